@@ -0,0 +1,312 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/programmablemike/fibo/internal/cache"
+	"github.com/programmablemike/fibo/internal/fibonacci"
+	"github.com/programmablemike/fibo/internal/router"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manages the memoizer cache",
+	Long:  `Manages the memoizer cache`,
+}
+
+var cacheExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Exports the memoizer cache entries",
+	Long: `Exports the memoizer cache entries as jsonl, csv or binary.
+Entries are written to stdout unless --output is given.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		format := exportFormatFlag(cmd)
+		output, _ := cmd.Flags().GetString("output")
+
+		uri := apiURL("/v1/cache/export?format=" + url.QueryEscape(string(format)))
+		res, err := apiClient.Get(uri)
+		if err != nil {
+			log.Fatalf("error: %s\n", err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			decodeResponse(res, nil)
+		}
+
+		var w io.Writer = os.Stdout
+		if output != "" {
+			f, err := os.Create(output)
+			if err != nil {
+				log.Fatalf("error: %s\n", err)
+			}
+			defer f.Close()
+			w = f
+		}
+		if _, err := io.Copy(w, res.Body); err != nil {
+			log.Fatalf("error: export was interrupted, %s\n", err)
+		}
+	},
+}
+
+var cacheImportCmd = &cobra.Command{
+	Use:   "import [FILE]",
+	Short: "Imports memoizer cache entries",
+	Long: `Imports memoizer cache entries that were created with "fibo cache export".
+Entries are read from stdin unless FILE is given.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format := exportFormatFlag(cmd)
+
+		var r io.Reader = os.Stdin
+		if len(args) > 0 {
+			f, err := os.Open(args[0])
+			if err != nil {
+				log.Fatalf("error: %s\n", err)
+			}
+			defer f.Close()
+			r = f
+		}
+
+		res, err := apiRequest("POST", "/v1/cache/import?format="+url.QueryEscape(string(format)), format.ContentType(), r)
+		if err != nil {
+			log.Fatalf("error: %s\n", err)
+		}
+		defer res.Body.Close()
+
+		v := router.Import{}
+		decodeResponse(res, &v)
+		fmt.Printf("Imported cache entries: %d\n", v.Imported)
+	},
+}
+
+var cacheVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verifies the memoizer cache entries",
+	Long: `Verifies that every memoizer cache entry holds the correct Fibonacci number.
+Entries can be checked against the recurrence F(n) = F(n-1) + F(n-2), against a
+modular fingerprint of F(n), or both. Bad entries can be repaired or evicted.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		method, _ := cmd.Flags().GetString("method")
+		action, _ := cmd.Flags().GetString("action")
+
+		query := url.Values{}
+		query.Set("method", method)
+		query.Set("action", action)
+		res, err := apiRequest("POST", "/v1/cache/verify?"+query.Encode(), "", nil)
+		if err != nil {
+			log.Fatalf("error: %s\n", err)
+		}
+		defer res.Body.Close()
+
+		v := router.Verification{}
+		decodeResponse(res, &v)
+		fmt.Printf("Checked cache entries: %d\n", v.Checked)
+		fmt.Printf("Bad cache entries: %d\n", len(v.Bad))
+		for _, ordinal := range v.Bad {
+			fmt.Printf("  ordinal=%d\n", ordinal)
+		}
+		if v.Repaired > 0 {
+			fmt.Printf("Repaired cache entries: %d\n", v.Repaired)
+		}
+		if v.Evicted > 0 {
+			fmt.Printf("Evicted cache entries: %d\n", v.Evicted)
+		}
+		if len(v.Bad) > 0 && action == string(cache.ActionReport) {
+			os.Exit(1)
+		}
+	},
+}
+
+var cacheWarmCmd = &cobra.Command{
+	Use:   "warm",
+	Short: "Precomputes memoizer cache entries",
+	Long: `Precomputes and stores Fibonacci numbers up to the ordinal --to in the background.
+With --step K only checkpoint pairs every K ordinals are stored instead of every value.
+Progress is reported until the warm-up finishes unless --detach is given.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		to, _ := cmd.Flags().GetUint64("to")
+		step, _ := cmd.Flags().GetUint64("step")
+		detach, _ := cmd.Flags().GetBool("detach")
+
+		query := url.Values{}
+		query.Set("to", strconv.FormatUint(to, 10))
+		query.Set("step", strconv.FormatUint(step, 10))
+		res, err := apiRequest("POST", "/v1/cache/warm?"+query.Encode(), "", nil)
+		if err != nil {
+			log.Fatalf("error: %s\n", err)
+		}
+		v := router.WarmUp{}
+		decodeResponse(res, &v)
+		res.Body.Close()
+		fmt.Printf("Warming the cache up to ordinal %d (step %d)\n", v.To, v.Step)
+		if detach {
+			return
+		}
+
+		for v.Running {
+			time.Sleep(time.Second)
+			res, err := apiRequest("GET", "/v1/cache/warm", "", nil)
+			if err != nil {
+				log.Fatalf("error: %s\n", err)
+			}
+			decodeResponse(res, &v)
+			res.Body.Close()
+			if v.Error != "" {
+				log.Fatalf("error: %s\n", v.Error)
+			}
+			fmt.Printf("Progress: %d/%d (%.1f%%)\n", v.Done, v.To, 100*float64(v.Done)/float64(v.To+1))
+		}
+		if v.Started != nil && v.Finished != nil {
+			fmt.Printf("Warmed the cache in %s\n", v.Finished.Sub(*v.Started).Round(time.Millisecond))
+		}
+	},
+}
+
+var cacheListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the memoizer cache entries",
+	Long: `Lists the memoizer cache entries in ascending ordinal order without their values.
+Entries are listed one page of --limit entries at a time starting at --from, or all of
+them with --all. The size is the number of bytes taken by the stored value.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		from, _ := cmd.Flags().GetUint64("from")
+		limit, _ := cmd.Flags().GetInt("limit")
+		all, _ := cmd.Flags().GetBool("all")
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "ORDINAL\tSIZE\tCREATED\tUPDATED")
+		for {
+			query := url.Values{}
+			query.Set("from", strconv.FormatUint(from, 10))
+			query.Set("limit", strconv.Itoa(limit))
+			res, err := apiRequest("GET", "/v1/cache/entries?"+query.Encode(), "", nil)
+			if err != nil {
+				log.Fatalf("error: %s\n", err)
+			}
+			v := router.CacheEntries{}
+			decodeResponse(res, &v)
+			res.Body.Close()
+			for _, e := range v.Entries {
+				fmt.Fprintf(tw, "%d\t%d\t%s\t%s\n", e.Ordinal, e.Size, timeText(e.CreatedAt), timeText(e.UpdatedAt))
+			}
+			if v.Next == nil {
+				break
+			}
+			if !all {
+				tw.Flush()
+				fmt.Printf("More entries: fibo cache list --from %d\n", *v.Next)
+				return
+			}
+			from = *v.Next
+		}
+		tw.Flush()
+	},
+}
+
+var cacheGetCmd = &cobra.Command{
+	Use:   "get N",
+	Short: "Shows a memoizer cache entry",
+	Long: `Shows how the value of the ordinal N is stored in the memoizer cache and the value itself.
+Unlike "fibo calculate" it never computes the value, it fails if N isn't cached.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ordinal, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			log.Fatalf("error: failed to parse ordinal value, %s\n", err)
+		}
+		format := numberFormatFlag(cmd)
+
+		path := fmt.Sprintf("/v1/cache/entries/%d?format=%s", ordinal, url.QueryEscape(format.String()))
+		res, err := apiRequest("GET", path, "", nil)
+		if err != nil {
+			log.Fatalf("error: %s\n", err)
+		}
+		defer res.Body.Close()
+
+		v := router.CacheEntry{}
+		decodeResponse(res, &v)
+		fmt.Printf("Ordinal: %d\n", v.Ordinal)
+		fmt.Printf("Size: %d bytes\n", v.Size)
+		if v.CreatedAt != nil {
+			fmt.Printf("Created: %s\n", timeText(v.CreatedAt))
+		}
+		if v.UpdatedAt != nil {
+			fmt.Printf("Updated: %s\n", timeText(v.UpdatedAt))
+		}
+		fmt.Printf("Value: %s\n", valueText(v.Value))
+	},
+}
+
+var cacheStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Summarizes the memoizer cache",
+	Long: `Shows the number of live and tombstoned records, the space taken by the cache and the
+largest cached ordinal.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		res, err := apiRequest("GET", "/v1/cache/stats", "", nil)
+		if err != nil {
+			log.Fatalf("error: %s\n", err)
+		}
+		defer res.Body.Close()
+
+		v := router.CacheStats{}
+		decodeResponse(res, &v)
+		fmt.Printf("Entries: %d\n", v.Entries)
+		fmt.Printf("Tombstoned: %d\n", v.Tombstoned)
+		fmt.Printf("Bytes: %d\n", v.Bytes)
+		if v.MaxOrdinal != nil {
+			fmt.Printf("Largest ordinal: %d\n", *v.MaxOrdinal)
+		}
+	},
+}
+
+// timeText prints an optional timestamp, backends that don't record timestamps leave them unset
+func timeText(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
+// exportFormatFlag parses the --format flag and exits if it's invalid
+func exportFormatFlag(cmd *cobra.Command) cache.Format {
+	v, _ := cmd.Flags().GetString("format")
+	format, err := cache.ParseFormat(v)
+	if err != nil {
+		log.Fatalf("error: %s\n", err)
+	}
+	return format
+}
+
+func init() {
+	cacheExportCmd.Flags().String("format", string(cache.FormatJSONL), "Export format: jsonl, csv or binary")
+	cacheExportCmd.Flags().StringP("output", "o", "", "File to write the export to (default: stdout)")
+	cacheImportCmd.Flags().String("format", string(cache.FormatJSONL), "Import format: jsonl, csv or binary")
+	cacheVerifyCmd.Flags().String("method", string(cache.VerifyBoth), "Verification method: recurrence, fingerprint or both")
+	cacheVerifyCmd.Flags().String("action", string(cache.ActionReport), "What to do with bad entries: report, repair or evict")
+	cacheWarmCmd.Flags().Uint64("to", 0, "Highest ordinal to precompute")
+	cacheWarmCmd.Flags().Uint64("step", 1, "Store checkpoint pairs every step ordinals instead of every value")
+	cacheWarmCmd.Flags().Bool("detach", false, "Start the warm-up without waiting for it to finish")
+	cacheWarmCmd.MarkFlagRequired("to")
+	cacheListCmd.Flags().Uint64("from", 0, "First ordinal to list")
+	cacheListCmd.Flags().Int("limit", 100, "Number of entries per page, up to 1000")
+	cacheListCmd.Flags().Bool("all", false, "List every page")
+	cacheGetCmd.Flags().String("format", string(fibonacci.FormatDecimal), "Output format: decimal, hex, base:N, bytes, sci[:DIGITS] or grouped[:SEPARATOR]")
+
+	cacheCmd.AddCommand(cacheExportCmd, cacheImportCmd, cacheVerifyCmd, cacheWarmCmd, cacheListCmd, cacheGetCmd, cacheStatsCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/programmablemike/fibo/internal/jobs"
+	"github.com/programmablemike/fibo/internal/router"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var jobCmd = &cobra.Command{
+	Use:   "job",
+	Short: "Manages asynchronous jobs",
+	Long: `Manages asynchronous jobs
+Large computations run in the background on the server instead of holding a
+connection open until they finish.`,
+}
+
+var jobSubmitCmd = &cobra.Command{
+	Use:   "submit KIND",
+	Short: "Submits a calculate, count, sequence or warm job",
+	Long: `Submits a job and prints its ID
+  calculate needs --ordinal
+  count needs --number
+  sequence needs --from and --to
+  warm needs --to and accepts --step
+With --wait the job is polled until it finishes and the result is printed.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		job := jobs.Job{Kind: jobs.Kind(args[0])}
+		job.Ordinal, _ = cmd.Flags().GetUint64("ordinal")
+		job.Number, _ = cmd.Flags().GetString("number")
+		job.From, _ = cmd.Flags().GetUint64("from")
+		job.To, _ = cmd.Flags().GetUint64("to")
+		job.Step, _ = cmd.Flags().GetUint64("step")
+		wait, _ := cmd.Flags().GetBool("wait")
+
+		c := responseCodec()
+		var body bytes.Buffer
+		if err := c.Encode(&body, job); err != nil {
+			log.Fatalf("error: %s\n", err)
+		}
+		res, err := apiRequest("POST", "/v1/jobs", c.ContentType(), &body)
+		if err != nil {
+			log.Fatalf("error: %s\n", err)
+		}
+		v := router.JobStatus{}
+		decodeResponse(res, &v)
+		res.Body.Close()
+		fmt.Printf("Job ID: %s\n", v.ID)
+		if !wait {
+			return
+		}
+
+		for !v.State.Finished() {
+			time.Sleep(time.Second)
+			v = getJob(v.ID)
+			fmt.Printf("Progress: %.1f%%\n", 100*v.Progress)
+		}
+		if v.State == jobs.StateFailed {
+			log.Fatalf("error: job failed, %s\n", v.Error)
+		}
+		if v.State != jobs.StateSucceeded {
+			log.Fatalf("error: job %s\n", v.State)
+		}
+		printJobResult(v.ID)
+	},
+}
+
+var jobStatusCmd = &cobra.Command{
+	Use:   "status ID",
+	Short: "Shows the state and progress of a job",
+	Long:  `Shows the state and progress of a job`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		v := getJob(args[0])
+		fmt.Printf("Kind: %s\n", v.Kind)
+		fmt.Printf("State: %s\n", v.State)
+		fmt.Printf("Progress: %.1f%%\n", 100*v.Progress)
+		if v.Error != "" {
+			fmt.Printf("Error: %s\n", v.Error)
+		}
+		if v.ResultURL != "" {
+			fmt.Printf("Result: %s\n", apiURL(v.ResultURL))
+		}
+	},
+}
+
+var jobResultCmd = &cobra.Command{
+	Use:   "result ID",
+	Short: "Prints the result of a finished job",
+	Long:  `Prints the result of a finished job`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		printJobResult(args[0])
+	},
+}
+
+var jobCancelCmd = &cobra.Command{
+	Use:   "cancel ID",
+	Short: "Cancels a pending or running job",
+	Long:  `Cancels a pending or running job`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		res, err := apiRequest("DELETE", "/v1/jobs/"+args[0], "", nil)
+		if err != nil {
+			log.Fatalf("error: %s\n", err)
+		}
+		defer res.Body.Close()
+		v := router.JobStatus{}
+		decodeResponse(res, &v)
+		fmt.Printf("Cancelled job %s\n", v.ID)
+	},
+}
+
+// getJob fetches the state of a job and exits if the server reported an error
+func getJob(id string) router.JobStatus {
+	res, err := apiRequest("GET", "/v1/jobs/"+id, "", nil)
+	if err != nil {
+		log.Fatalf("error: %s\n", err)
+	}
+	defer res.Body.Close()
+	v := router.JobStatus{}
+	decodeResponse(res, &v)
+	return v
+}
+
+// printJobResult streams the result of a job to stdout
+func printJobResult(id string) {
+	res, err := apiClient.Get(apiURL("/v1/jobs/" + id + "/result"))
+	if err != nil {
+		log.Fatalf("error: %s\n", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		decodeResponse(res, nil)
+	}
+	if _, err := io.Copy(os.Stdout, res.Body); err != nil {
+		log.Fatalf("error: %s\n", err)
+	}
+	fmt.Println()
+}
+
+func init() {
+	jobSubmitCmd.Flags().Uint64("ordinal", 0, "Ordinal to calculate")
+	jobSubmitCmd.Flags().String("number", "", "Fibonacci value to count the ordinals up to")
+	jobSubmitCmd.Flags().Uint64("from", 0, "First ordinal of the sequence")
+	jobSubmitCmd.Flags().Uint64("to", 0, "Last ordinal of the sequence or warm-up")
+	jobSubmitCmd.Flags().Uint64("step", 1, "Store checkpoint pairs every step ordinals when warming")
+	jobSubmitCmd.Flags().Bool("wait", false, "Wait for the job to finish and print its result")
+	jobCmd.AddCommand(jobSubmitCmd, jobStatusCmd, jobResultCmd, jobCancelCmd)
+	rootCmd.AddCommand(jobCmd)
+}
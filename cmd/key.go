@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/programmablemike/fibo/internal/auth"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var keyCmd = &cobra.Command{
+	Use:   "key",
+	Short: "Manages the API keys of the server",
+	Long:  `Manages the API keys of the server`,
+}
+
+var keyCreateCmd = &cobra.Command{
+	Use:   "create NAME",
+	Short: "Creates an API key",
+	Long: `Creates an API key and prints its token and its key file line
+The token is only printed once, the key file only holds its SHA-256 hash. Add the
+line to the file given to "fibo server --api-key-file" and hand the token to the
+client, which sends it from the token setting of .fiborc or from $FIBO_TOKEN.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		v, _ := cmd.Flags().GetString("scopes")
+		scopes, err := auth.ParseScopes(v)
+		if err != nil {
+			log.Fatalf("error: %s\n", err)
+		}
+		if len(scopes) == 0 {
+			log.Fatalf("error: the key needs at least one scope\n")
+		}
+		token, err := auth.NewToken()
+		if err != nil {
+			log.Fatalf("error: %s\n", err)
+		}
+		fmt.Fprintf(os.Stderr, "Token (only shown once): %s\n", token)
+		fmt.Println(auth.KeyFileLine(args[0], token, scopes))
+	},
+}
+
+func init() {
+	keyCreateCmd.Flags().String("scopes", string(auth.ScopeRead), "Comma separated scopes: read, compute-large or admin (default: read)")
+	keyCmd.AddCommand(keyCreateCmd)
+	rootCmd.AddCommand(keyCmd)
+}
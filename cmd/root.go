@@ -1,13 +1,22 @@
 package cmd
 
 import (
-	"encoding/json"
+	"bytes"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 
 	homedir "github.com/mitchellh/go-homedir"
+	"github.com/programmablemike/fibo/internal/codec"
+	"github.com/programmablemike/fibo/internal/fibonacci"
+	"github.com/programmablemike/fibo/internal/logging"
 	"github.com/programmablemike/fibo/internal/router"
+	"github.com/programmablemike/fibo/internal/tlsconfig"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -18,27 +27,142 @@ var (
 )
 
 var calculateCmd = &cobra.Command{
-	Use:   "calculate N",
-	Short: "Calculates the Fibonacci number for the given ordinal N",
-	Long:  `Calculates the Fibonacci number for the given ordinal N`,
-	Args:  cobra.MinimumNArgs(1),
+	Use:   "calculate N [N | FROM-TO]...",
+	Short: "Calculates the Fibonacci numbers for the given ordinals",
+	Long: `Calculates the Fibonacci numbers for the given ordinals
+A single value is streamed from the server so even values with millions of digits
+can be written to stdout or to a file with --output. Several ordinals and
+FROM-TO ranges are calculated as one batch and printed in ascending order.`,
+	Args: cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		host := viper.GetString("host")
-		port := viper.GetInt("port")
+		output, _ := cmd.Flags().GetString("output")
+		format := numberFormatFlag(cmd)
+		if len(args) > 1 || strings.Contains(args[0], "-") {
+			calculateBatch(args, format, output)
+			return
+		}
 
-		uri := fmt.Sprintf("http://%s:%d/fibo/calculate/%s", host, port, args[0])
-		res, err := http.Get(uri)
+		uri := apiURL(fmt.Sprintf("/v1/fibonacci/%s?format=%s", args[0], url.QueryEscape(format.String())))
+		req, err := http.NewRequest("GET", uri, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// The value is streamed as text unless another response encoding was requested
+		c := responseCodec()
+		req.Header.Set("Accept", "text/plain")
+		if c != codec.JSON {
+			req.Header.Set("Accept", c.ContentType())
+		}
+		res, err := apiClient.Do(req)
 		if err != nil {
 			log.Fatalf("error: %s\n", err)
 		}
 		defer res.Body.Close()
+		var body io.Reader = res.Body
+		if res.StatusCode != http.StatusOK || c != codec.JSON {
+			v := router.Value{}
+			decodeResponse(res, &v)
+			body = strings.NewReader(valueText(v.Value) + "\n")
+		}
+
+		var w io.Writer = os.Stdout
+		if output != "" {
+			f, err := os.Create(output)
+			if err != nil {
+				log.Fatalf("error: %s\n", err)
+			}
+			defer f.Close()
+			w = f
+		} else if !format.Binary() {
+			fmt.Print("Fibonacci number: ")
+		}
+		if _, err := io.Copy(w, body); err != nil {
+			log.Fatalf("error: the value was interrupted, %s\n", err)
+		}
+	},
+}
 
-		v := router.GenericResponse{}
-		err = json.NewDecoder(res.Body).Decode(&v)
+// calculateBatch sends the ordinals and FROM-TO ranges as one batch and prints a value per line
+func calculateBatch(args []string, format fibonacci.NumberFormat, output string) {
+	batch := router.BatchRequest{}
+	for _, arg := range args {
+		if i := strings.Index(arg, "-"); i >= 0 {
+			from, err := strconv.ParseUint(arg[:i], 10, 64)
+			if err != nil {
+				log.Fatalf("error: invalid range %q\n", arg)
+			}
+			to, err := strconv.ParseUint(arg[i+1:], 10, 64)
+			if err != nil {
+				log.Fatalf("error: invalid range %q\n", arg)
+			}
+			batch.Ranges = append(batch.Ranges, router.Range{From: from, To: to})
+			continue
+		}
+		ordinal, err := strconv.ParseUint(arg, 10, 64)
 		if err != nil {
-			log.Fatalf("error: failed to decode res.Body, %s\n", err)
+			log.Fatalf("error: invalid ordinal %q\n", arg)
+		}
+		batch.Ordinals = append(batch.Ordinals, ordinal)
+	}
+	c := responseCodec()
+	var body bytes.Buffer
+	if err := c.Encode(&body, batch); err != nil {
+		log.Fatalf("error: %s\n", err)
+	}
+	res, err := apiRequest("POST", "/v1/fibonacci?format="+url.QueryEscape(format.String()), c.ContentType(), &body)
+	if err != nil {
+		log.Fatalf("error: %s\n", err)
+	}
+	defer res.Body.Close()
+	v := router.Batch{}
+	decodeResponse(res, &v)
+
+	var w io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			log.Fatalf("error: %s\n", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	for _, value := range v.Values {
+		fmt.Fprintf(w, "F(%d) = %s\n", value.Ordinal, valueText(value.Value))
+	}
+}
+
+var sequenceCmd = &cobra.Command{
+	Use:   "sequence FROM TO",
+	Short: "Prints the Fibonacci numbers for the ordinals FROM to TO",
+	Long: `Prints the Fibonacci numbers for the ordinals FROM to TO, one per line
+The bytes format is base64 encoded. Use "fibo job submit sequence" for long ranges.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		format := numberFormatFlag(cmd)
+
+		uri := apiURL(fmt.Sprintf("/v1/sequence/%s/%s?stream=1&format=%s", args[0], args[1], url.QueryEscape(format.String())))
+		res, err := apiClient.Get(uri)
+		if err != nil {
+			log.Fatalf("error: %s\n", err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			decodeResponse(res, nil)
+		}
+
+		var w io.Writer = os.Stdout
+		if output != "" {
+			f, err := os.Create(output)
+			if err != nil {
+				log.Fatalf("error: %s\n", err)
+			}
+			defer f.Close()
+			w = f
+		}
+		if _, err := io.Copy(w, res.Body); err != nil {
+			log.Fatalf("error: the sequence was interrupted, %s\n", err)
 		}
-		fmt.Printf("Fibonacci number: %s\n", v.Value)
 	},
 }
 
@@ -48,22 +172,15 @@ var countCmd = &cobra.Command{
 	Long:  `Counts the number of ordinals in the Fibonacci value range (0, NUM)`,
 	Args:  cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		host := viper.GetString("host")
-		port := viper.GetInt("port")
-
-		uri := fmt.Sprintf("http://%s:%d/fibo/count/%s", host, port, args[0])
-		res, err := http.Get(uri)
+		res, err := apiRequest("GET", "/v1/count/"+args[0], "", nil)
 		if err != nil {
 			log.Fatalf("error: %s\n", err)
 		}
 		defer res.Body.Close()
 
-		v := router.GenericResponse{}
-		err = json.NewDecoder(res.Body).Decode(&v)
-		if err != nil {
-			log.Fatalf("error: failed to decode res.Body, %s\n", err)
-		}
-		fmt.Printf("Ordinals in this range: %s\n", v.Value)
+		v := router.Count{}
+		decodeResponse(res, &v)
+		fmt.Printf("Ordinals in this range: %d\n", v.Count)
 	},
 }
 
@@ -72,34 +189,13 @@ var clearCmd = &cobra.Command{
 	Short: "Clears the memoizer cache",
 	Long:  `Clears the memoizer cache`,
 	Run: func(cmd *cobra.Command, args []string) {
-		host := viper.GetString("host")
-		port := viper.GetInt("port")
-
-		uri := fmt.Sprintf("http://%s:%d/fibo/cache", host, port)
-
-		// Create client
-		client := &http.Client{}
-
-		// Create request
-		req, err := http.NewRequest("DELETE", uri, nil)
-		if err != nil {
-			log.Fatal(err)
-			return
-		}
-
-		// Fetch Request
-		res, err := client.Do(req)
+		res, err := apiRequest("DELETE", "/v1/cache", "", nil)
 		if err != nil {
-			log.Fatal(err)
-			return
+			log.Fatalf("error: %s\n", err)
 		}
 		defer res.Body.Close()
 
-		v := router.GenericResponse{}
-		err = json.NewDecoder(res.Body).Decode(&v)
-		if err != nil {
-			log.Fatalf("error: failed to decode res.Body, %s\n", err)
-		}
+		decodeResponse(res, nil)
 		fmt.Println("Successfully cleared cache")
 	},
 }
@@ -109,6 +205,13 @@ var rootCmd = &cobra.Command{
 	Short: "Fibo is an API server and CLI client for generating Fibonacci sequences",
 	Long: `Fibo is an API server and CLI client for generating Fibonacci sequences
 It uses dynamic programming techniques (memoization) to speed up processing.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// The server binds host and port to its own flags, the client commands to the root ones
+		if cmd != serverCmd {
+			viper.BindPFlag("host", cmd.Root().PersistentFlags().Lookup("host"))
+			viper.BindPFlag("port", cmd.Root().PersistentFlags().Lookup("port"))
+		}
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		// Print the command line options for debugging purposes
 		log.Debugf("host: %s", viper.GetString("host"))
@@ -116,6 +219,148 @@ It uses dynamic programming techniques (memoization) to speed up processing.`,
 	},
 }
 
+// numberFormatFlag parses the --format flag and exits if it's invalid
+func numberFormatFlag(cmd *cobra.Command) fibonacci.NumberFormat {
+	v, _ := cmd.Flags().GetString("format")
+	format, err := fibonacci.ParseNumberFormat(v)
+	if err != nil {
+		log.Fatalf("error: %s\n", err)
+	}
+	return format
+}
+
+// responseCodec returns the response encoding selected with --encoding and exits if it's invalid
+func responseCodec() codec.Codec {
+	c, err := codec.ByName(viper.GetString("encoding"))
+	if err != nil {
+		log.Fatalf("error: %s\n", err)
+	}
+	return c
+}
+
+// apiRequest sends a request to the API server asking for the response encoding selected with --encoding
+func apiRequest(method string, path string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, apiURL(path), body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", responseCodec().ContentType())
+	return apiClient.Do(req)
+}
+
+// apiClient sends the API token from .fiborc or $FIBO_TOKEN with every request
+var apiClient = &http.Client{Transport: tokenTransport{}}
+
+// tokenTransport adds the API token to the requests as a bearer token
+type tokenTransport struct{}
+
+func (tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if token := viper.GetString("token"); token != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return clientTransport().RoundTrip(req)
+}
+
+var (
+	transportOnce sync.Once
+	transport     http.RoundTripper
+)
+
+// clientTransport returns the transport of the API requests, it trusts the CAs of the ca-cert option
+// and presents the certificate of the client-cert and client-key options
+func clientTransport() http.RoundTripper {
+	transportOnce.Do(func() {
+		cfg, err := tlsconfig.NewClientConfig(tlsconfig.ClientOptions{
+			CAFile:   viper.GetString("ca_cert"),
+			CertFile: viper.GetString("client_cert"),
+			KeyFile:  viper.GetString("client_key"),
+		})
+		if err != nil {
+			log.Fatalf("error: %s\n", err)
+		}
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = cfg
+		transport = t
+	})
+	return transport
+}
+
+// apiURL builds the URL of an API server route from the url option, or from the host and port options
+func apiURL(path string) string {
+	if base := viper.GetString("url"); base != "" {
+		return strings.TrimRight(base, "/") + path
+	}
+	return fmt.Sprintf("http://%s:%d%s", viper.GetString("host"), viper.GetInt("port"), path)
+}
+
+// exitStatuses are the exit statuses for the error codes reported by the server, every other
+// failure exits with 1
+var exitStatuses = map[router.ErrorCode]int{
+	router.CodeInvalidRequest:     2,
+	router.CodeInvalidOrdinal:     3,
+	router.CodeOrdinalTooLarge:    4,
+	router.CodeNotFound:           5,
+	router.CodeConflict:           6,
+	router.CodeNotImplemented:     7,
+	router.CodeBackendUnavailable: 8,
+	router.CodeTimeout:            9,
+	router.CodeRateLimited:        10,
+	router.CodeInternal:           11,
+	router.CodeUnauthorized:       12,
+	router.CodeForbidden:          13,
+	router.CodeSequenceTooLong:    14,
+}
+
+// decodeResponse decodes the response body of a /v1 route into v and exits if the server reported
+// an error
+// The body is decoded with the codec for its Content-Type. v can be nil when there's no payload.
+func decodeResponse(res *http.Response, v interface{}) {
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		log.Fatalf("error: failed to read res.Body, %s\n", err)
+	}
+	c := codec.ForContentType(res.Header.Get("Content-Type"))
+	if res.StatusCode >= http.StatusBadRequest {
+		p := router.Problem{}
+		if err := codec.Unmarshal(c, body, &p); err != nil || p.Code == "" {
+			log.Fatalf("error: %s\n", res.Status)
+		}
+		exitWithProblem(p)
+	}
+	if v == nil {
+		return
+	}
+	if err := codec.Unmarshal(c, body, v); err != nil {
+		log.Fatalf("error: failed to decode res.Body, %s\n", err)
+	}
+}
+
+// exitWithProblem logs a problem reported by the server and exits with the status for its code
+func exitWithProblem(p router.Problem) {
+	message := p.Detail
+	if message == "" {
+		message = p.Title
+	}
+	log.Errorf("error: %s (%s)\n", message, p.Code)
+	status, ok := exitStatuses[p.Code]
+	if !ok {
+		status = 1
+	}
+	os.Exit(status)
+}
+
+// valueText prints a decoded router.Value, small decimal values are JSON numbers
+func valueText(v interface{}) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -127,13 +372,29 @@ func init() {
 	cobra.OnInitialize(initConfig)
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.fiborc)")
 	rootCmd.PersistentFlags().Bool("debug", false, "Turns on debugging mode")
+	rootCmd.PersistentFlags().String("log-format", string(logging.FormatText), "Log format: text or json (default: text)")
+	rootCmd.PersistentFlags().String("log-level", "info", "Log level: trace, debug, info, warn or error (default: info)")
 	rootCmd.PersistentFlags().String("host", "localhost", "HTTP server hostname to bind (default: localhost)")
 	rootCmd.PersistentFlags().Int("port", 8080, "HTTP server port to bind (default: 8080)")
-	rootCmd.AddCommand(calculateCmd, countCmd, clearCmd)
-	viper.BindPFlag("host", rootCmd.PersistentFlags().Lookup("host"))
-	viper.BindPFlag("port", rootCmd.PersistentFlags().Lookup("port"))
+	rootCmd.PersistentFlags().String("url", "", "Base URL of the API server, use https:// for TLS (default: http://HOST:PORT)")
+	rootCmd.PersistentFlags().String("ca-cert", "", "PEM file of the CAs that sign the server certificate (default: the system CAs)")
+	rootCmd.PersistentFlags().String("client-cert", "", "PEM client certificate file for servers that require mutual TLS (default: none)")
+	rootCmd.PersistentFlags().String("client-key", "", "PEM private key file of the client certificate (default: none)")
+	rootCmd.PersistentFlags().String("encoding", codec.JSON.Name(), "API response encoding: json, cbor or msgpack (default: json)")
+	calculateCmd.Flags().StringP("output", "o", "", "File to write the value to (default: stdout)")
+	calculateCmd.Flags().String("format", string(fibonacci.FormatDecimal), "Output format: decimal, hex, base:N, bytes, sci[:DIGITS] or grouped[:SEPARATOR]")
+	sequenceCmd.Flags().StringP("output", "o", "", "File to write the values to (default: stdout)")
+	sequenceCmd.Flags().String("format", string(fibonacci.FormatDecimal), "Output format: decimal, hex, base:N, bytes, sci[:DIGITS] or grouped[:SEPARATOR]")
+	rootCmd.AddCommand(calculateCmd, sequenceCmd, countCmd, clearCmd)
+	viper.BindPFlag("url", rootCmd.PersistentFlags().Lookup("url"))
+	viper.BindPFlag("ca_cert", rootCmd.PersistentFlags().Lookup("ca-cert"))
+	viper.BindPFlag("client_cert", rootCmd.PersistentFlags().Lookup("client-cert"))
+	viper.BindPFlag("client_key", rootCmd.PersistentFlags().Lookup("client-key"))
 	viper.BindPFlag("useViper", rootCmd.PersistentFlags().Lookup("viper"))
 	viper.BindPFlag("debug", rootCmd.PersistentFlags().Lookup("debug"))
+	viper.BindPFlag("log_format", rootCmd.PersistentFlags().Lookup("log-format"))
+	viper.BindPFlag("log_level", rootCmd.PersistentFlags().Lookup("log-level"))
+	viper.BindPFlag("encoding", rootCmd.PersistentFlags().Lookup("encoding"))
 }
 
 func initConfig() {
@@ -175,10 +436,18 @@ func initConfig() {
 		fmt.Println("Falling back to command-line defaults.")
 	}
 
+	format, err := logging.ParseFormat(viper.GetString("log_format"))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	level := viper.GetString("log_level")
 	// Turn on debug is toggled
 	if viper.GetBool("debug") {
-		log.SetLevel(log.DebugLevel)
-	} else {
-		log.SetLevel(log.InfoLevel)
+		level = log.DebugLevel.String()
+	}
+	if err := logging.Configure(format, level); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
 }
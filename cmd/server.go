@@ -1,32 +1,143 @@
 package cmd
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/programmablemike/fibo/internal/auth"
 	"github.com/programmablemike/fibo/internal/cache"
 	"github.com/programmablemike/fibo/internal/fibonacci"
+	"github.com/programmablemike/fibo/internal/jobs"
+	"github.com/programmablemike/fibo/internal/logging"
+	"github.com/programmablemike/fibo/internal/metrics"
+	"github.com/programmablemike/fibo/internal/ratelimit"
 	"github.com/programmablemike/fibo/internal/router"
+	"github.com/programmablemike/fibo/internal/rpc"
+	"github.com/programmablemike/fibo/internal/tlsconfig"
+	"github.com/programmablemike/fibo/internal/tracing"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 func init() {
 	serverCmd.PersistentFlags().String("host", "", "HTTP server hostname to bind (default: *)")
 	serverCmd.PersistentFlags().Int("port", 8080, "HTTP server port to bind (default: 8080)")
+	serverCmd.PersistentFlags().Int("grpc-port", 9090, "gRPC server port to bind, 0 disables the gRPC server (default: 9090)")
 	serverCmd.PersistentFlags().String("pguser", "fibo", "Postgres database user (default: fibo)")
 	serverCmd.PersistentFlags().String("pgpassword", "", "Postgres database password (default: \"\")")
 	serverCmd.PersistentFlags().String("pghost", "localhost", "Postgres database hostname (default: localhost)")
 	serverCmd.PersistentFlags().Int("pgport", 5432, "Postgres database port (default: 5432)")
 	serverCmd.PersistentFlags().String("pgdb", "fibo", "Postgres database name (default: fibo)")
+	serverCmd.PersistentFlags().Int("pg-chunk-threshold", cache.DefaultCacheOptions.ChunkThreshold, "Postgres values with more digits than this are stored in chunks, 0 never chunks (default: 1048576)")
+	serverCmd.PersistentFlags().Int("pg-chunk-size", cache.DefaultCacheOptions.ChunkSize, "Number of digits per Postgres value chunk (default: 262144)")
+	serverCmd.PersistentFlags().String("cache", "postgres", "Memoizer backend to use: postgres, file or redis (default: postgres)")
+	serverCmd.PersistentFlags().String("cache-dir", "/var/lib/fibo", "Directory for the file cache log (default: /var/lib/fibo)")
+	serverCmd.PersistentFlags().String("cache-fsync", string(cache.SyncInterval), "File cache fsync policy: always, interval or never (default: interval)")
+	serverCmd.PersistentFlags().Duration("cache-fsync-interval", cache.DefaultFileCacheOptions.SyncInterval, "File cache fsync interval (default: 1s)")
+	serverCmd.PersistentFlags().Duration("cache-compact-interval", cache.DefaultFileCacheOptions.CompactInterval, "File cache compaction check interval, 0 disables compaction (default: 5m)")
+	serverCmd.PersistentFlags().Float64("cache-compact-ratio", cache.DefaultFileCacheOptions.CompactRatio, "Fraction of stale file cache records that triggers compaction (default: 0.5)")
+	serverCmd.PersistentFlags().String("redis-addr", cache.DefaultRedisCacheOptions.Addr, "RESP server address for the redis cache (default: localhost:6379)")
+	serverCmd.PersistentFlags().String("redis-password", "", "RESP server password (default: \"\")")
+	serverCmd.PersistentFlags().Int("redis-db", 0, "RESP server logical database (default: 0)")
+	serverCmd.PersistentFlags().String("redis-prefix", cache.DefaultRedisCacheOptions.Prefix, "Prefix for keys written to the RESP server (default: fibo:)")
+	serverCmd.PersistentFlags().Duration("redis-ttl", 0, "Expiry of redis cache entries, 0 never expires (default: 0)")
+	serverCmd.PersistentFlags().Int("redis-pool-size", cache.DefaultRedisCacheOptions.PoolSize, "Maximum open RESP server connections, 0 opens up to 10 per CPU (default: 0)")
+	serverCmd.PersistentFlags().Duration("redis-dial-timeout", cache.DefaultRedisCacheOptions.DialTimeout, "Timeout for connecting to the RESP server (default: 5s)")
+	serverCmd.PersistentFlags().Duration("redis-read-timeout", cache.DefaultRedisCacheOptions.ReadTimeout, "Timeout for reading a RESP reply, 0 never times out (default: 3s)")
+	serverCmd.PersistentFlags().Duration("redis-write-timeout", cache.DefaultRedisCacheOptions.WriteTimeout, "Timeout for writing a RESP command, 0 never times out (default: 3s)")
+	serverCmd.PersistentFlags().Duration("scrub-interval", 0, "How often to verify the cache in the background, 0 disables scrubbing (default: 0)")
+	serverCmd.PersistentFlags().String("scrub-method", string(cache.VerifyFingerprint), "Scrubber verification method: recurrence, fingerprint or both (default: fingerprint)")
+	serverCmd.PersistentFlags().String("scrub-action", string(cache.ActionReport), "What the scrubber does with bad entries: report, repair or evict (default: report)")
+	serverCmd.PersistentFlags().Int("job-workers", 2, "Maximum number of asynchronous jobs that run at the same time (default: 2)")
+	serverCmd.PersistentFlags().Uint64("max-ordinal", 0, "Largest ordinal calculated while the client waits, larger ones need a job, 0 disables the limit (default: 0)")
+	serverCmd.PersistentFlags().Uint64("max-stream-length", router.DefaultMaxStreamLength, "Most values of a sequence stream, streams without a to ordinal end after them (default: 10000)")
+	serverCmd.PersistentFlags().StringSlice("ws-origins", nil, "Origins of the pages allowed to open WebSocket streams besides the server's own, * allows any (ex. https://dashboard.example.com)")
+	serverCmd.PersistentFlags().Duration("request-timeout", 0, "Deadline of calculations that run while the client waits, 0 disables the deadline (default: 0)")
+	serverCmd.PersistentFlags().String("trace-exporter", string(tracing.ExporterNone), "Where to export the trace spans: none, stdout or otlp (default: none)")
+	serverCmd.PersistentFlags().String("otlp-endpoint", "", "host:port of the OTLP gRPC collector (default: $OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4317)")
+	serverCmd.PersistentFlags().Bool("otlp-insecure", false, "Send the spans to the OTLP collector without TLS (default: false)")
+	serverCmd.PersistentFlags().Float64("trace-sample-ratio", 1, "Fraction of the traces started by the server that are sampled (default: 1)")
+	serverCmd.PersistentFlags().Float64("rate-limit", 0, "Tokens per second refilled in the bucket of every client, 0 disables rate limiting (default: 0)")
+	serverCmd.PersistentFlags().Float64("rate-limit-burst", 0, "Tokens the bucket of a client holds (default: the rate, at least 1)")
+	serverCmd.PersistentFlags().Float64("rate-limit-digits-per-token", 100000, "Decimal digits of the requested values that cost one more token, 0 makes every request cost 1 token (default: 100000)")
+	serverCmd.PersistentFlags().StringSlice("rate-limit-route", nil, "ROUTE=RATE[:BURST] limits of route templates or gRPC methods with their own buckets, a 0 rate doesn't limit the route (ex. /v1/fibonacci/{ordinal}=5:20)")
+	serverCmd.PersistentFlags().StringSlice("api-key", nil, "NAME:TOKEN:SCOPES API keys, scopes are read, compute-large or admin joined with + (ex. ci:s3cr3t:read+compute-large)")
+	serverCmd.PersistentFlags().String("api-key-file", "", "File of hashed API keys created with \"fibo key create\" (default: none)")
+	serverCmd.PersistentFlags().StringSlice("anonymous-scopes", nil, "Scopes of requests without an API key once keys are configured (default: none)")
+	serverCmd.PersistentFlags().Uint64("large-ordinal", 100000, "Largest ordinal calculated with the read scope, larger ones need compute-large, 0 disables the limit (default: 100000)")
+	serverCmd.PersistentFlags().String("tls-cert", "", "PEM certificate file that turns on TLS for the HTTP and gRPC servers (default: none)")
+	serverCmd.PersistentFlags().String("tls-key", "", "PEM private key file of the TLS certificate (default: none)")
+	serverCmd.PersistentFlags().String("client-ca", "", "PEM file of the CAs that sign client certificates, turns on mutual TLS (default: none)")
+	serverCmd.PersistentFlags().Duration("tls-reload-interval", time.Minute, "How often the TLS files are checked for changes, 0 disables reloading (default: 1m)")
+	serverCmd.PersistentFlags().Duration("shutdown-timeout", 30*time.Second, "How long in-flight requests and running jobs get to finish on SIGTERM or SIGINT before they're cancelled (default: 30s)")
+	serverCmd.PersistentFlags().Duration("shutdown-delay", 0, "How long /readyz fails on SIGTERM or SIGINT before the server stops accepting connections (default: 0s)")
+	serverCmd.PersistentFlags().StringSlice("access-log-sample", nil, "ROUTE=N pairs that only log one in N requests to the route, 0 only logs its failures (ex. /healthz=0,/metrics=10)")
 	viper.BindPFlag("host", serverCmd.PersistentFlags().Lookup("host"))
 	viper.BindPFlag("port", serverCmd.PersistentFlags().Lookup("port"))
+	viper.BindPFlag("grpc_port", serverCmd.PersistentFlags().Lookup("grpc-port"))
 	viper.BindPFlag("pguser", serverCmd.PersistentFlags().Lookup("pguser"))
 	viper.BindPFlag("pgpassword", serverCmd.PersistentFlags().Lookup("pgpassword"))
 	viper.BindPFlag("pghost", serverCmd.PersistentFlags().Lookup("pghost"))
 	viper.BindPFlag("pgport", serverCmd.PersistentFlags().Lookup("pgport"))
 	viper.BindPFlag("pgdb", serverCmd.PersistentFlags().Lookup("pgdb"))
+	viper.BindPFlag("pg_chunk_threshold", serverCmd.PersistentFlags().Lookup("pg-chunk-threshold"))
+	viper.BindPFlag("pg_chunk_size", serverCmd.PersistentFlags().Lookup("pg-chunk-size"))
+	viper.BindPFlag("cache", serverCmd.PersistentFlags().Lookup("cache"))
+	viper.BindPFlag("cache_dir", serverCmd.PersistentFlags().Lookup("cache-dir"))
+	viper.BindPFlag("cache_fsync", serverCmd.PersistentFlags().Lookup("cache-fsync"))
+	viper.BindPFlag("cache_fsync_interval", serverCmd.PersistentFlags().Lookup("cache-fsync-interval"))
+	viper.BindPFlag("cache_compact_interval", serverCmd.PersistentFlags().Lookup("cache-compact-interval"))
+	viper.BindPFlag("cache_compact_ratio", serverCmd.PersistentFlags().Lookup("cache-compact-ratio"))
+	viper.BindPFlag("redis_addr", serverCmd.PersistentFlags().Lookup("redis-addr"))
+	viper.BindPFlag("redis_password", serverCmd.PersistentFlags().Lookup("redis-password"))
+	viper.BindPFlag("redis_db", serverCmd.PersistentFlags().Lookup("redis-db"))
+	viper.BindPFlag("redis_prefix", serverCmd.PersistentFlags().Lookup("redis-prefix"))
+	viper.BindPFlag("redis_ttl", serverCmd.PersistentFlags().Lookup("redis-ttl"))
+	viper.BindPFlag("redis_pool_size", serverCmd.PersistentFlags().Lookup("redis-pool-size"))
+	viper.BindPFlag("redis_dial_timeout", serverCmd.PersistentFlags().Lookup("redis-dial-timeout"))
+	viper.BindPFlag("redis_read_timeout", serverCmd.PersistentFlags().Lookup("redis-read-timeout"))
+	viper.BindPFlag("redis_write_timeout", serverCmd.PersistentFlags().Lookup("redis-write-timeout"))
+	viper.BindPFlag("scrub_interval", serverCmd.PersistentFlags().Lookup("scrub-interval"))
+	viper.BindPFlag("scrub_method", serverCmd.PersistentFlags().Lookup("scrub-method"))
+	viper.BindPFlag("scrub_action", serverCmd.PersistentFlags().Lookup("scrub-action"))
+	viper.BindPFlag("job_workers", serverCmd.PersistentFlags().Lookup("job-workers"))
+	viper.BindPFlag("max_ordinal", serverCmd.PersistentFlags().Lookup("max-ordinal"))
+	viper.BindPFlag("max_stream_length", serverCmd.PersistentFlags().Lookup("max-stream-length"))
+	viper.BindPFlag("ws_origins", serverCmd.PersistentFlags().Lookup("ws-origins"))
+	viper.BindPFlag("request_timeout", serverCmd.PersistentFlags().Lookup("request-timeout"))
+	viper.BindPFlag("trace_exporter", serverCmd.PersistentFlags().Lookup("trace-exporter"))
+	viper.BindPFlag("otlp_endpoint", serverCmd.PersistentFlags().Lookup("otlp-endpoint"))
+	viper.BindPFlag("otlp_insecure", serverCmd.PersistentFlags().Lookup("otlp-insecure"))
+	viper.BindPFlag("trace_sample_ratio", serverCmd.PersistentFlags().Lookup("trace-sample-ratio"))
+	viper.BindPFlag("rate_limit", serverCmd.PersistentFlags().Lookup("rate-limit"))
+	viper.BindPFlag("rate_limit_burst", serverCmd.PersistentFlags().Lookup("rate-limit-burst"))
+	viper.BindPFlag("rate_limit_digits_per_token", serverCmd.PersistentFlags().Lookup("rate-limit-digits-per-token"))
+	viper.BindPFlag("rate_limit_routes", serverCmd.PersistentFlags().Lookup("rate-limit-route"))
+	viper.BindPFlag("api_keys", serverCmd.PersistentFlags().Lookup("api-key"))
+	viper.BindPFlag("api_key_file", serverCmd.PersistentFlags().Lookup("api-key-file"))
+	viper.BindPFlag("anonymous_scopes", serverCmd.PersistentFlags().Lookup("anonymous-scopes"))
+	viper.BindPFlag("large_ordinal", serverCmd.PersistentFlags().Lookup("large-ordinal"))
+	viper.BindPFlag("tls_cert", serverCmd.PersistentFlags().Lookup("tls-cert"))
+	viper.BindPFlag("tls_key", serverCmd.PersistentFlags().Lookup("tls-key"))
+	viper.BindPFlag("client_ca", serverCmd.PersistentFlags().Lookup("client-ca"))
+	viper.BindPFlag("tls_reload_interval", serverCmd.PersistentFlags().Lookup("tls-reload-interval"))
+	viper.BindPFlag("shutdown_timeout", serverCmd.PersistentFlags().Lookup("shutdown-timeout"))
+	viper.BindPFlag("shutdown_delay", serverCmd.PersistentFlags().Lookup("shutdown-delay"))
+	viper.BindPFlag("access_log_sample", serverCmd.PersistentFlags().Lookup("access-log-sample"))
 	rootCmd.AddCommand(serverCmd)
 }
 
@@ -43,6 +154,42 @@ func createDsnFromConfig() string {
 	return dsn
 }
 
+// createMemoizerFromConfig creates the memoizer backend selected in the CLI flags/environment/.fiborc
+func createMemoizerFromConfig() (fibonacci.Memoizer, error) {
+	switch backend := viper.GetString("cache"); backend {
+	case "postgres":
+		return cache.NewCache(createDsnFromConfig(), cache.CacheOptions{
+			ChunkThreshold: viper.GetInt("pg_chunk_threshold"),
+			ChunkSize:      viper.GetInt("pg_chunk_size"),
+		})
+	case "file":
+		policy, err := cache.ParseSyncPolicy(viper.GetString("cache_fsync"))
+		if err != nil {
+			return nil, err
+		}
+		return cache.NewFileCache(viper.GetString("cache_dir"), cache.FileCacheOptions{
+			SyncPolicy:      policy,
+			SyncInterval:    viper.GetDuration("cache_fsync_interval"),
+			CompactInterval: viper.GetDuration("cache_compact_interval"),
+			CompactRatio:    viper.GetFloat64("cache_compact_ratio"),
+		})
+	case "redis":
+		return cache.NewRedisCache(cache.RedisCacheOptions{
+			Addr:         viper.GetString("redis_addr"),
+			Password:     viper.GetString("redis_password"),
+			DB:           viper.GetInt("redis_db"),
+			Prefix:       viper.GetString("redis_prefix"),
+			TTL:          viper.GetDuration("redis_ttl"),
+			PoolSize:     viper.GetInt("redis_pool_size"),
+			DialTimeout:  viper.GetDuration("redis_dial_timeout"),
+			ReadTimeout:  viper.GetDuration("redis_read_timeout"),
+			WriteTimeout: viper.GetDuration("redis_write_timeout"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", backend)
+	}
+}
+
 var serverCmd = &cobra.Command{
 	Use:   "server",
 	Short: "Run the API server for memoized Fibonacci generation",
@@ -51,17 +198,262 @@ var serverCmd = &cobra.Command{
 		// Print the command line options for debugging purposes
 		log.Debugf("host: %s", viper.GetString("host"))
 		log.Debugf("port: %d", viper.GetInt("port"))
+		log.Debugf("grpc_port: %d", viper.GetInt("grpc_port"))
 		log.Debugf("pguser: %s", viper.GetString("pguser"))
 		log.Debugf("pghost: %s", viper.GetString("pghost"))
 		log.Debugf("pgport: %s", viper.GetString("pgport"))
 		log.Debugf("pgdb: %s", viper.GetString("pgdb"))
+		log.Debugf("cache: %s", viper.GetString("cache"))
+
+		sampling, err := logging.ParseSampling(viper.GetStringSlice("access_log_sample"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		logging.SetSampling(sampling)
+
+		limiter, err := ratelimit.FromConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		authenticator, err := auth.FromConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !authenticator.Enabled() {
+			log.Warn("No API keys are configured, every client can clear and change the cache.")
+		}
 
-		dsn := createDsnFromConfig()
-		c := cache.NewCache(dsn)
+		// The first SIGTERM or SIGINT drains the server, a second one kills it
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+		defer stop()
+
+		tlsConfig, err := createTLSConfigFromConfig(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		exporter, err := tracing.ParseExporter(viper.GetString("trace_exporter"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+			Exporter:    exporter,
+			Endpoint:    viper.GetString("otlp_endpoint"),
+			Insecure:    viper.GetBool("otlp_insecure"),
+			SampleRatio: viper.GetFloat64("trace_sample_ratio"),
+		})
+		if err != nil {
+			log.Fatalf("Failed to set up tracing: %s", err)
+		}
+
+		c, err := createMemoizerFromConfig()
+		if err != nil {
+			log.Fatalf("Failed to create the memoizer cache: %s", err)
+		}
+		var scrubber *cache.Scrubber
+		if interval := viper.GetDuration("scrub_interval"); interval > 0 {
+			method, err := cache.ParseVerifyMethod(viper.GetString("scrub_method"))
+			if err != nil {
+				log.Fatal(err)
+			}
+			action, err := cache.ParseVerifyAction(viper.GetString("scrub_action"))
+			if err != nil {
+				log.Fatal(err)
+			}
+			scrubber = cache.NewScrubber(c, interval, method, action)
+			scrubber.Start()
+		}
 		gen := fibonacci.NewGenerator(c)
-		r := router.NewRouter(gen)
+		gen.SetObserver(metrics.GeneratorObserver{})
+		if pg, ok := c.(*cache.Cache); ok {
+			prometheus.MustRegister(metrics.NewDBStatsCollector("postgres", pg.PoolStats))
+		}
+		// Jobs are kept in the cache backend when it can store them so they survive restarts
+		store, ok := c.(jobs.Store)
+		if !ok {
+			log.Warn("The cache backend can't store jobs, they will be lost on restart.")
+			store = jobs.NewMemoryStore()
+		}
+		jobManager := jobs.NewManager(gen, store, viper.GetInt("job_workers"))
+		if err := jobManager.Resume(); err != nil {
+			log.Errorf("Failed to resume jobs: %s", err)
+		}
+		// Requests and cache warm-ups are cancelled through the tracker once the shutdown grace period is over
+		tracker := router.NewTracker()
+		s := &servers{
+			requests:        tracker,
+			jobs:            jobManager,
+			scrubber:        scrubber,
+			cache:           c,
+			shutdownTracing: shutdownTracing,
+		}
+		errc := make(chan error, 2)
+		if port := viper.GetInt("grpc_port"); port > 0 {
+			grpcAddr := fmt.Sprintf("%s:%d", viper.GetString("host"), port)
+			lis, err := net.Listen("tcp", grpcAddr)
+			if err != nil {
+				log.Fatalf("Failed to listen for gRPC at %s: %s", grpcAddr, err)
+			}
+			var opts []grpc.ServerOption
+			if tlsConfig != nil {
+				opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+			}
+			s.grpc = rpc.NewServer(gen, authenticator, limiter, opts...)
+			log.Info("Started gRPC server at ", grpcAddr)
+			go func() {
+				if err := s.grpc.Serve(lis); err != nil {
+					errc <- fmt.Errorf("the gRPC server stopped: %w", err)
+				}
+			}()
+		}
+		r := router.NewRouter(gen, jobManager, authenticator, limiter, tracker)
 		addr := fmt.Sprintf("%s:%d", viper.GetString("host"), viper.GetInt("port"))
+		s.http = &http.Server{
+			Addr:        addr,
+			Handler:     tracker.Middleware(r),
+			TLSConfig:   tlsConfig,
+			BaseContext: func(net.Listener) context.Context { return tracker.Context() },
+		}
 		log.Info("Started server at ", addr)
-		http.ListenAndServe(addr, r)
+		go func() {
+			var err error
+			if tlsConfig != nil {
+				err = s.http.ListenAndServeTLS("", "")
+			} else {
+				err = s.http.ListenAndServe()
+			}
+			if err != http.ErrServerClosed {
+				errc <- fmt.Errorf("the server stopped: %w", err)
+			}
+		}()
+
+		clean := true
+		select {
+		case err := <-errc:
+			log.Error(err)
+			clean = false
+		case <-ctx.Done():
+			log.Info("Received a shutdown signal, draining the server...")
+		}
+		stop()
+		if !s.shutdown(viper.GetDuration("shutdown_delay"), viper.GetDuration("shutdown_timeout")) {
+			clean = false
+		}
+		if !clean {
+			os.Exit(1)
+		}
+		log.Info("The server stopped.")
 	},
 }
+
+// servers are the parts of the running server that are stopped on shutdown
+type servers struct {
+	http            *http.Server
+	requests        *router.Tracker // Tracks the HTTP requests, live streams and cache warm-ups
+	grpc            *grpc.Server    // nil when gRPC is off
+	jobs            *jobs.Manager
+	scrubber        *cache.Scrubber // nil when scrubbing is off
+	cache           fibonacci.Memoizer
+	shutdownTracing func(context.Context) error
+}
+
+// shutdown fails the readiness probe for delay, then stops accepting connections and gives the
+// in-flight requests, live streams, cache warm-ups and running jobs up to timeout to finish before
+// they're cancelled. Once they've all returned the scrubber, the cache backend and the tracer are
+// closed, which flushes their pending writes.
+// It returns false when work had to be cancelled or a resource failed to close.
+func (s *servers) shutdown(delay time.Duration, timeout time.Duration) bool {
+	router.SetDraining(true)
+	if delay > 0 {
+		log.Infof("Waiting %s for load balancers to stop routing requests...", delay)
+		time.Sleep(delay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	clean := true
+	var mu sync.Mutex
+	fail := func(format string, args ...interface{}) {
+		log.Warnf(format, args...)
+		mu.Lock()
+		clean = false
+		mu.Unlock()
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		// Shutdown doesn't wait for the hijacked connections of the live streams, the tracker does
+		err := s.http.Shutdown(ctx)
+		if err := s.requests.Shutdown(ctx); err != nil {
+			fail("Cancelled the HTTP requests, live streams and cache warm-ups still running after %s.", timeout)
+		}
+		if err != nil {
+			s.http.Close()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if err := s.jobs.Shutdown(ctx); err != nil {
+			fail("Interrupted the jobs still running after %s.", timeout)
+		}
+	}()
+	if s.grpc != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stopped := make(chan struct{})
+			go func() {
+				s.grpc.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				fail("Cancelled the gRPC calls still running after %s.", timeout)
+				s.grpc.Stop()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if s.scrubber != nil {
+		s.scrubber.Stop()
+	}
+	if closer, ok := s.cache.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			fail("Failed to close the cache backend: %s", err)
+		}
+	}
+	tracingCtx, cancelTracing := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelTracing()
+	if err := s.shutdownTracing(tracingCtx); err != nil {
+		fail("Failed to flush the traces: %s", err)
+	}
+	return clean
+}
+
+// createTLSConfigFromConfig reads the TLS certificate in the CLI flags/environment/.fiborc and
+// reloads it when its files change until ctx ends, it returns nil when TLS is off
+func createTLSConfigFromConfig(ctx context.Context) (*tls.Config, error) {
+	opts := tlsconfig.ServerOptions{
+		CertFile:     viper.GetString("tls_cert"),
+		KeyFile:      viper.GetString("tls_key"),
+		ClientCAFile: viper.GetString("client_ca"),
+	}
+	if !opts.Enabled() {
+		if opts.ClientCAFile != "" {
+			return nil, errors.New("--client-ca needs --tls-cert and --tls-key")
+		}
+		return nil, nil
+	}
+	reloader, err := tlsconfig.NewReloader(opts)
+	if err != nil {
+		return nil, err
+	}
+	if interval := viper.GetDuration("tls_reload_interval"); interval > 0 {
+		go reloader.Watch(ctx, interval)
+	}
+	return reloader.Config(), nil
+}
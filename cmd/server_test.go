@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/programmablemike/fibo/internal/fibonacci"
+	"github.com/programmablemike/fibo/internal/fibonacci/fibotest"
+	"github.com/programmablemike/fibo/internal/jobs"
+	"github.com/programmablemike/fibo/internal/router"
+	"github.com/stretchr/testify/assert"
+)
+
+// closingCache is a slow memoizer that counts the writes made after it was closed
+type closingCache struct {
+	*fibotest.MemoryCache
+	closed int32
+	late   int32
+}
+
+func (c *closingCache) Write(ordinal uint64, value *fibonacci.Number) error {
+	time.Sleep(time.Millisecond)
+	if atomic.LoadInt32(&c.closed) == 1 {
+		atomic.AddInt32(&c.late, 1)
+	}
+	return c.MemoryCache.Write(ordinal, value)
+}
+
+func (c *closingCache) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+	return nil
+}
+
+func TestServersShutdown(t *testing.T) {
+	defer router.SetDraining(false)
+	c := &closingCache{MemoryCache: fibotest.NewMemoryCache()}
+	gen := fibonacci.NewGenerator(c)
+	jobManager := jobs.NewManager(gen, jobs.NewMemoryStore(), 1)
+	tracker := router.NewTracker()
+	s := &servers{
+		requests:        tracker,
+		jobs:            jobManager,
+		cache:           c,
+		shutdownTracing: func(context.Context) error { return nil },
+	}
+	s.http = &http.Server{
+		Handler:     tracker.Middleware(router.NewRouter(gen, jobManager, nil, nil, tracker)),
+		BaseContext: func(net.Listener) context.Context { return tracker.Context() },
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.http.Serve(lis)
+	addr := lis.Addr().String()
+
+	// A cache warm-up that outlasts the grace period and a live stream on a hijacked connection
+	res, err := http.Post("http://"+addr+"/v1/cache/warm?to=1000000", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	assert.Equal(t, http.StatusAccepted, res.StatusCode)
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/v1/events/sequence?from=0&interval=1s", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, _, err = conn.ReadMessage()
+	assert.NoError(t, err)
+
+	assert.False(t, s.shutdown(0, 100*time.Millisecond))
+	// Both were cancelled and returned before the cache was closed
+	assert.Zero(t, atomic.LoadInt32(&c.late))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = conn.ReadMessage()
+	// The server closed the connection, the read didn't time out
+	netErr, ok := err.(net.Error)
+	assert.Error(t, err)
+	assert.False(t, ok && netErr.Timeout(), err)
+}
@@ -0,0 +1,274 @@
+// Authenticates API clients with keys and checks the scopes of their requests
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Scope is a set of operations a client is allowed
+type Scope string
+
+const (
+	// ScopeRead allows calculations up to the large ordinal and reading the cache and jobs
+	ScopeRead Scope = "read"
+	// ScopeComputeLarge allows calculations above the large ordinal and submitting jobs, and
+	// includes ScopeRead
+	ScopeComputeLarge Scope = "compute-large"
+	// ScopeAdmin allows changing the cache and includes every other scope
+	ScopeAdmin Scope = "admin"
+)
+
+// scopeRanks orders the scopes, a scope includes the ones with a lower rank
+var scopeRanks = map[Scope]int{
+	ScopeRead:         1,
+	ScopeComputeLarge: 2,
+	ScopeAdmin:        3,
+}
+
+// ErrInvalidCredentials is returned for a token that doesn't match any key
+var ErrInvalidCredentials = errors.New("invalid API key")
+
+// AnonymousName is the name of the principal of requests without credentials
+const AnonymousName = "anonymous"
+
+// ParseScopes parses scopes separated by commas or plus signs
+func ParseScopes(v string) ([]Scope, error) {
+	var scopes []Scope
+	for _, s := range strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == '+' || r == ' ' }) {
+		scope := Scope(s)
+		if _, ok := scopeRanks[scope]; !ok {
+			return nil, fmt.Errorf("invalid scope %q (expected read, compute-large or admin)", s)
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes, nil
+}
+
+// Principal is a client whose credentials were checked
+type Principal struct {
+	Name   string
+	Scopes []Scope
+}
+
+// Allows returns true if one of the scopes of the principal includes scope
+func (p *Principal) Allows(scope Scope) bool {
+	for _, s := range p.Scopes {
+		if scopeRanks[s] >= scopeRanks[scope] {
+			return true
+		}
+	}
+	return false
+}
+
+// Anonymous returns true for the principal of requests without credentials
+func (p *Principal) Anonymous() bool {
+	return p.Name == AnonymousName
+}
+
+// Key is an API key, only the SHA-256 hash of its token is kept
+type Key struct {
+	Name   string
+	Hash   [sha256.Size]byte
+	Scopes []Scope
+}
+
+// HashToken returns the SHA-256 hash of a token
+func HashToken(token string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(token))
+}
+
+// NewToken returns a random 256-bit token
+func NewToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ParseKey parses a NAME:TOKEN:SCOPES key from the configuration
+func ParseKey(spec string) (Key, error) {
+	i, j := strings.Index(spec, ":"), strings.LastIndex(spec, ":")
+	if i <= 0 || j == i || j == i+1 {
+		return Key{}, fmt.Errorf("invalid API key %q (expected NAME:TOKEN:SCOPES)", redact(spec))
+	}
+	scopes, err := ParseScopes(spec[j+1:])
+	if err != nil {
+		return Key{}, fmt.Errorf("invalid API key %s: %w", spec[:i], err)
+	}
+	return Key{Name: spec[:i], Hash: HashToken(spec[i+1 : j]), Scopes: scopes}, nil
+}
+
+// redact hides the token of an invalid key spec
+func redact(spec string) string {
+	if i := strings.Index(spec, ":"); i >= 0 {
+		return spec[:i] + ":..."
+	}
+	return "..."
+}
+
+// KeyFileLine formats a key as a line of a key file
+func KeyFileLine(name string, token string, scopes []Scope) string {
+	hash := HashToken(token)
+	names := make([]string, len(scopes))
+	for i, s := range scopes {
+		names[i] = string(s)
+	}
+	return fmt.Sprintf("%s %s %s", name, hex.EncodeToString(hash[:]), strings.Join(names, ","))
+}
+
+// ReadKeyFile reads the keys of a key file
+// Every line holds the name, the hex encoded SHA-256 hash of the token and the comma separated scopes
+// of a key. Empty lines and lines starting with # are skipped.
+func ReadKeyFile(path string) ([]Key, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var keys []Key
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected NAME SHA256 SCOPES", path, line)
+		}
+		key := Key{Name: fields[0]}
+		hash, err := hex.DecodeString(fields[1])
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("%s:%d: invalid SHA-256 hash", path, line)
+		}
+		copy(key.Hash[:], hash)
+		if key.Scopes, err = ParseScopes(fields[2]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, scanner.Err()
+}
+
+// Config is how clients are authenticated and what they're allowed
+type Config struct {
+	Keys []Key
+	// Anonymous are the scopes of requests without credentials
+	Anonymous []Scope
+	// LargeOrdinal is the largest ordinal calculated with ScopeRead, 0 means there's no limit
+	LargeOrdinal uint64
+}
+
+// Authenticator checks the credentials of the clients
+// A nil Authenticator, or one without keys, lets every request through.
+type Authenticator struct {
+	keys         map[[sha256.Size]byte]*Principal
+	anonymous    *Principal
+	largeOrdinal uint64
+}
+
+// New creates an Authenticator for the keys of the configuration
+func New(cfg Config) (*Authenticator, error) {
+	a := &Authenticator{
+		keys:         make(map[[sha256.Size]byte]*Principal, len(cfg.Keys)),
+		anonymous:    &Principal{Name: AnonymousName, Scopes: cfg.Anonymous},
+		largeOrdinal: cfg.LargeOrdinal,
+	}
+	for _, key := range cfg.Keys {
+		if key.Name == AnonymousName {
+			return nil, fmt.Errorf("the API key name %q is reserved", AnonymousName)
+		}
+		if _, ok := a.keys[key.Hash]; ok {
+			return nil, fmt.Errorf("the API key %s has the same token as another key", key.Name)
+		}
+		a.keys[key.Hash] = &Principal{Name: key.Name, Scopes: key.Scopes}
+	}
+	return a, nil
+}
+
+// FromConfig creates an Authenticator from the CLI flags/environment/.fiborc
+func FromConfig() (*Authenticator, error) {
+	cfg := Config{LargeOrdinal: viper.GetUint64("large_ordinal")}
+	for _, spec := range viper.GetStringSlice("api_keys") {
+		key, err := ParseKey(spec)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Keys = append(cfg.Keys, key)
+	}
+	if path := viper.GetString("api_key_file"); path != "" {
+		keys, err := ReadKeyFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the API key file: %w", err)
+		}
+		cfg.Keys = append(cfg.Keys, keys...)
+	}
+	var err error
+	if cfg.Anonymous, err = ParseScopes(strings.Join(viper.GetStringSlice("anonymous_scopes"), ",")); err != nil {
+		return nil, err
+	}
+	return New(cfg)
+}
+
+// Enabled returns true if requests need credentials
+func (a *Authenticator) Enabled() bool {
+	return a != nil && len(a.keys) > 0
+}
+
+// Authenticate returns the principal of a token, or the anonymous principal when the token is empty
+func (a *Authenticator) Authenticate(token string) (*Principal, error) {
+	if token == "" {
+		return a.anonymous, nil
+	}
+	p, ok := a.keys[HashToken(token)]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return p, nil
+}
+
+// OrdinalScope returns the scope needed to calculate the ordinal
+func (a *Authenticator) OrdinalScope(ordinal uint64) Scope {
+	if a.largeOrdinal > 0 && ordinal > a.largeOrdinal {
+		return ScopeComputeLarge
+	}
+	return ScopeRead
+}
+
+// RequestToken returns the token of an Authorization: Bearer or an X-API-Key header
+func RequestToken(h http.Header) string {
+	return Token(h.Get("Authorization"), h.Get("X-API-Key"))
+}
+
+// Token returns the token of a Bearer authorization, or the API key when there's none
+func Token(authorization string, apiKey string) string {
+	if len(authorization) > 7 && strings.EqualFold(authorization[:7], "bearer ") {
+		return strings.TrimSpace(authorization[7:])
+	}
+	return apiKey
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the principal
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal carried by ctx, or nil when the request wasn't authenticated
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(contextKey{}).(*Principal)
+	return p
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := ParseScopes("read+compute-large,admin")
+	assert.NoError(t, err)
+	assert.Equal(t, []Scope{ScopeRead, ScopeComputeLarge, ScopeAdmin}, scopes)
+	_, err = ParseScopes("read,write")
+	assert.Error(t, err)
+}
+
+func TestPrincipalAllows(t *testing.T) {
+	admin := &Principal{Name: "ops", Scopes: []Scope{ScopeAdmin}}
+	assert.True(t, admin.Allows(ScopeRead))
+	assert.True(t, admin.Allows(ScopeComputeLarge))
+	reader := &Principal{Name: "ci", Scopes: []Scope{ScopeRead}}
+	assert.True(t, reader.Allows(ScopeRead))
+	assert.False(t, reader.Allows(ScopeComputeLarge))
+	assert.False(t, (&Principal{Name: AnonymousName}).Allows(ScopeRead))
+}
+
+func TestParseKey(t *testing.T) {
+	key, err := ParseKey("ci:to:ken:read+compute-large")
+	assert.NoError(t, err)
+	assert.Equal(t, "ci", key.Name)
+	assert.Equal(t, HashToken("to:ken"), key.Hash)
+	assert.Equal(t, []Scope{ScopeRead, ScopeComputeLarge}, key.Scopes)
+	for _, bad := range []string{"ci", "ci:token", ":token:read", "ci::read", "ci:token:write"} {
+		_, err = ParseKey(bad)
+		assert.Error(t, err, bad)
+	}
+	// The token isn't part of the error
+	_, err = ParseKey("ci:s3cr3t")
+	assert.NotContains(t, err.Error(), "s3cr3t")
+}
+
+func TestReadKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	content := "# fibo API keys\n\n" + KeyFileLine("ci", "token-1", []Scope{ScopeRead}) + "\n" +
+		KeyFileLine("ops", "token-2", []Scope{ScopeAdmin}) + "\n"
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	keys, err := ReadKeyFile(path)
+	assert.NoError(t, err)
+	if assert.Len(t, keys, 2) {
+		assert.Equal(t, Key{Name: "ci", Hash: HashToken("token-1"), Scopes: []Scope{ScopeRead}}, keys[0])
+		assert.Equal(t, "ops", keys[1].Name)
+	}
+
+	assert.NoError(t, os.WriteFile(path, []byte("ci not-a-hash read\n"), 0600))
+	_, err = ReadKeyFile(path)
+	assert.Error(t, err)
+}
+
+func TestAuthenticate(t *testing.T) {
+	var disabled *Authenticator
+	assert.False(t, disabled.Enabled())
+
+	a, err := New(Config{
+		Keys:         []Key{{Name: "ci", Hash: HashToken("token-1"), Scopes: []Scope{ScopeRead}}},
+		Anonymous:    []Scope{ScopeRead},
+		LargeOrdinal: 1000,
+	})
+	assert.NoError(t, err)
+	assert.True(t, a.Enabled())
+	p, err := a.Authenticate("token-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "ci", p.Name)
+	p, err = a.Authenticate("")
+	assert.NoError(t, err)
+	assert.True(t, p.Anonymous())
+	assert.True(t, p.Allows(ScopeRead))
+	_, err = a.Authenticate("token-2")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	assert.Equal(t, ScopeRead, a.OrdinalScope(1000))
+	assert.Equal(t, ScopeComputeLarge, a.OrdinalScope(1001))
+
+	_, err = New(Config{Keys: []Key{{Name: AnonymousName, Hash: HashToken("x")}}})
+	assert.Error(t, err)
+	_, err = New(Config{Keys: []Key{{Name: "a", Hash: HashToken("x")}, {Name: "b", Hash: HashToken("x")}}})
+	assert.Error(t, err)
+}
+
+func TestRequestToken(t *testing.T) {
+	h := http.Header{}
+	assert.Empty(t, RequestToken(h))
+	h.Set("X-API-Key", "key")
+	assert.Equal(t, "key", RequestToken(h))
+	h.Set("Authorization", "bearer token")
+	assert.Equal(t, "token", RequestToken(h))
+}
+
+func TestContext(t *testing.T) {
+	assert.Nil(t, FromContext(context.Background()))
+	p := &Principal{Name: "ci"}
+	assert.Equal(t, p, FromContext(NewContext(context.Background(), p)))
+}
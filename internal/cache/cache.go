@@ -0,0 +1,68 @@
+// Defines the behavior shared by all of the memoizer cache backends
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/programmablemike/fibo/internal/fibonacci"
+)
+
+// ErrNotFound is returned when an ordinal has no cached value
+var ErrNotFound = errors.New("cache entry not found")
+
+// Iterator is implemented by caches that can enumerate their entries
+type Iterator interface {
+	// Each calls fn for every cached entry in ascending ordinal order, stopping at the first error
+	Each(fn func(ordinal uint64, value *fibonacci.Number) error) error
+}
+
+// Deleter is implemented by caches that can remove a single entry
+type Deleter interface {
+	Delete(ordinal uint64) error
+}
+
+// Streamer is implemented by caches that can write a value without loading all of it into memory
+type Streamer interface {
+	// StreamValue writes the decimal digits of the cached value to w, or returns ErrNotFound
+	StreamValue(ordinal uint64, w io.Writer) (int64, error)
+}
+
+// Checker is implemented by caches that can become unusable, e.g. when their server goes away
+type Checker interface {
+	// Check returns the state of every dependency of the cache by name, healthy ones have a nil error
+	Check(ctx context.Context) map[string]error
+}
+
+// EntryInfo describes how an entry is stored without its value
+type EntryInfo struct {
+	Ordinal   uint64
+	Size      int64     // Number of bytes taken by the stored value
+	CreatedAt time.Time // Zero when the backend doesn't record timestamps
+	UpdatedAt time.Time // Zero when the backend doesn't record timestamps
+}
+
+// Stats summarizes the contents of a cache
+type Stats struct {
+	Entries    uint64 // Number of live records
+	Tombstoned uint64 // Number of deleted or superseded records that still take space
+	Bytes      int64  // Space taken by the cache
+	MaxOrdinal uint64 // Largest cached ordinal, only meaningful when there are entries
+}
+
+// Inspector is implemented by caches that can describe their entries
+type Inspector interface {
+	// List describes up to limit entries with ordinals of at least from in ascending order
+	List(from uint64, limit int) ([]EntryInfo, error)
+	// Info describes the entry for the ordinal, or returns ErrNotFound
+	Info(ordinal uint64) (*EntryInfo, error)
+	Stats() (*Stats, error)
+}
+
+// sortOrdinals sorts ordinals in ascending order
+func sortOrdinals(ordinals []uint64) {
+	sort.Slice(ordinals, func(i, j int) bool { return ordinals[i] < ordinals[j] })
+}
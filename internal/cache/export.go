@@ -0,0 +1,314 @@
+// Serializes cache entries so that a memo can be moved between environments
+package cache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+
+	"github.com/programmablemike/fibo/internal/fibonacci"
+)
+
+// Format is the serialization format of an exported cache
+type Format string
+
+const (
+	FormatJSONL  Format = "jsonl"
+	FormatCSV    Format = "csv"
+	FormatBinary Format = "binary"
+)
+
+// binaryMagic is written at the start of every binary export
+const binaryMagic = "FIBO\x01"
+
+// maxBinaryValueSize guards against allocating huge buffers for corrupted binary exports
+const maxBinaryValueSize = 1 << 32
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ParseFormat converts a user supplied string into a Format
+func ParseFormat(v string) (Format, error) {
+	switch f := Format(v); f {
+	case FormatJSONL, FormatCSV, FormatBinary:
+		return f, nil
+	default:
+		return "", fmt.Errorf("invalid export format %q (expected jsonl, csv or binary)", v)
+	}
+}
+
+// ContentType is the MIME type used when sending the format over HTTP
+func (f Format) ContentType() string {
+	switch f {
+	case FormatCSV:
+		return "text/csv"
+	case FormatBinary:
+		return "application/octet-stream"
+	default:
+		return "application/x-ndjson"
+	}
+}
+
+// Checksum is the CRC-32C of the big-endian bytes of a value
+// It's independent of the export format so that checksums can be compared across formats.
+func Checksum(value *fibonacci.Number) uint32 {
+	return crc32.Checksum(value.Bytes(), castagnoli)
+}
+
+// ExportedEntry is a single row of a text export
+type ExportedEntry struct {
+	Ordinal  uint64 `json:"ordinal"`
+	Value    string `json:"value"`
+	Checksum string `json:"checksum"`
+}
+
+// EntryWriter streams cache entries to an export
+type EntryWriter interface {
+	WriteEntry(ordinal uint64, value *fibonacci.Number) error
+	// Flush writes any buffered data to the underlying writer
+	Flush() error
+}
+
+// EntryReader streams cache entries from an export
+type EntryReader interface {
+	// ReadEntry returns the next entry after verifying its checksum, or io.EOF at the end of the export
+	ReadEntry() (uint64, *fibonacci.Number, error)
+}
+
+// NewEntryWriter creates an EntryWriter for the given format
+func NewEntryWriter(w io.Writer, format Format) (EntryWriter, error) {
+	switch format {
+	case FormatJSONL:
+		bw := bufio.NewWriter(w)
+		return &jsonlWriter{w: bw, enc: json.NewEncoder(bw)}, nil
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"ordinal", "value", "checksum"}); err != nil {
+			return nil, err
+		}
+		return &csvWriter{w: cw}, nil
+	case FormatBinary:
+		bw := bufio.NewWriter(w)
+		if _, err := bw.WriteString(binaryMagic); err != nil {
+			return nil, err
+		}
+		return &binaryWriter{w: bw}, nil
+	default:
+		return nil, fmt.Errorf("invalid export format %q", format)
+	}
+}
+
+// NewEntryReader creates an EntryReader for the given format
+func NewEntryReader(r io.Reader, format Format) (EntryReader, error) {
+	switch format {
+	case FormatJSONL:
+		return &jsonlReader{dec: json.NewDecoder(r)}, nil
+	case FormatCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = 3
+		cr.ReuseRecord = true
+		header, err := cr.Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read csv header: %w", err)
+		}
+		if header[0] != "ordinal" || header[1] != "value" || header[2] != "checksum" {
+			return nil, fmt.Errorf("unexpected csv header %v", header)
+		}
+		return &csvReader{r: cr}, nil
+	case FormatBinary:
+		br := bufio.NewReader(r)
+		magic := make([]byte, len(binaryMagic))
+		if _, err := io.ReadFull(br, magic); err != nil || string(magic) != binaryMagic {
+			return nil, fmt.Errorf("not a fibo binary export")
+		}
+		return &binaryReader{r: br}, nil
+	default:
+		return nil, fmt.Errorf("invalid export format %q", format)
+	}
+}
+
+// parseTextEntry converts the decimal value and hex checksum of a text export row
+func parseTextEntry(ordinal uint64, value string, checksum string) (uint64, *fibonacci.Number, error) {
+	v, ok := fibonacci.NewNumberFromDecimalString(value)
+	if !ok {
+		return 0, nil, fmt.Errorf("invalid value for ordinal=%s", fibonacci.Uint64ToString(ordinal))
+	}
+	sum, err := strconv.ParseUint(checksum, 16, 32)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid checksum for ordinal=%s", fibonacci.Uint64ToString(ordinal))
+	}
+	if uint32(sum) != Checksum(v) {
+		return 0, nil, fmt.Errorf("checksum mismatch for ordinal=%s", fibonacci.Uint64ToString(ordinal))
+	}
+	return ordinal, v, nil
+}
+
+func formatChecksum(value *fibonacci.Number) string {
+	return fmt.Sprintf("%08x", Checksum(value))
+}
+
+type jsonlWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (jw *jsonlWriter) WriteEntry(ordinal uint64, value *fibonacci.Number) error {
+	return jw.enc.Encode(ExportedEntry{
+		Ordinal:  ordinal,
+		Value:    value.String(),
+		Checksum: formatChecksum(value),
+	})
+}
+
+func (jw *jsonlWriter) Flush() error {
+	return jw.w.Flush()
+}
+
+type jsonlReader struct {
+	dec *json.Decoder
+}
+
+func (jr *jsonlReader) ReadEntry() (uint64, *fibonacci.Number, error) {
+	entry := ExportedEntry{}
+	if err := jr.dec.Decode(&entry); err != nil {
+		return 0, nil, err
+	}
+	return parseTextEntry(entry.Ordinal, entry.Value, entry.Checksum)
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (cw *csvWriter) WriteEntry(ordinal uint64, value *fibonacci.Number) error {
+	return cw.w.Write([]string{fibonacci.Uint64ToString(ordinal), value.String(), formatChecksum(value)})
+}
+
+func (cw *csvWriter) Flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+type csvReader struct {
+	r *csv.Reader
+}
+
+func (cr *csvReader) ReadEntry() (uint64, *fibonacci.Number, error) {
+	record, err := cr.r.Read()
+	if err != nil {
+		return 0, nil, err
+	}
+	ordinal, err := strconv.ParseUint(record[0], 10, 64)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid ordinal %q", record[0])
+	}
+	return parseTextEntry(ordinal, record[1], record[2])
+}
+
+// binaryWriter writes records of: uvarint ordinal | uvarint length | big-endian value | CRC-32C
+type binaryWriter struct {
+	w *bufio.Writer
+}
+
+func (bw *binaryWriter) WriteEntry(ordinal uint64, value *fibonacci.Number) error {
+	b := value.Bytes()
+	header := make([]byte, 2*binary.MaxVarintLen64)
+	n := binary.PutUvarint(header, ordinal)
+	n += binary.PutUvarint(header[n:], uint64(len(b)))
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, crc32.Checksum(b, castagnoli))
+	for _, part := range [][]byte{header[:n], b, sum} {
+		if _, err := bw.w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bw *binaryWriter) Flush() error {
+	return bw.w.Flush()
+}
+
+type binaryReader struct {
+	r *bufio.Reader
+}
+
+func (br *binaryReader) ReadEntry() (uint64, *fibonacci.Number, error) {
+	ordinal, err := binary.ReadUvarint(br.r)
+	if err != nil {
+		return 0, nil, err // io.EOF on a clean record boundary
+	}
+	length, err := binary.ReadUvarint(br.r)
+	if err != nil {
+		return 0, nil, fmt.Errorf("truncated record for ordinal=%s", fibonacci.Uint64ToString(ordinal))
+	}
+	if length > maxBinaryValueSize {
+		return 0, nil, fmt.Errorf("value too large for ordinal=%s", fibonacci.Uint64ToString(ordinal))
+	}
+	b := make([]byte, length+4)
+	if _, err := io.ReadFull(br.r, b); err != nil {
+		return 0, nil, fmt.Errorf("truncated record for ordinal=%s", fibonacci.Uint64ToString(ordinal))
+	}
+	if crc32.Checksum(b[:length], castagnoli) != binary.BigEndian.Uint32(b[length:]) {
+		return 0, nil, fmt.Errorf("checksum mismatch for ordinal=%s", fibonacci.Uint64ToString(ordinal))
+	}
+	return ordinal, fibonacci.NewNumber(0).SetBytes(b[:length]), nil
+}
+
+// Export writes every entry of the cache to w
+func Export(it Iterator, w io.Writer, format Format) (int, error) {
+	ew, err := NewEntryWriter(w, format)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	err = it.Each(func(ordinal uint64, value *fibonacci.Number) error {
+		count++
+		return ew.WriteEntry(ordinal, value)
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, ew.Flush()
+}
+
+// StoreError is returned by Import when the memoizer fails to store an entry
+// The other errors of Import are caused by the input.
+type StoreError struct {
+	Ordinal uint64
+	Err     error
+}
+
+func (e *StoreError) Error() string {
+	return fmt.Sprintf("failed to store ordinal=%d: %s", e.Ordinal, e.Err)
+}
+
+func (e *StoreError) Unwrap() error {
+	return e.Err
+}
+
+// Import writes every entry read from r into the memoizer
+func Import(m fibonacci.Memoizer, r io.Reader, format Format) (int, error) {
+	er, err := NewEntryReader(r, format)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for {
+		ordinal, value, err := er.ReadEntry()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		if err := m.Write(ordinal, value); err != nil {
+			return count, &StoreError{Ordinal: ordinal, Err: err}
+		}
+		count++
+	}
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/programmablemike/fibo/internal/fibonacci"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	src, err := NewFileCache(t.TempDir(), fileCacheTestOptions)
+	assert.NoError(t, err)
+	defer src.Close()
+	fibonacci.NewGenerator(src).Compute(300)
+	expected, err := src.Read(299)
+	assert.NoError(t, err)
+
+	for _, format := range []Format{FormatJSONL, FormatCSV, FormatBinary} {
+		t.Run(string(format), func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			exported, err := Export(src, buf, format)
+			assert.NoError(t, err)
+			assert.Equal(t, 300, exported) // Ordinals 0..299 are memoized while computing F(300)
+
+			dst, err := NewFileCache(t.TempDir(), fileCacheTestOptions)
+			assert.NoError(t, err)
+			defer dst.Close()
+			imported, err := Import(dst, buf, format)
+			assert.NoError(t, err)
+			assert.Equal(t, exported, imported)
+
+			v, err := dst.Read(299)
+			assert.NoError(t, err)
+			assert.Equal(t, 0, expected.Cmp(v))
+		})
+	}
+}
+
+func TestImportRejectsBadChecksum(t *testing.T) {
+	dst, err := NewFileCache(t.TempDir(), fileCacheTestOptions)
+	assert.NoError(t, err)
+	defer dst.Close()
+
+	_, err = Import(dst, strings.NewReader(`{"ordinal":12,"value":"144","checksum":"00000000"}`+"\n"), FormatJSONL)
+	assert.EqualError(t, err, "checksum mismatch for ordinal=12")
+	_, err = Import(dst, strings.NewReader("ordinal,value,checksum\n12,145,"+formatChecksum(fibonacci.NewNumber(144))+"\n"), FormatCSV)
+	assert.EqualError(t, err, "checksum mismatch for ordinal=12")
+	_, err = dst.Read(12)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestImportRejectsTruncatedBinary(t *testing.T) {
+	src, err := NewFileCache(t.TempDir(), fileCacheTestOptions)
+	assert.NoError(t, err)
+	defer src.Close()
+	assert.NoError(t, src.Write(100, fibonacci.NewGenerator(src).Compute(100)))
+
+	buf := &bytes.Buffer{}
+	_, err = Export(src, buf, FormatBinary)
+	assert.NoError(t, err)
+
+	dst, err := NewFileCache(t.TempDir(), fileCacheTestOptions)
+	assert.NoError(t, err)
+	defer dst.Close()
+	_, err = Import(dst, bytes.NewReader(buf.Bytes()[:buf.Len()-2]), FormatBinary)
+	assert.Error(t, err)
+}
@@ -0,0 +1,619 @@
+// Implements an intermediate cache of Fibonacci values using an append-only log file
+package cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/programmablemike/fibo/internal/fibonacci"
+	"github.com/programmablemike/fibo/internal/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	logFileName     = "fibo.log"
+	compactFileName = "fibo.log.compact"
+	// recordHeaderSize is the size of the fixed record header: ordinal (8 bytes) + value length (4 bytes)
+	recordHeaderSize = 12
+	// recordTrailerSize is the size of the CRC-32 checksum that follows every record
+	recordTrailerSize = 4
+	// tombstoneLength is the value length that marks a deleted ordinal
+	tombstoneLength = ^uint32(0)
+)
+
+// SyncPolicy controls when the log file is flushed to stable storage
+type SyncPolicy string
+
+const (
+	// SyncAlways calls fsync after every write
+	SyncAlways SyncPolicy = "always"
+	// SyncInterval calls fsync periodically in the background
+	SyncInterval SyncPolicy = "interval"
+	// SyncNever leaves flushing up to the operating system
+	SyncNever SyncPolicy = "never"
+)
+
+// ParseSyncPolicy converts a configuration string into a SyncPolicy
+func ParseSyncPolicy(v string) (SyncPolicy, error) {
+	switch p := SyncPolicy(v); p {
+	case SyncAlways, SyncInterval, SyncNever:
+		return p, nil
+	default:
+		return "", fmt.Errorf("invalid fsync policy %q (expected always, interval or never)", v)
+	}
+}
+
+// FileCacheOptions configures the durability and compaction behavior of a FileCache
+type FileCacheOptions struct {
+	SyncPolicy      SyncPolicy    // When to fsync the log
+	SyncInterval    time.Duration // How often to fsync when using SyncInterval
+	CompactInterval time.Duration // How often to check whether the log needs compacting (0 disables)
+	CompactRatio    float64       // Fraction of stale records that triggers a compaction
+}
+
+// DefaultFileCacheOptions are sensible defaults for edge deployments
+var DefaultFileCacheOptions = FileCacheOptions{
+	SyncPolicy:      SyncInterval,
+	SyncInterval:    1 * time.Second,
+	CompactInterval: 5 * time.Minute,
+	CompactRatio:    0.5,
+}
+
+// FileCache implements a cache for pre-computed ordinal values on the local filesystem
+//
+// Entries are appended to a log file and an in-memory index of ordinal -> file offset is
+// rebuilt from the log at startup. Overwritten entries are reclaimed by compaction.
+// FileCache is goroutine safe.
+type FileCache struct {
+	mu    sync.RWMutex
+	dir   string
+	opts  FileCacheOptions
+	file  *os.File
+	size  int64            // Current size of the log file
+	index map[uint64]int64 // Offset of the latest record for each ordinal
+	stale int              // Number of records that have been superseded
+	dirty bool             // Whether there are writes that haven't been synced
+	stop  chan struct{}    // Closed to stop the background goroutines
+	wg    sync.WaitGroup   // Tracks the background goroutines
+
+	closeOnce sync.Once
+	closeErr  error // Error of the first Close, returned by the later ones
+}
+
+// NewFileCache opens (or creates) the log in dir and rebuilds the index
+func NewFileCache(dir string, opts FileCacheOptions) (*FileCache, error) {
+	log.Infof("Opening file cache in %s...", dir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	c := &FileCache{
+		dir:   dir,
+		opts:  opts,
+		index: make(map[uint64]int64),
+		stop:  make(chan struct{}),
+	}
+	if err := c.open(); err != nil {
+		return nil, err
+	}
+	log.Infof("Loaded %d cache entries from %s.", len(c.index), c.path())
+
+	if opts.SyncPolicy == SyncInterval && opts.SyncInterval > 0 {
+		c.wg.Add(1)
+		go c.every(opts.SyncInterval, c.Sync)
+	}
+	if opts.CompactInterval > 0 {
+		c.wg.Add(1)
+		go c.every(opts.CompactInterval, c.maybeCompact)
+	}
+	return c, nil
+}
+
+func (c *FileCache) path() string {
+	return filepath.Join(c.dir, logFileName)
+}
+
+// open the log file and replay it to rebuild the index
+func (c *FileCache) open() error {
+	f, err := os.OpenFile(c.path(), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open cache log: %w", err)
+	}
+	c.file = f
+	c.index = make(map[uint64]int64)
+	c.stale = 0
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat cache log: %w", err)
+	}
+	r := bufio.NewReader(io.NewSectionReader(f, 0, info.Size()))
+	offset := int64(0)
+	for {
+		ordinal, length, _, err := readRecord(r, info.Size()-offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// A torn or corrupted record can only be the result of a crash mid-write
+			// so we drop it and everything after it.
+			log.Warnf("Truncating cache log at offset %d: %s", offset, err)
+			if err := f.Truncate(offset); err != nil {
+				return fmt.Errorf("failed to truncate cache log: %w", err)
+			}
+			break
+		}
+		if _, ok := c.index[ordinal]; ok {
+			c.stale++
+		}
+		if length == tombstoneLength {
+			delete(c.index, ordinal)
+			c.stale++ // The tombstone itself is reclaimed by compaction
+		} else {
+			c.index[ordinal] = offset
+		}
+		offset += recordSize(length)
+	}
+	c.size = offset
+	return nil
+}
+
+// recordSize is the number of bytes taken up by a record with a value of the given length
+func recordSize(length uint32) int64 {
+	if length == tombstoneLength {
+		length = 0
+	}
+	return recordHeaderSize + int64(length) + recordTrailerSize
+}
+
+// readRecord reads the next record from r and returns its value
+// limit is the number of bytes left in the log, a length beyond it can only be a torn header.
+func readRecord(r io.Reader, limit int64) (uint64, uint32, []byte, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, 0, nil, fmt.Errorf("short record header")
+		}
+		return 0, 0, nil, err
+	}
+	ordinal := binary.BigEndian.Uint64(header[0:8])
+	length := binary.BigEndian.Uint32(header[8:12])
+	if recordSize(length) > limit {
+		return 0, 0, nil, fmt.Errorf("record length %d exceeds the end of the log", length)
+	}
+
+	size := recordSize(length) - recordHeaderSize - recordTrailerSize
+	value := make([]byte, size+recordTrailerSize)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return 0, 0, nil, fmt.Errorf("short record body")
+	}
+	sum := crc32.NewIEEE()
+	sum.Write(header)
+	sum.Write(value[:size])
+	if sum.Sum32() != binary.BigEndian.Uint32(value[size:]) {
+		return 0, 0, nil, fmt.Errorf("checksum mismatch for ordinal=%s", fibonacci.Uint64ToString(ordinal))
+	}
+	return ordinal, length, value[:size], nil
+}
+
+// encodeRecord serializes a single log record, a nil value encodes a tombstone
+func encodeRecord(ordinal uint64, value []byte) []byte {
+	length := uint32(len(value))
+	if value == nil {
+		length = tombstoneLength
+	}
+	record := make([]byte, recordHeaderSize+len(value)+recordTrailerSize)
+	binary.BigEndian.PutUint64(record[0:8], ordinal)
+	binary.BigEndian.PutUint32(record[8:12], length)
+	copy(record[recordHeaderSize:], value)
+	sum := crc32.ChecksumIEEE(record[:recordHeaderSize+len(value)])
+	binary.BigEndian.PutUint32(record[recordHeaderSize+len(value):], sum)
+	return record
+}
+
+// every runs fn on a fixed interval until the cache is closed
+func (c *FileCache) every(interval time.Duration, fn func() error) {
+	defer c.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			if err := fn(); err != nil {
+				log.Errorf("File cache background task failed: %s", err)
+			}
+		}
+	}
+}
+
+func (c *FileCache) Write(ordinal uint64, value *fibonacci.Number) error {
+	return c.write(logging.Default(), ordinal, value)
+}
+
+func (c *FileCache) write(logger *log.Entry, ordinal uint64, value *fibonacci.Number) error {
+	// Bytes returns an empty (but non-nil) slice for zero so it can't be mistaken for a tombstone
+	if err := c.append(ordinal, encodeRecord(ordinal, append([]byte{}, value.Bytes()...)), false); err != nil {
+		return fmt.Errorf("failed to append cache entry: %w", err)
+	}
+	logger.Debugf("Wrote cache entry for ordinal=%s", fibonacci.Uint64ToString(ordinal))
+	return nil
+}
+
+// WriteBatch appends all of the entries with a single write (and a single fsync)
+func (c *FileCache) WriteBatch(entries []fibonacci.Entry) error {
+	return c.writeBatch(logging.Default(), entries)
+}
+
+func (c *FileCache) writeBatch(logger *log.Entry, entries []fibonacci.Entry) error {
+	var buf []byte
+	offsets := make([]int64, len(entries))
+	for i, e := range entries {
+		offsets[i] = int64(len(buf))
+		buf = append(buf, encodeRecord(e.Ordinal, append([]byte{}, e.Value.Bytes()...))...)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.file.WriteAt(buf, c.size); err != nil {
+		return fmt.Errorf("failed to append cache entries: %w", err)
+	}
+	for i, e := range entries {
+		if _, ok := c.index[e.Ordinal]; ok {
+			c.stale++
+		}
+		c.index[e.Ordinal] = c.size + offsets[i]
+	}
+	c.size += int64(len(buf))
+	c.dirty = true
+	if c.opts.SyncPolicy == SyncAlways {
+		if err := c.syncLocked(); err != nil {
+			return err
+		}
+	}
+	logger.Debugf("Wrote %d cache entries", len(entries))
+	return nil
+}
+
+// Delete appends a tombstone for the ordinal
+func (c *FileCache) Delete(ordinal uint64) error {
+	if err := c.append(ordinal, encodeRecord(ordinal, nil), true); err != nil {
+		return fmt.Errorf("failed to append cache tombstone: %w", err)
+	}
+	log.Debugf("Deleted cache entry for ordinal=%s", fibonacci.Uint64ToString(ordinal))
+	return nil
+}
+
+// append writes a record to the end of the log and updates the index
+func (c *FileCache) append(ordinal uint64, record []byte, tombstone bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.file.WriteAt(record, c.size); err != nil {
+		return err
+	}
+	if _, ok := c.index[ordinal]; ok {
+		c.stale++
+	}
+	if tombstone {
+		delete(c.index, ordinal)
+		c.stale++
+	} else {
+		c.index[ordinal] = c.size
+	}
+	c.size += int64(len(record))
+	c.dirty = true
+	if c.opts.SyncPolicy == SyncAlways {
+		return c.syncLocked()
+	}
+	return nil
+}
+
+func (c *FileCache) Read(ordinal uint64) (*fibonacci.Number, error) {
+	return c.read(logging.Default(), ordinal)
+}
+
+func (c *FileCache) read(logger *log.Entry, ordinal uint64) (*fibonacci.Number, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	offset, ok := c.index[ordinal]
+	if !ok {
+		logger.Debugf("Failed to retrieve cache entry for ordinal=%s: %v", fibonacci.Uint64ToString(ordinal), ErrNotFound)
+		return fibonacci.NewNumber(-1), ErrNotFound
+	}
+	section := io.NewSectionReader(c.file, offset, c.size-offset)
+	_, _, value, err := readRecord(section, c.size-offset)
+	if err != nil {
+		err = fmt.Errorf("failed to read cache entry: %w", err)
+		logger.Error(err)
+		return fibonacci.NewNumber(-1), err
+	}
+	logger.Debugf("Read cache entry for ordinal=%s", fibonacci.Uint64ToString(ordinal))
+	return fibonacci.NewNumber(0).SetBytes(value), nil
+}
+
+// ReadBatch reads the values of the ordinals, missing ones are left out
+// The index is in memory so this only saves the lookups of the entries that aren't cached.
+func (c *FileCache) ReadBatch(ordinals []uint64) (map[uint64]*fibonacci.Number, error) {
+	return c.readBatch(logging.Default(), ordinals)
+}
+
+func (c *FileCache) readBatch(logger *log.Entry, ordinals []uint64) (map[uint64]*fibonacci.Number, error) {
+	values := make(map[uint64]*fibonacci.Number, len(ordinals))
+	for _, ordinal := range ordinals {
+		v, err := c.read(logger, ordinal)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[ordinal] = v
+	}
+	return values, nil
+}
+
+// boundFileCache is a view of a FileCache that logs with the logger of a request
+type boundFileCache struct {
+	*FileCache
+	logger *log.Entry
+}
+
+// WithContext returns a view of the cache that logs with the logger of ctx
+func (c *FileCache) WithContext(ctx context.Context) fibonacci.Memoizer {
+	return &boundFileCache{FileCache: c, logger: logging.FromContext(ctx)}
+}
+
+func (c *boundFileCache) Read(ordinal uint64) (*fibonacci.Number, error) {
+	return c.read(c.logger, ordinal)
+}
+
+func (c *boundFileCache) Write(ordinal uint64, value *fibonacci.Number) error {
+	return c.write(c.logger, ordinal, value)
+}
+
+func (c *boundFileCache) ReadBatch(ordinals []uint64) (map[uint64]*fibonacci.Number, error) {
+	return c.readBatch(c.logger, ordinals)
+}
+
+func (c *boundFileCache) WriteBatch(entries []fibonacci.Entry) error {
+	return c.writeBatch(c.logger, entries)
+}
+
+// Each iterates over the cache entries in ascending ordinal order
+func (c *FileCache) Each(fn func(ordinal uint64, value *fibonacci.Number) error) error {
+	c.mu.RLock()
+	ordinals := make([]uint64, 0, len(c.index))
+	for ordinal := range c.index {
+		ordinals = append(ordinals, ordinal)
+	}
+	c.mu.RUnlock()
+	sortOrdinals(ordinals)
+
+	for _, ordinal := range ordinals {
+		v, err := c.Read(ordinal)
+		if err == ErrNotFound {
+			continue // Cleared since we took the snapshot
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(ordinal, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List describes a page of entries, the log doesn't record timestamps
+func (c *FileCache) List(from uint64, limit int) ([]EntryInfo, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ordinals := make([]uint64, 0, len(c.index))
+	for ordinal := range c.index {
+		if ordinal >= from {
+			ordinals = append(ordinals, ordinal)
+		}
+	}
+	sortOrdinals(ordinals)
+	if len(ordinals) > limit {
+		ordinals = ordinals[:limit]
+	}
+	entries := make([]EntryInfo, 0, len(ordinals))
+	for _, ordinal := range ordinals {
+		info, err := c.infoLocked(ordinal, c.index[ordinal])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *info)
+	}
+	return entries, nil
+}
+
+// Info describes the entry for the ordinal
+func (c *FileCache) Info(ordinal uint64) (*EntryInfo, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	offset, ok := c.index[ordinal]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return c.infoLocked(ordinal, offset)
+}
+
+// infoLocked reads the value length from the header of the record at offset
+func (c *FileCache) infoLocked(ordinal uint64, offset int64) (*EntryInfo, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := c.file.ReadAt(header, offset); err != nil {
+		return nil, fmt.Errorf("failed to read cache entry: %w", err)
+	}
+	return &EntryInfo{
+		Ordinal: ordinal,
+		Size:    int64(binary.BigEndian.Uint32(header[8:12])),
+	}, nil
+}
+
+// Stats reports the live and stale records and the size of the log
+func (c *FileCache) Stats() (*Stats, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	stats := &Stats{
+		Entries:    uint64(len(c.index)),
+		Tombstoned: uint64(c.stale),
+		Bytes:      c.size,
+	}
+	for ordinal := range c.index {
+		if ordinal > stats.MaxOrdinal {
+			stats.MaxOrdinal = ordinal
+		}
+	}
+	return stats, nil
+}
+
+// Clear truncates the log and drops the index
+func (c *FileCache) Clear() error {
+	log.Info("Clearing the file cache.")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate cache log: %w", err)
+	}
+	c.index = make(map[uint64]int64)
+	c.size = 0
+	c.stale = 0
+	c.dirty = true
+	return c.syncLocked()
+}
+
+// Sync flushes any pending writes to stable storage
+func (c *FileCache) Sync() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.syncLocked()
+}
+
+func (c *FileCache) syncLocked() error {
+	if !c.dirty {
+		return nil
+	}
+	if err := c.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync cache log: %w", err)
+	}
+	c.dirty = false
+	return nil
+}
+
+// maybeCompact compacts the log once the share of stale records exceeds the configured ratio
+func (c *FileCache) maybeCompact() error {
+	c.mu.RLock()
+	stale := c.stale
+	total := len(c.index) + stale
+	c.mu.RUnlock()
+	if stale == 0 || float64(stale)/float64(total) < c.opts.CompactRatio {
+		return nil
+	}
+	return c.Compact()
+}
+
+// Compact rewrites the log so that it only contains the latest record for each ordinal
+func (c *FileCache) Compact() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	log.Infof("Compacting file cache (%d live, %d stale records)...", len(c.index), c.stale)
+
+	tmpPath := filepath.Join(c.dir, compactFileName)
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create compacted log: %w", err)
+	}
+	// The partial log is removed when compaction fails so it doesn't take up space until the next one
+	fail := func(format string, err error) error {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf(format, err)
+	}
+	w := bufio.NewWriter(tmp)
+	index := make(map[uint64]int64, len(c.index))
+	offset := int64(0)
+	for ordinal, from := range c.index {
+		header := make([]byte, recordHeaderSize)
+		if _, err := c.file.ReadAt(header, from); err != nil {
+			return fail("failed to read cache entry during compaction: %w", err)
+		}
+		size := recordSize(binary.BigEndian.Uint32(header[8:12]))
+		if _, err := io.Copy(w, io.NewSectionReader(c.file, from, size)); err != nil {
+			return fail("failed to copy cache entry during compaction: %w", err)
+		}
+		index[ordinal] = offset
+		offset += size
+	}
+	if err := w.Flush(); err != nil {
+		return fail("failed to write compacted log: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fail("failed to sync compacted log: %w", err)
+	}
+	if err := os.Rename(tmpPath, c.path()); err != nil {
+		return fail("failed to replace cache log: %w", err)
+	}
+	c.file.Close()
+	c.file = tmp
+	c.index = index
+	c.size = offset
+	c.stale = 0
+	c.dirty = false
+	// The rename is only durable once the directory is synced too
+	if err := syncDir(c.dir); err != nil {
+		return fmt.Errorf("failed to sync cache directory: %w", err)
+	}
+	log.Info("File cache compaction finished.")
+	return nil
+}
+
+// syncDir flushes the entries of a directory to disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Check makes sure that the log file is still open and that it hasn't been removed
+func (c *FileCache) Check(ctx context.Context) map[string]error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	info, err := c.file.Stat()
+	if err == nil {
+		_, err = os.Stat(c.path())
+	}
+	if err == nil && !info.Mode().IsRegular() {
+		err = fmt.Errorf("%s is not a regular file", c.path())
+	}
+	return map[string]error{"file": err}
+}
+
+// Close stops the background tasks, flushes pending writes and closes the log
+// Closing the cache again returns the error of the first Close.
+func (c *FileCache) Close() error {
+	c.closeOnce.Do(func() {
+		log.Info("Closing the file cache.")
+		close(c.stop)
+		c.wg.Wait()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.closeErr = c.syncLocked()
+		if err := c.file.Close(); c.closeErr == nil {
+			c.closeErr = err
+		}
+	})
+	return c.closeErr
+}
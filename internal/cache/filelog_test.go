@@ -0,0 +1,329 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/programmablemike/fibo/internal/fibonacci"
+	"github.com/programmablemike/fibo/internal/logging"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+var fileCacheTestOptions = FileCacheOptions{
+	SyncPolicy:   SyncAlways,
+	CompactRatio: 0.5,
+}
+
+func TestFileCacheReadWriteEntry(t *testing.T) {
+	cache, err := NewFileCache(t.TempDir(), fileCacheTestOptions)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, cache.Close())
+	}()
+	assert.NoError(t, cache.Write(0, fibonacci.NewNumber(0)))
+	assert.NoError(t, cache.Write(1, fibonacci.NewNumber(1)))
+	assert.NoError(t, cache.Write(12, fibonacci.NewNumber(144)))
+
+	v, err := cache.Read(0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fibonacci.NewNumber(0).Cmp(v))
+	v, err = cache.Read(12)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fibonacci.NewNumber(144).Cmp(v))
+	_, err = cache.Read(2)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestFileCacheSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewFileCache(dir, fileCacheTestOptions)
+	assert.NoError(t, err)
+	large, _ := fibonacci.NewNumberFromDecimalString("354224848179261915075")
+	assert.NoError(t, cache.Write(100, large))
+	assert.NoError(t, cache.Write(11, fibonacci.NewNumber(89)))
+	assert.NoError(t, cache.Close())
+
+	cache, err = NewFileCache(dir, fileCacheTestOptions)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, cache.Close())
+	}()
+	v, err := cache.Read(100)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, large.Cmp(v))
+	v, err = cache.Read(11)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fibonacci.NewNumber(89).Cmp(v))
+}
+
+func TestFileCacheTruncatesTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewFileCache(dir, fileCacheTestOptions)
+	assert.NoError(t, err)
+	assert.NoError(t, cache.Write(10, fibonacci.NewNumber(55)))
+	assert.NoError(t, cache.Close())
+
+	// Simulate a crash part way through appending a record
+	f, err := os.OpenFile(filepath.Join(dir, logFileName), os.O_APPEND|os.O_WRONLY, 0o644)
+	assert.NoError(t, err)
+	_, err = f.Write(encodeRecord(11, fibonacci.NewNumber(89).Bytes())[:recordHeaderSize+1])
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	cache, err = NewFileCache(dir, fileCacheTestOptions)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, cache.Close())
+	}()
+	v, err := cache.Read(10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fibonacci.NewNumber(55).Cmp(v))
+	_, err = cache.Read(11)
+	assert.ErrorIs(t, err, ErrNotFound)
+	// New writes must land after the last good record
+	assert.NoError(t, cache.Write(11, fibonacci.NewNumber(89)))
+	v, err = cache.Read(11)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fibonacci.NewNumber(89).Cmp(v))
+}
+
+func TestFileCacheTruncatesTornLength(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewFileCache(dir, fileCacheTestOptions)
+	assert.NoError(t, err)
+	assert.NoError(t, cache.Write(10, fibonacci.NewNumber(55)))
+	assert.NoError(t, cache.Close())
+
+	// A torn header can claim a length far beyond the end of the log
+	f, err := os.OpenFile(filepath.Join(dir, logFileName), os.O_APPEND|os.O_WRONLY, 0o644)
+	assert.NoError(t, err)
+	header := encodeRecord(11, nil)[:recordHeaderSize]
+	copy(header[8:12], []byte{0x7f, 0xff, 0xff, 0xff})
+	_, err = f.Write(header)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	cache, err = NewFileCache(dir, fileCacheTestOptions)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, cache.Close())
+	}()
+	v, err := cache.Read(10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fibonacci.NewNumber(55).Cmp(v))
+	info, err := os.Stat(filepath.Join(dir, logFileName))
+	assert.NoError(t, err)
+	assert.Equal(t, recordSize(1), info.Size())
+}
+
+func TestFileCacheCompact(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewFileCache(dir, fileCacheTestOptions)
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, cache.Write(5, fibonacci.NewNumber(int64(i))))
+	}
+	assert.NoError(t, cache.Write(6, fibonacci.NewNumber(8)))
+	before, err := os.Stat(filepath.Join(dir, logFileName))
+	assert.NoError(t, err)
+
+	assert.NoError(t, cache.maybeCompact())
+	after, err := os.Stat(filepath.Join(dir, logFileName))
+	assert.NoError(t, err)
+	assert.Less(t, after.Size(), before.Size())
+
+	v, err := cache.Read(5)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fibonacci.NewNumber(9).Cmp(v))
+	assert.NoError(t, cache.Close())
+
+	// The compacted log must replay cleanly
+	cache, err = NewFileCache(dir, fileCacheTestOptions)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, cache.Close())
+	}()
+	v, err = cache.Read(6)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fibonacci.NewNumber(8).Cmp(v))
+}
+
+func TestFileCacheCompactFailureRemovesTempFile(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewFileCache(dir, fileCacheTestOptions)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, cache.Close())
+	}()
+	assert.NoError(t, cache.Write(5, fibonacci.NewNumber(5)))
+
+	// The compacted log can't be renamed over a directory
+	assert.NoError(t, os.Remove(filepath.Join(dir, logFileName)))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, logFileName, "blocker"), 0o755))
+	assert.Error(t, cache.Compact())
+	_, err = os.Stat(filepath.Join(dir, compactFileName))
+	assert.True(t, os.IsNotExist(err))
+	v, err := cache.Read(5)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fibonacci.NewNumber(5).Cmp(v))
+}
+
+func TestFileCacheCloseTwice(t *testing.T) {
+	cache, err := NewFileCache(t.TempDir(), DefaultFileCacheOptions)
+	assert.NoError(t, err)
+	assert.NoError(t, cache.Close())
+	assert.NoError(t, cache.Close())
+}
+
+func TestFileCacheClear(t *testing.T) {
+	cache, err := NewFileCache(t.TempDir(), fileCacheTestOptions)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, cache.Close())
+	}()
+	assert.NoError(t, cache.Write(3, fibonacci.NewNumber(2)))
+	assert.NoError(t, cache.Clear())
+	_, err = cache.Read(3)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestFileCacheDeleteSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewFileCache(dir, fileCacheTestOptions)
+	assert.NoError(t, err)
+	assert.NoError(t, cache.Write(0, fibonacci.NewNumber(0)))
+	assert.NoError(t, cache.Write(4, fibonacci.NewNumber(3)))
+	assert.NoError(t, cache.Delete(4))
+	assert.NoError(t, cache.Close())
+
+	cache, err = NewFileCache(dir, fileCacheTestOptions)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, cache.Close())
+	}()
+	_, err = cache.Read(4)
+	assert.ErrorIs(t, err, ErrNotFound)
+	// F(0) is an empty value and must not be mistaken for a tombstone
+	v, err := cache.Read(0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fibonacci.NewNumber(0).Cmp(v))
+
+	assert.NoError(t, cache.Compact())
+	_, err = cache.Read(4)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestFileCacheWriteBatch(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewFileCache(dir, fileCacheTestOptions)
+	assert.NoError(t, err)
+	assert.NoError(t, cache.Write(7, fibonacci.NewNumber(0)))
+	assert.NoError(t, cache.WriteBatch([]fibonacci.Entry{
+		{Ordinal: 6, Value: fibonacci.NewNumber(8)},
+		{Ordinal: 7, Value: fibonacci.NewNumber(13)},
+	}))
+	assert.NoError(t, cache.Close())
+
+	cache, err = NewFileCache(dir, fileCacheTestOptions)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, cache.Close())
+	}()
+	v, err := cache.Read(7)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fibonacci.NewNumber(13).Cmp(v))
+	v, err = cache.Read(6)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fibonacci.NewNumber(8).Cmp(v))
+}
+
+func TestFileCacheReadBatch(t *testing.T) {
+	cache, err := NewFileCache(t.TempDir(), fileCacheTestOptions)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, cache.Close())
+	}()
+	assert.NoError(t, cache.Write(6, fibonacci.NewNumber(8)))
+	assert.NoError(t, cache.Write(7, fibonacci.NewNumber(13)))
+
+	values, err := cache.ReadBatch([]uint64{5, 6, 7})
+	assert.NoError(t, err)
+	assert.Len(t, values, 2)
+	assert.Equal(t, 0, fibonacci.NewNumber(8).Cmp(values[6]))
+	assert.Equal(t, 0, fibonacci.NewNumber(13).Cmp(values[7]))
+}
+
+func TestFileCacheWithContext(t *testing.T) {
+	cache, err := NewFileCache(t.TempDir(), fileCacheTestOptions)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, cache.Close())
+	}()
+	logger, hook := test.NewNullLogger()
+	logger.SetLevel(log.DebugLevel)
+	ctx := logging.NewContext(context.Background(), logger.WithField("request_id", "abc"))
+	bound := cache.WithContext(ctx)
+
+	assert.NoError(t, bound.Write(6, fibonacci.NewNumber(8)))
+	v, err := cache.Read(6)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fibonacci.NewNumber(8).Cmp(v))
+	_, err = bound.Read(7)
+	assert.ErrorIs(t, err, ErrNotFound)
+	// The bound view logs with the logger of the request
+	if assert.Len(t, hook.AllEntries(), 2) {
+		for _, e := range hook.AllEntries() {
+			assert.Equal(t, "abc", e.Data["request_id"])
+		}
+	}
+}
+
+func TestFileCacheInspect(t *testing.T) {
+	cache, err := NewFileCache(t.TempDir(), fileCacheTestOptions)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, cache.Close())
+	}()
+	for i := uint64(0); i < 10; i++ {
+		assert.NoError(t, cache.Write(i, fibonacci.FastDoubling(i)))
+	}
+	assert.NoError(t, cache.Write(100, fibonacci.FastDoubling(100)))
+	assert.NoError(t, cache.Write(9, fibonacci.FastDoubling(9)))
+	assert.NoError(t, cache.Delete(8))
+
+	entries, err := cache.List(6, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []EntryInfo{{Ordinal: 6, Size: 1}, {Ordinal: 7, Size: 1}, {Ordinal: 9, Size: 1}}, entries)
+	entries, err = cache.List(10, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []EntryInfo{{Ordinal: 100, Size: 9}}, entries)
+
+	info, err := cache.Info(0)
+	assert.NoError(t, err)
+	assert.Equal(t, &EntryInfo{Ordinal: 0, Size: 0}, info)
+	_, err = cache.Info(8)
+	assert.Equal(t, ErrNotFound, err)
+
+	stats, err := cache.Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(10), stats.Entries)
+	assert.Equal(t, uint64(3), stats.Tombstoned) // The overwritten 9, the deleted 8 and its tombstone
+	assert.Equal(t, uint64(100), stats.MaxOrdinal)
+	assert.Equal(t, cache.size, stats.Bytes)
+}
+
+func TestFileCacheCheck(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewFileCache(dir, fileCacheTestOptions)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]error{"file": nil}, cache.Check(context.Background()))
+
+	assert.NoError(t, os.Remove(filepath.Join(dir, logFileName)))
+	assert.Error(t, cache.Check(context.Background())["file"])
+	assert.NoError(t, cache.Close())
+	assert.Error(t, cache.Check(context.Background())["file"])
+}
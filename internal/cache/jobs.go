@@ -0,0 +1,189 @@
+// Persists asynchronous jobs next to the cached values so they survive restarts
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/programmablemike/fibo/internal/jobs"
+	gorm "gorm.io/gorm"
+	clause "gorm.io/gorm/clause"
+)
+
+// jobsDirName is the directory under the file cache that holds one JSON file per job
+const jobsDirName = "jobs"
+
+// storedJob adds the result to the JSON representation of a job
+type storedJob struct {
+	*jobs.Job
+	Result string `json:"result,omitempty"`
+}
+
+func marshalJob(job *jobs.Job) ([]byte, error) {
+	return json.Marshal(storedJob{Job: job, Result: job.Result})
+}
+
+func unmarshalJob(data []byte) (*jobs.Job, error) {
+	stored := storedJob{Job: new(jobs.Job)}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to decode job: %w", err)
+	}
+	stored.Job.Result = stored.Result
+	return stored.Job, nil
+}
+
+// validJobID rejects IDs that could escape the jobs directory
+func validJobID(id string) bool {
+	return id != "" && !strings.ContainsAny(id, `/\.`)
+}
+
+// SaveJob upserts the job row
+func (c *Cache) SaveJob(job *jobs.Job) error {
+	return c.db.Clauses(clause.OnConflict{
+		UpdateAll: true,
+	}).Create(job).Error
+}
+
+func (c *Cache) LoadJob(id string) (*jobs.Job, error) {
+	job := new(jobs.Job)
+	err := c.db.Where("id = ?", id).First(job).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, jobs.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (c *Cache) UnfinishedJobs() ([]*jobs.Job, error) {
+	var unfinished []*jobs.Job
+	err := c.db.Where("state IN ?", []jobs.State{jobs.StatePending, jobs.StateRunning}).
+		Order("created_at").Find(&unfinished).Error
+	return unfinished, err
+}
+
+func (c *FileCache) jobPath(id string) string {
+	return filepath.Join(c.dir, jobsDirName, id+".json")
+}
+
+// SaveJob writes the job to a temporary file and renames it so a crash never leaves a partial file
+func (c *FileCache) SaveJob(job *jobs.Job) error {
+	if !validJobID(job.ID) {
+		return fmt.Errorf("invalid job ID %q", job.ID)
+	}
+	data, err := marshalJob(job)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(c.dir, jobsDirName)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create jobs directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, job.ID+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to save job: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save job: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save job: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.jobPath(job.ID)); err != nil {
+		return fmt.Errorf("failed to save job: %w", err)
+	}
+	return nil
+}
+
+func (c *FileCache) LoadJob(id string) (*jobs.Job, error) {
+	if !validJobID(id) {
+		return nil, jobs.ErrNotFound
+	}
+	data, err := os.ReadFile(c.jobPath(id))
+	if os.IsNotExist(err) {
+		return nil, jobs.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load job: %w", err)
+	}
+	return unmarshalJob(data)
+}
+
+func (c *FileCache) UnfinishedJobs() ([]*jobs.Job, error) {
+	paths, err := filepath.Glob(filepath.Join(c.dir, jobsDirName, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var unfinished []*jobs.Job
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load job: %w", err)
+		}
+		job, err := unmarshalJob(data)
+		if err != nil {
+			return nil, err
+		}
+		if !job.State.Finished() {
+			unfinished = append(unfinished, job)
+		}
+	}
+	return unfinished, nil
+}
+
+func (c *RedisCache) jobKey(id string) string {
+	return c.opts.Prefix + "job:" + id
+}
+
+// SaveJob stores the job as JSON, jobs never expire because clients poll them
+func (c *RedisCache) SaveJob(job *jobs.Job) error {
+	data, err := marshalJob(job)
+	if err != nil {
+		return err
+	}
+	if err := c.client.Set(context.Background(), c.jobKey(job.ID), data, 0).Err(); err != nil {
+		return fmt.Errorf("failed to save job: %w", err)
+	}
+	return nil
+}
+
+func (c *RedisCache) LoadJob(id string) (*jobs.Job, error) {
+	data, err := c.client.Get(context.Background(), c.jobKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, jobs.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load job: %w", err)
+	}
+	return unmarshalJob(data)
+}
+
+func (c *RedisCache) UnfinishedJobs() ([]*jobs.Job, error) {
+	var unfinished []*jobs.Job
+	prefix := c.opts.Prefix + "job:"
+	err := c.scan(prefix+"*", func(keys []string) error {
+		for _, key := range keys {
+			job, err := c.LoadJob(strings.TrimPrefix(key, prefix))
+			if err == jobs.ErrNotFound {
+				continue // Removed since the scan
+			}
+			if err != nil {
+				return err
+			}
+			if !job.State.Finished() {
+				unfinished = append(unfinished, job)
+			}
+		}
+		return nil
+	})
+	return unfinished, err
+}
@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/programmablemike/fibo/internal/jobs"
+	"github.com/stretchr/testify/assert"
+)
+
+// testJobStore exercises the jobs.Store methods of a backend
+func testJobStore(t *testing.T, store jobs.Store) {
+	now := time.Now().UTC().Truncate(time.Second)
+	running := &jobs.Job{ID: "a1", Kind: jobs.KindSequence, State: jobs.StateRunning, From: 1, To: 3, Progress: 0.5, CreatedAt: now, UpdatedAt: now}
+	done := &jobs.Job{ID: "b2", Kind: jobs.KindCalculate, State: jobs.StateSucceeded, Ordinal: 12, Result: "144", CreatedAt: now, UpdatedAt: now}
+	assert.NoError(t, store.SaveJob(running))
+	assert.NoError(t, store.SaveJob(done))
+
+	job, err := store.LoadJob("b2")
+	assert.NoError(t, err)
+	assert.Equal(t, done, job)
+	_, err = store.LoadJob("missing")
+	assert.ErrorIs(t, err, jobs.ErrNotFound)
+
+	unfinished, err := store.UnfinishedJobs()
+	assert.NoError(t, err)
+	assert.Equal(t, []*jobs.Job{running}, unfinished)
+
+	running.State = jobs.StateCancelled
+	assert.NoError(t, store.SaveJob(running))
+	unfinished, err = store.UnfinishedJobs()
+	assert.NoError(t, err)
+	assert.Empty(t, unfinished)
+}
+
+func TestFileCacheJobStore(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewFileCache(dir, fileCacheTestOptions)
+	assert.NoError(t, err)
+	testJobStore(t, cache)
+	assert.NoError(t, cache.Close())
+
+	// Jobs are kept outside of the log so they survive a restart and a clear
+	cache, err = NewFileCache(dir, fileCacheTestOptions)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, cache.Close())
+	}()
+	assert.NoError(t, cache.Clear())
+	job, err := cache.LoadJob("b2")
+	assert.NoError(t, err)
+	assert.Equal(t, "144", job.Result)
+	_, err = cache.LoadJob("../b2")
+	assert.ErrorIs(t, err, jobs.ErrNotFound)
+}
+
+func TestRedisCacheJobStore(t *testing.T) {
+	cache, server := newTestRedisCache(t, DefaultRedisCacheOptions)
+	testJobStore(t, cache)
+	assert.True(t, server.Exists("fibo:job:a1"))
+}
@@ -2,14 +2,20 @@
 package cache
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"io"
+	"strings"
 	"time"
 
 	"github.com/programmablemike/fibo/internal/fibonacci"
+	"github.com/programmablemike/fibo/internal/jobs"
+	"github.com/programmablemike/fibo/internal/logging"
 	log "github.com/sirupsen/logrus"
 	pg "gorm.io/driver/postgres"
 	gorm "gorm.io/gorm"
-	clause "gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
@@ -17,50 +23,107 @@ type CacheEntry struct {
 	gorm.Model
 	Ordinal uint64 `gorm:"index"` // The fibonacci ordinal N
 	Value   string // The fibonacci value - we use string to represent arbitrary precision
+	Chunks  int    // Number of CacheChunk rows holding the value, Value is empty when this isn't 0
+}
+
+// CacheChunk holds a slice of the decimal digits of a large value
+//
+// Chunks are deleted together with their entry so they don't need a soft-delete timestamp.
+type CacheChunk struct {
+	ID      uint   `gorm:"primaryKey"`
+	EntryID uint   `gorm:"index:idx_cache_chunks_entry_seq,priority:1"` // The CacheEntry the chunk belongs to
+	Seq     int    `gorm:"index:idx_cache_chunks_entry_seq,priority:2"` // Position of the chunk in the value
+	Data    string // The decimal digits in this chunk
 }
 
 func (c CacheEntry) String() string {
 	return fmt.Sprintf("CacheEntry<%s %s>", fibonacci.Uint64ToString(c.Ordinal), c.Value)
 }
 
+// eachPageSize is the number of rows loaded per query when iterating over the cache
+const eachPageSize = 500
+
+// chunkInsertBatch is the number of chunks inserted per statement
+const chunkInsertBatch = 8
+
+// CacheOptions configures how the Postgres cache stores values
+type CacheOptions struct {
+	ChunkThreshold int // Values with more decimal digits than this are split into chunks (0 never chunks)
+	ChunkSize      int // Number of decimal digits per chunk
+}
+
+// DefaultCacheOptions chunk values above 1 MiB, roughly F(5*10^6) and up
+var DefaultCacheOptions = CacheOptions{
+	ChunkThreshold: 1 << 20,
+	ChunkSize:      256 << 10,
+}
+
 // Cache implements a PostgresDB cache for pre-computed ordinal values
+//
+// Values larger than the chunk threshold are stored in CacheChunk rows so that they can be
+// streamed with StreamValue instead of being loaded into memory in one piece.
 type Cache struct {
-	db          *gorm.DB
-	initialized bool
+	db   *gorm.DB
+	opts CacheOptions
+
+	migrating   chan struct{} // Holds a token while the schema is migrated
+	initialized bool          // Whether the schema has been migrated
+
+	requestLog *log.Entry // Logger of the request the cache is bound to by WithContext
+}
+
+// logger returns the logger of the bound request, or the default one
+func (c *Cache) logger() *log.Entry {
+	if c.requestLog != nil {
+		return c.requestLog
+	}
+	return logging.Default()
 }
 
 // NewCache creates a new cache with persistent database connection
-func NewCache(dsn string) *Cache {
+// A database that stays unreachable or a failed schema migration doesn't stop the cache from being
+// created, Check retries it and reports the cache as unusable until it succeeds.
+func NewCache(dsn string, opts CacheOptions) (*Cache, error) {
 	log.Debugf("Connecting to postgres with DSN=%s", dsn)
 	db, err := gorm.Open(pg.Open(dsn), &gorm.Config{
 		// This turns off the default logging which is too verbose for records that don't exist
 		Logger: logger.Default.LogMode(logger.Silent),
 	})
 	if err != nil {
-		log.Errorf("Failed to connect to database: %s", err)
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 	log.Info("Successfully connected to database.")
+	if err := db.Use(tracingPlugin{}); err != nil {
+		return nil, fmt.Errorf("failed to set up the tracing of the database: %w", err)
+	}
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultCacheOptions.ChunkSize
+	}
 	cache := &Cache{
 		db:          db,
+		opts:        opts,
+		migrating:   make(chan struct{}, 1),
 		initialized: false,
 	}
 	if err := cache.init(); err != nil {
 		log.Errorf("Failed to initialize the database: %s", err)
 	}
-	return cache
+	return cache, nil
 }
 
 // init the cache database
 func (c *Cache) init() error {
 	log.Info("Initializing the database...")
+	c.migrating <- struct{}{}
+	defer func() { <-c.migrating }()
 	if c.initialized {
 		log.Warning("Cannot re-initiliaze the database... skipping.")
 		return nil
 	}
 	if err := c.initWaitForDatabase(); err != nil {
-		log.Fatal("Timed out while waiting for database to become available")
+		return err
 	}
-	if err := c.initTables(); err != nil {
+	if err := c.initTables(context.Background()); err != nil {
 		log.Error("Failed to initialize the database schema.")
 		return err
 	}
@@ -74,8 +137,7 @@ func (c *Cache) initWaitForDatabase() error {
 	timeoutAt := time.Now().Add(20 * time.Second)
 	for {
 		if time.Now().After(timeoutAt) {
-			log.Fatal("Failed to connect to the database within 20 seconds")
-			return fmt.Errorf("failed to connect to the database")
+			return fmt.Errorf("failed to connect to the database within 20 seconds")
 		}
 		log.Info("Trying to connect to database...")
 		db, err := c.db.DB()
@@ -94,13 +156,54 @@ func (c *Cache) initWaitForDatabase() error {
 	return nil
 }
 
-// initSchema creates the table schema
-func (c *Cache) initTables() error {
-	c.db.AutoMigrate(&CacheEntry{})
+// initTables creates the table schema
+func (c *Cache) initTables(ctx context.Context) error {
+	if err := c.db.WithContext(ctx).AutoMigrate(&CacheEntry{}, &CacheChunk{}, &jobs.Job{}); err != nil {
+		return err
+	}
 	log.Info("Successfully initialized the table schemas.")
 	return nil
 }
 
+// Check pings the database and retries the schema migration if it failed before
+func (c *Cache) Check(ctx context.Context) map[string]error {
+	checks := map[string]error{"postgres": nil, "migrations": nil}
+	db, err := c.db.DB()
+	if err == nil {
+		err = db.PingContext(ctx)
+	}
+	if err != nil {
+		checks["postgres"] = err
+		checks["migrations"] = errors.New("the database is unreachable")
+		return checks
+	}
+	// A probe doesn't queue up behind a migration that's still running
+	select {
+	case c.migrating <- struct{}{}:
+		defer func() { <-c.migrating }()
+	case <-ctx.Done():
+		checks["migrations"] = ctx.Err()
+		return checks
+	}
+	if !c.initialized {
+		if err := c.initTables(ctx); err != nil {
+			checks["migrations"] = err
+			return checks
+		}
+		c.initialized = true
+	}
+	return checks
+}
+
+// PoolStats returns the statistics of the database connection pool
+func (c *Cache) PoolStats() sql.DBStats {
+	db, err := c.db.DB()
+	if err != nil {
+		return sql.DBStats{}
+	}
+	return db.Stats()
+}
+
 func (c *Cache) Close() error {
 	log.Info("Closing the database connection.")
 	db, _ := c.db.DB()
@@ -111,36 +214,314 @@ func (c *Cache) Clear() error {
 	log.Info("Clearing the database.")
 	// Deletes all cache entries
 	// Note that this only "tombstones" the entries in Gorm by adding a "deleted_at" timestamp
-	c.db.Where("1 = 1").Delete(&CacheEntry{})
+	return c.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("1 = 1").Delete(&CacheChunk{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("1 = 1").Delete(&CacheEntry{}).Error
+	})
+}
+
+// Delete tombstones every row for the ordinal
+func (c *Cache) Delete(ordinal uint64) error {
+	err := c.db.Transaction(func(tx *gorm.DB) error {
+		return deleteEntries(tx, []uint64{ordinal})
+	})
+	if err != nil {
+		return err
+	}
+	c.logger().Debugf("Deleted cache entry for ordinal=%s", fibonacci.Uint64ToString(ordinal))
 	return nil
 }
 
-func (c *Cache) Write(ordinal uint64, value *fibonacci.Number) error {
+// deleteEntries tombstones the rows for the ordinals and removes their chunks
+func deleteEntries(tx *gorm.DB, ordinals []uint64) error {
+	ids := tx.Session(&gorm.Session{NewDB: true}).Model(&CacheEntry{}).Select("id").Where("ordinal IN ?", ordinals)
+	if err := tx.Where("entry_id IN (?)", ids).Delete(&CacheChunk{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("ordinal IN ?", ordinals).Delete(&CacheEntry{}).Error
+}
+
+// chunked returns true if the decimal value is stored in chunks
+func (c *Cache) chunked(value string) bool {
+	return c.opts.ChunkThreshold > 0 && len(value) > c.opts.ChunkThreshold
+}
+
+// createChunkedEntry inserts an entry and the chunks of its value
+func (c *Cache) createChunkedEntry(tx *gorm.DB, ordinal uint64, value string) error {
 	entry := &CacheEntry{
 		Ordinal: ordinal,
-		Value:   value.String(),
+		Chunks:  (len(value) + c.opts.ChunkSize - 1) / c.opts.ChunkSize,
+	}
+	if err := tx.Create(entry).Error; err != nil {
+		return err
 	}
-	c.db.Clauses(clause.OnConflict{
-		UpdateAll: true,
-	}).Create(entry)
-	log.Debugf("Wrote cache entry for ordinal=%s", fibonacci.Uint64ToString(ordinal))
+	chunks := make([]CacheChunk, 0, entry.Chunks)
+	for seq := 0; len(value) > 0; seq++ {
+		n := c.opts.ChunkSize
+		if n > len(value) {
+			n = len(value)
+		}
+		chunks = append(chunks, CacheChunk{
+			EntryID: entry.ID,
+			Seq:     seq,
+			Data:    value[:n],
+		})
+		value = value[n:]
+	}
+	return tx.CreateInBatches(chunks, chunkInsertBatch).Error
+}
+
+// Write replaces the rows for the ordinal with a row for the value
+func (c *Cache) Write(ordinal uint64, value *fibonacci.Number) error {
+	if err := c.writeEntries([]fibonacci.Entry{{Ordinal: ordinal, Value: value}}); err != nil {
+		return err
+	}
+	c.logger().Debugf("Wrote cache entry for ordinal=%s", fibonacci.Uint64ToString(ordinal))
 	return nil
 }
 
+// WriteBatch writes entries with multi-row inserts instead of an INSERT per entry
+func (c *Cache) WriteBatch(entries []fibonacci.Entry) error {
+	if err := c.writeEntries(entries); err != nil {
+		return err
+	}
+	c.logger().Debugf("Wrote %d cache entries", len(entries))
+	return nil
+}
+
+// writeEntries replaces the rows for the ordinals of the entries in a single transaction
+func (c *Cache) writeEntries(entries []fibonacci.Entry) error {
+	rows := make([]CacheEntry, 0, len(entries))
+	large := make(map[uint64]string)
+	ordinals := make([]uint64, len(entries))
+	for i, e := range entries {
+		ordinals[i] = e.Ordinal
+		v := e.Value.String()
+		if c.chunked(v) {
+			large[e.Ordinal] = v
+			continue
+		}
+		rows = append(rows, CacheEntry{
+			Ordinal: e.Ordinal,
+			Value:   v,
+		})
+	}
+	return c.db.Transaction(func(tx *gorm.DB) error {
+		// Read returns the oldest row for an ordinal so any existing rows have to be tombstoned first
+		if err := deleteEntries(tx, ordinals); err != nil {
+			return err
+		}
+		for ordinal, v := range large {
+			if err := c.createChunkedEntry(tx, ordinal, v); err != nil {
+				return err
+			}
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(rows, eachPageSize).Error
+	})
+}
+
 func (c *Cache) Read(ordinal uint64) (*fibonacci.Number, error) {
 	entry := new(CacheEntry)
 	result := c.db.Where("ordinal = ?", ordinal).First(entry)
 	if result.Error != nil {
-		log.Debugf("Failed to retrieve cache entry for ordinal=%s: %v", fibonacci.Uint64ToString(ordinal), result.Error)
+		c.logger().Debugf("Failed to retrieve cache entry for ordinal=%s: %v", fibonacci.Uint64ToString(ordinal), result.Error)
 		return fibonacci.NewNumber(-1), result.Error
 	}
-	log.Debugf("Successfully retrieved cached value for ordinal=%s", fibonacci.Uint64ToString(ordinal))
-	v, ok := fibonacci.NewNumberFromDecimalString(entry.Value)
-	if !ok {
-		err := fmt.Errorf("failed to convert %s to a *fibonacci.Number", entry.Value)
-		log.Error(err)
+	c.logger().Debugf("Successfully retrieved cached value for ordinal=%s", fibonacci.Uint64ToString(ordinal))
+	v, err := c.entryValue(entry)
+	if err != nil {
+		c.logger().Error(err)
 		return fibonacci.NewNumber(-1), err
 	}
-	log.Debugf("Read cache entry for ordinal=%s", fibonacci.Uint64ToString(ordinal))
+	c.logger().Debugf("Read cache entry for ordinal=%s", fibonacci.Uint64ToString(ordinal))
+	return v, nil
+}
+
+// ReadBatch reads the values of the ordinals with one query per eachPageSize ordinals
+func (c *Cache) ReadBatch(ordinals []uint64) (map[uint64]*fibonacci.Number, error) {
+	values := make(map[uint64]*fibonacci.Number, len(ordinals))
+	for start := 0; start < len(ordinals); start += eachPageSize {
+		end := start + eachPageSize
+		if end > len(ordinals) {
+			end = len(ordinals)
+		}
+		var entries []CacheEntry
+		if err := c.db.Where("ordinal IN ?", ordinals[start:end]).Order("ordinal, id").Find(&entries).Error; err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			// Only the oldest row of an ordinal is returned to match Read
+			if _, ok := values[entry.Ordinal]; ok {
+				continue
+			}
+			v, err := c.entryValue(&entry)
+			if err != nil {
+				return nil, err
+			}
+			values[entry.Ordinal] = v
+		}
+	}
+	c.logger().Debugf("Read %d of %d cache entries", len(values), len(ordinals))
+	return values, nil
+}
+
+// Each iterates over the cache entries one page at a time so the whole table is never loaded at once
+func (c *Cache) Each(fn func(ordinal uint64, value *fibonacci.Number) error) error {
+	started := false
+	last := uint64(0)
+	for {
+		var entries []CacheEntry
+		query := c.db.Order("ordinal, id").Limit(eachPageSize)
+		if started {
+			query = query.Where("ordinal > ?", last)
+		}
+		if err := query.Find(&entries).Error; err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			// Rows are never updated in place so an ordinal can have several rows
+			// We only return the oldest one to match Read
+			if started && entry.Ordinal == last {
+				continue
+			}
+			v, err := c.entryValue(&entry)
+			if err != nil {
+				return err
+			}
+			if err := fn(entry.Ordinal, v); err != nil {
+				return err
+			}
+			started = true
+			last = entry.Ordinal
+		}
+		if len(entries) < eachPageSize {
+			return nil
+		}
+	}
+}
+
+// entryInfoColumns selects an EntryInfo, the size of a chunked value is the sum of its chunks
+const entryInfoColumns = `DISTINCT ON (ordinal) ordinal, created_at, updated_at,
+	CASE WHEN chunks > 0
+		THEN (SELECT COALESCE(SUM(LENGTH(data)), 0) FROM cache_chunks WHERE cache_chunks.entry_id = cache_entries.id)
+		ELSE LENGTH(value)
+	END AS size`
+
+// List describes a page of entries, only the oldest row of an ordinal is listed to match Read
+func (c *Cache) List(from uint64, limit int) ([]EntryInfo, error) {
+	var entries []EntryInfo
+	err := c.db.Model(&CacheEntry{}).Select(entryInfoColumns).
+		Where("ordinal >= ?", from).Order("ordinal, id").Limit(limit).
+		Scan(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Info describes the oldest row of the ordinal
+func (c *Cache) Info(ordinal uint64) (*EntryInfo, error) {
+	var entries []EntryInfo
+	err := c.db.Model(&CacheEntry{}).Select(entryInfoColumns).
+		Where("ordinal = ?", ordinal).Order("ordinal, id").Limit(1).
+		Scan(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrNotFound
+	}
+	return &entries[0], nil
+}
+
+// Stats counts the live and tombstoned rows, Bytes is the size of the tables including indexes and TOAST
+func (c *Cache) Stats() (*Stats, error) {
+	var live, tombstoned int64
+	if err := c.db.Model(&CacheEntry{}).Count(&live).Error; err != nil {
+		return nil, err
+	}
+	if err := c.db.Unscoped().Model(&CacheEntry{}).Where("deleted_at IS NOT NULL").Count(&tombstoned).Error; err != nil {
+		return nil, err
+	}
+	stats := &Stats{
+		Entries:    uint64(live),
+		Tombstoned: uint64(tombstoned),
+	}
+	if err := c.db.Model(&CacheEntry{}).Select("COALESCE(MAX(ordinal), 0)").Scan(&stats.MaxOrdinal).Error; err != nil {
+		return nil, err
+	}
+	err := c.db.Raw("SELECT pg_total_relation_size('cache_entries') + pg_total_relation_size('cache_chunks')").Scan(&stats.Bytes).Error
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// StreamValue writes the decimal digits of the value to w one chunk at a time
+func (c *Cache) StreamValue(ordinal uint64, w io.Writer) (int64, error) {
+	entry := new(CacheEntry)
+	err := c.db.Where("ordinal = ?", ordinal).First(entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	if entry.Chunks == 0 {
+		n, err := io.WriteString(w, entry.Value)
+		return int64(n), err
+	}
+	return c.streamChunks(entry, w)
+}
+
+// entryValue converts the stored value of an entry, loading its chunks if it has any
+func (c *Cache) entryValue(entry *CacheEntry) (*fibonacci.Number, error) {
+	value := entry.Value
+	if entry.Chunks > 0 {
+		var sb strings.Builder
+		if _, err := c.streamChunks(entry, &sb); err != nil {
+			return nil, err
+		}
+		value = sb.String()
+	}
+	v, ok := fibonacci.NewNumberFromDecimalString(value)
+	if !ok {
+		return nil, fmt.Errorf("failed to convert the value for ordinal=%s to a *fibonacci.Number", fibonacci.Uint64ToString(entry.Ordinal))
+	}
 	return v, nil
 }
+
+// streamChunks copies the chunks of an entry to w in order without holding more than one in memory
+func (c *Cache) streamChunks(entry *CacheEntry, w io.Writer) (int64, error) {
+	rows, err := c.db.Model(&CacheChunk{}).Select("data").Where("entry_id = ?", entry.ID).Order("seq").Rows()
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	written := int64(0)
+	seen := 0
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return written, err
+		}
+		n, err := io.WriteString(w, data)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		seen++
+	}
+	if err := rows.Err(); err != nil {
+		return written, err
+	}
+	if seen != entry.Chunks {
+		return written, fmt.Errorf("cache entry for ordinal=%s has %d of %d chunks", fibonacci.Uint64ToString(entry.Ordinal), seen, entry.Chunks)
+	}
+	return written, nil
+}
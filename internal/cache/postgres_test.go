@@ -1,27 +1,39 @@
 package cache
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/ory/dockertest"
 	"github.com/programmablemike/fibo/internal/fibonacci"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
 	pg "gorm.io/driver/postgres"
 	gorm "gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
 var database string = "fibo_test"
-var connString string
+var connString string // Empty when Docker is unavailable
 
 func TestMain(m *testing.M) {
 	pool, err := dockertest.NewPool("")
-
+	if err == nil {
+		err = pool.Client.Ping()
+	}
 	if err != nil {
-		log.Fatalf("Could not connect to docker: %s", err)
+		// The other backends don't need Docker, only the Postgres tests are skipped
+		log.Printf("Could not connect to docker, skipping the Postgres tests: %s", err)
+		os.Exit(m.Run())
 	}
 
 	resource, err := pool.Run("postgres", "9.6", []string{"POSTGRES_PASSWORD=secret", "POSTGRES_DB=" + database})
@@ -56,15 +68,38 @@ func TestMain(m *testing.M) {
 	os.Exit(retCode)
 }
 
+// requirePostgres skips a test when TestMain couldn't start the Postgres container
+func requirePostgres(t *testing.T) {
+	t.Helper()
+	if connString == "" {
+		t.Skip("Docker is unavailable")
+	}
+}
+
 func TestCreateCache(t *testing.T) {
-	cache := NewCache(connString)
+	requirePostgres(t)
+	cache, err := NewCache(connString, DefaultCacheOptions)
+	assert.NoError(t, err)
 	defer func() {
 		assert.NoError(t, cache.Close())
 	}()
 }
 
+func TestCheck(t *testing.T) {
+	requirePostgres(t)
+	cache, err := NewCache(connString, DefaultCacheOptions)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]error{"postgres": nil, "migrations": nil}, cache.Check(context.Background()))
+	assert.NoError(t, cache.Close())
+	checks := cache.Check(context.Background())
+	assert.Error(t, checks["postgres"])
+	assert.Error(t, checks["migrations"])
+}
+
 func TestReadWriteEntry(t *testing.T) {
-	cache := NewCache(connString)
+	requirePostgres(t)
+	cache, err := NewCache(connString, DefaultCacheOptions)
+	assert.NoError(t, err)
 	defer func() {
 		assert.NoError(t, cache.Close())
 	}()
@@ -83,3 +118,164 @@ func TestReadWriteEntry(t *testing.T) {
 	assert.Equal(t, fibonacci.NewNumber(1), v)
 	assert.NoError(t, err)
 }
+
+func TestWriteBatch(t *testing.T) {
+	requirePostgres(t)
+	cache, err := NewCache(connString, DefaultCacheOptions)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, cache.Close())
+	}()
+	assert.NoError(t, cache.Write(3, fibonacci.NewNumber(0)))
+	assert.NoError(t, cache.WriteBatch([]fibonacci.Entry{
+		{Ordinal: 3, Value: fibonacci.NewNumber(2)},
+		{Ordinal: 4, Value: fibonacci.NewNumber(3)},
+	}))
+	// The batch must replace the existing row for ordinal 3
+	v, err := cache.Read(3)
+	assert.Equal(t, fibonacci.NewNumber(2), v)
+	assert.NoError(t, err)
+	v, err = cache.Read(4)
+	assert.Equal(t, fibonacci.NewNumber(3), v)
+	assert.NoError(t, err)
+}
+
+func TestReadBatch(t *testing.T) {
+	requirePostgres(t)
+	cache, err := NewCache(connString, DefaultCacheOptions)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, cache.Close())
+	}()
+	assert.NoError(t, cache.Write(8, fibonacci.NewNumber(0)))
+	assert.NoError(t, cache.Write(9, fibonacci.NewNumber(34)))
+	// Rows are never updated in place, the oldest row must win like in Read
+	assert.NoError(t, cache.db.Create(&CacheEntry{Ordinal: 8, Value: "99"}).Error)
+
+	values, err := cache.ReadBatch([]uint64{8, 9, 10})
+	assert.NoError(t, err)
+	assert.Len(t, values, 2)
+	assert.Equal(t, fibonacci.NewNumber(0), values[8])
+	assert.Equal(t, fibonacci.NewNumber(34), values[9])
+}
+
+func TestInspect(t *testing.T) {
+	requirePostgres(t)
+	cache, err := NewCache(connString, CacheOptions{ChunkThreshold: 10, ChunkSize: 4})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, cache.Close())
+	}()
+	before, err := cache.Stats()
+	assert.NoError(t, err)
+	assert.NoError(t, cache.Write(2000, fibonacci.NewNumber(5)))
+	assert.NoError(t, cache.Write(2001, fibonacci.FastDoubling(100))) // 21 digits in 6 chunks
+	assert.NoError(t, cache.db.Create(&CacheEntry{Ordinal: 2000, Value: "99"}).Error)
+	assert.NoError(t, cache.Write(2002, fibonacci.NewNumber(8)))
+	assert.NoError(t, cache.Delete(2002))
+
+	entries, err := cache.List(2000, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, uint64(2000), entries[0].Ordinal)
+		assert.Equal(t, int64(1), entries[0].Size) // The oldest row wins like in Read
+		assert.False(t, entries[0].CreatedAt.IsZero())
+		assert.Equal(t, uint64(2001), entries[1].Ordinal)
+		assert.Equal(t, int64(21), entries[1].Size)
+	}
+	info, err := cache.Info(2001)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(21), info.Size)
+	_, err = cache.Info(2002)
+	assert.Equal(t, ErrNotFound, err)
+
+	stats, err := cache.Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, before.Entries+3, stats.Entries)
+	assert.Equal(t, before.Tombstoned+1, stats.Tombstoned)
+	assert.GreaterOrEqual(t, stats.MaxOrdinal, uint64(2001))
+	assert.Greater(t, stats.Bytes, int64(0))
+}
+
+func TestChunkedEntry(t *testing.T) {
+	requirePostgres(t)
+	cache, err := NewCache(connString, CacheOptions{ChunkThreshold: 100, ChunkSize: 30})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, cache.Close())
+	}()
+	// F(1000) has 209 digits so it's stored in 7 chunks
+	large := fibonacci.FastDoubling(1000)
+	assert.NoError(t, cache.Write(1000, large))
+	assert.NoError(t, cache.WriteBatch([]fibonacci.Entry{
+		{Ordinal: 999, Value: fibonacci.FastDoubling(999)},
+		{Ordinal: 10, Value: fibonacci.NewNumber(55)},
+	}))
+
+	v, err := cache.Read(1000)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, large.Cmp(v))
+	v, err = cache.Read(999)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fibonacci.FastDoubling(999).Cmp(v))
+
+	var sb strings.Builder
+	n, err := cache.StreamValue(1000, &sb)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(large.String())), n)
+	assert.Equal(t, large.String(), sb.String())
+	_, err = cache.StreamValue(1001, &sb)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Writing replaces the chunked and the plain rows
+	assert.NoError(t, cache.Write(1000, fibonacci.FastDoubling(1001)))
+	v, err = cache.Read(1000)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fibonacci.FastDoubling(1001).Cmp(v))
+	assert.NoError(t, cache.Write(10, fibonacci.FastDoubling(999)))
+	v, err = cache.Read(10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fibonacci.FastDoubling(999).Cmp(v))
+
+	// Deleting the entry removes its chunks
+	assert.NoError(t, cache.Delete(1000))
+	var chunks int64
+	assert.NoError(t, cache.db.Model(&CacheChunk{}).Where("entry_id NOT IN (?)", cache.db.Model(&CacheEntry{}).Select("id")).Count(&chunks).Error)
+	assert.Equal(t, int64(0), chunks)
+}
+
+func TestTracing(t *testing.T) {
+	requirePostgres(t)
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	cache, err := NewCache(connString, DefaultCacheOptions)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, cache.Close())
+	}()
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "test")
+	bound := cache.WithContext(ctx)
+	assert.NoError(t, bound.Write(7, fibonacci.NewNumber(13)))
+	_, err = bound.Read(7)
+	assert.NoError(t, err)
+	_, err = bound.Read(12345678)
+	assert.Error(t, err)
+	parent.End()
+
+	var names []string
+	for _, span := range recorder.Ended() {
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			continue
+		}
+		names = append(names, span.Name())
+		assert.Equal(t, codes.Unset, span.Status().Code, "a miss isn't an error")
+		for _, attr := range span.Attributes() {
+			if attr.Key == semconv.DBStatementKey {
+				assert.Contains(t, attr.Value.AsString(), "cache_entries")
+			}
+		}
+	}
+	assert.Equal(t, []string{"postgres.create", "postgres.query", "postgres.query"}, names)
+}
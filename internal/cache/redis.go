@@ -0,0 +1,365 @@
+// Implements an intermediate cache of Fibonacci values using a Redis-protocol (RESP) key/value server
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/programmablemike/fibo/internal/fibonacci"
+	"github.com/programmablemike/fibo/internal/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// readBatchSize is the number of keys read per MGET
+const readBatchSize = 1000
+
+// RedisCacheOptions configures the connection to a RESP server
+type RedisCacheOptions struct {
+	Addr         string        // host:port of the server
+	Password     string        // Optional password sent with AUTH
+	DB           int           // Logical database selected with SELECT
+	Prefix       string        // Prefix for every key written by fibo
+	TTL          time.Duration // Optional expiry of cache entries (0 never expires)
+	PoolSize     int           // Maximum number of open connections (0 opens up to 10 per CPU)
+	DialTimeout  time.Duration // Timeout for establishing a connection
+	ReadTimeout  time.Duration // Timeout for reading the reply of a command (0 never times out)
+	WriteTimeout time.Duration // Timeout for writing a command (0 never times out)
+}
+
+// DefaultRedisCacheOptions are the defaults for a local Redis server
+var DefaultRedisCacheOptions = RedisCacheOptions{
+	Addr:         "localhost:6379",
+	Prefix:       "fibo:",
+	DialTimeout:  5 * time.Second,
+	ReadTimeout:  3 * time.Second,
+	WriteTimeout: 3 * time.Second,
+}
+
+// RedisCache implements a cache for pre-computed ordinal values on any RESP-speaking server
+//
+// Values are stored as raw big-endian bytes under "<prefix>cache:<ordinal>" so that multiple
+// fibo instances pointed at the same server share a single memo. RedisCache is goroutine safe.
+type RedisCache struct {
+	opts   RedisCacheOptions
+	client *redis.Client
+
+	requestLog *log.Entry // Logger of the request the cache is bound to by WithContext
+}
+
+// WithContext returns a view of the cache that logs with the logger of ctx
+func (c *RedisCache) WithContext(ctx context.Context) fibonacci.Memoizer {
+	bound := *c
+	bound.requestLog = logging.FromContext(ctx)
+	return &bound
+}
+
+// logger returns the logger of the bound request, or the default one
+func (c *RedisCache) logger() *log.Entry {
+	if c.requestLog != nil {
+		return c.requestLog
+	}
+	return logging.Default()
+}
+
+// NewRedisCache creates a new cache and verifies that the server is reachable
+func NewRedisCache(opts RedisCacheOptions) (*RedisCache, error) {
+	log.Infof("Connecting to RESP server at %s...", opts.Addr)
+	c := newRedisCache(opts)
+	if err := c.client.Ping(context.Background()).Err(); err != nil {
+		c.client.Close()
+		return nil, fmt.Errorf("failed to connect to RESP server: %w", err)
+	}
+	log.Info("Successfully connected to RESP server.")
+	return c, nil
+}
+
+// newRedisCache creates a cache without connecting to the server
+func newRedisCache(opts RedisCacheOptions) *RedisCache {
+	return &RedisCache{
+		opts: opts,
+		client: redis.NewClient(&redis.Options{
+			Addr:         opts.Addr,
+			Password:     opts.Password,
+			DB:           opts.DB,
+			PoolSize:     opts.PoolSize,
+			DialTimeout:  opts.DialTimeout,
+			ReadTimeout:  clientTimeout(opts.ReadTimeout),
+			WriteTimeout: clientTimeout(opts.WriteTimeout),
+			// A stalled server would make every command wait for the timeouts once per attempt
+			MaxRetries: -1,
+		}),
+	}
+}
+
+// clientTimeout converts a timeout of the options, where 0 never times out, into a go-redis one,
+// where -1 never times out and 0 is the default
+func clientTimeout(timeout time.Duration) time.Duration {
+	if timeout == 0 {
+		return -1
+	}
+	return timeout
+}
+
+func (c *RedisCache) key(ordinal uint64) string {
+	return c.opts.Prefix + "cache:" + fibonacci.Uint64ToString(ordinal)
+}
+
+func (c *RedisCache) Write(ordinal uint64, value *fibonacci.Number) error {
+	if err := c.client.Set(context.Background(), c.key(ordinal), value.Bytes(), c.opts.TTL).Err(); err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	c.logger().Debugf("Wrote cache entry for ordinal=%s", fibonacci.Uint64ToString(ordinal))
+	return nil
+}
+
+// WriteBatch pipelines the SET commands so the whole batch takes a single round trip
+func (c *RedisCache) WriteBatch(entries []fibonacci.Entry) error {
+	ctx := context.Background()
+	pipe := c.client.Pipeline()
+	for _, e := range entries {
+		pipe.Set(ctx, c.key(e.Ordinal), e.Value.Bytes(), c.opts.TTL)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to write cache entries: %w", err)
+	}
+	c.logger().Debugf("Wrote %d cache entries", len(entries))
+	return nil
+}
+
+func (c *RedisCache) Read(ordinal uint64) (*fibonacci.Number, error) {
+	value, err := c.client.Get(context.Background(), c.key(ordinal)).Bytes()
+	if err == redis.Nil {
+		c.logger().Debugf("Failed to retrieve cache entry for ordinal=%s: %v", fibonacci.Uint64ToString(ordinal), ErrNotFound)
+		return fibonacci.NewNumber(-1), ErrNotFound
+	}
+	if err != nil {
+		return fibonacci.NewNumber(-1), fmt.Errorf("failed to read cache entry: %w", err)
+	}
+	c.logger().Debugf("Read cache entry for ordinal=%s", fibonacci.Uint64ToString(ordinal))
+	return fibonacci.NewNumber(0).SetBytes(value), nil
+}
+
+// ReadBatch reads the values of the ordinals with MGET, one round trip per readBatchSize keys
+func (c *RedisCache) ReadBatch(ordinals []uint64) (map[uint64]*fibonacci.Number, error) {
+	values := make(map[uint64]*fibonacci.Number, len(ordinals))
+	for start := 0; start < len(ordinals); start += readBatchSize {
+		end := start + readBatchSize
+		if end > len(ordinals) {
+			end = len(ordinals)
+		}
+		page := ordinals[start:end]
+		keys := make([]string, len(page))
+		for i, ordinal := range page {
+			keys[i] = c.key(ordinal)
+		}
+		items, err := c.client.MGet(context.Background(), keys...).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cache entries: %w", err)
+		}
+		for i, item := range items {
+			if value, ok := item.(string); ok {
+				values[page[i]] = fibonacci.NewNumber(0).SetBytes([]byte(value))
+			}
+		}
+	}
+	c.logger().Debugf("Read %d of %d cache entries", len(values), len(ordinals))
+	return values, nil
+}
+
+// Delete removes the key for the ordinal
+func (c *RedisCache) Delete(ordinal uint64) error {
+	if err := c.client.Del(context.Background(), c.key(ordinal)).Err(); err != nil {
+		return fmt.Errorf("failed to delete cache entry: %w", err)
+	}
+	c.logger().Debugf("Deleted cache entry for ordinal=%s", fibonacci.Uint64ToString(ordinal))
+	return nil
+}
+
+// scan calls fn with every page of keys matching the pattern
+//
+// SCAN is used instead of KEYS or FLUSHDB so that a shared server can hold other data.
+func (c *RedisCache) scan(pattern string, fn func(keys []string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := c.client.Scan(context.Background(), cursor, pattern, 1000).Result()
+		if err != nil {
+			return fmt.Errorf("failed to scan keys: %w", err)
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// ordinals scans the ordinals of the cache entries and sorts them in ascending order
+func (c *RedisCache) ordinals() ([]uint64, error) {
+	var ordinals []uint64
+	prefix := c.opts.Prefix + "cache:"
+	err := c.scan(prefix+"*", func(keys []string) error {
+		for _, key := range keys {
+			ordinal, err := strconv.ParseUint(strings.TrimPrefix(key, prefix), 10, 64)
+			if err != nil {
+				continue // Not one of ours
+			}
+			ordinals = append(ordinals, ordinal)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortOrdinals(ordinals)
+	return ordinals, nil
+}
+
+// Each iterates over the cache entries in ascending ordinal order
+func (c *RedisCache) Each(fn func(ordinal uint64, value *fibonacci.Number) error) error {
+	ordinals, err := c.ordinals()
+	if err != nil {
+		return err
+	}
+	for _, ordinal := range ordinals {
+		v, err := c.Read(ordinal)
+		if err == ErrNotFound {
+			continue // Expired or cleared since the scan
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(ordinal, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sizes returns the length of the value of each ordinal with a pipelined STRLEN
+func (c *RedisCache) sizes(ordinals []uint64) ([]int64, error) {
+	ctx := context.Background()
+	pipe := c.client.Pipeline()
+	cmds := make([]*redis.IntCmd, len(ordinals))
+	for i, ordinal := range ordinals {
+		cmds[i] = pipe.StrLen(ctx, c.key(ordinal))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to read cache entry sizes: %w", err)
+	}
+	sizes := make([]int64, len(cmds))
+	for i, cmd := range cmds {
+		sizes[i] = cmd.Val()
+	}
+	return sizes, nil
+}
+
+// List describes a page of entries, RESP servers don't keep timestamps
+// Every key is scanned to find the page so this is meant for debugging rather than hot paths.
+func (c *RedisCache) List(from uint64, limit int) ([]EntryInfo, error) {
+	ordinals, err := c.ordinals()
+	if err != nil {
+		return nil, err
+	}
+	start := sort.Search(len(ordinals), func(i int) bool { return ordinals[i] >= from })
+	ordinals = ordinals[start:]
+	if len(ordinals) > limit {
+		ordinals = ordinals[:limit]
+	}
+	sizes, err := c.sizes(ordinals)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]EntryInfo, len(ordinals))
+	for i, ordinal := range ordinals {
+		entries[i] = EntryInfo{Ordinal: ordinal, Size: sizes[i]}
+	}
+	return entries, nil
+}
+
+// Info describes the entry for the ordinal
+func (c *RedisCache) Info(ordinal uint64) (*EntryInfo, error) {
+	ctx := context.Background()
+	pipe := c.client.Pipeline()
+	exists := pipe.Exists(ctx, c.key(ordinal))
+	size := pipe.StrLen(ctx, c.key(ordinal))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to read cache entry size: %w", err)
+	}
+	if exists.Val() == 0 {
+		return nil, ErrNotFound
+	}
+	return &EntryInfo{Ordinal: ordinal, Size: size.Val()}, nil
+}
+
+// Stats counts the keys and the bytes of their values, deleted keys don't take any space
+func (c *RedisCache) Stats() (*Stats, error) {
+	ordinals, err := c.ordinals()
+	if err != nil {
+		return nil, err
+	}
+	stats := &Stats{Entries: uint64(len(ordinals))}
+	if len(ordinals) > 0 {
+		stats.MaxOrdinal = ordinals[len(ordinals)-1]
+	}
+	for start := 0; start < len(ordinals); start += readBatchSize {
+		end := start + readBatchSize
+		if end > len(ordinals) {
+			end = len(ordinals)
+		}
+		sizes, err := c.sizes(ordinals[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for _, size := range sizes {
+			stats.Bytes += size
+		}
+	}
+	return stats, nil
+}
+
+// Clear deletes every key under the cache prefix
+func (c *RedisCache) Clear() error {
+	log.Info("Clearing the RESP cache.")
+	return c.scan(c.opts.Prefix+"cache:*", func(keys []string) error {
+		if err := c.client.Del(context.Background(), keys...).Err(); err != nil {
+			return fmt.Errorf("failed to delete cache keys: %w", err)
+		}
+		return nil
+	})
+}
+
+// Check pings the server, the ping is abandoned at the deadline of ctx
+func (c *RedisCache) Check(ctx context.Context) map[string]error {
+	err := c.client.Ping(ctx).Err()
+	if err != nil {
+		err = contextError(ctx, err)
+	}
+	return map[string]error{"redis": err}
+}
+
+// Close closes all pooled connections
+func (c *RedisCache) Close() error {
+	log.Info("Closing the RESP server connections.")
+	return c.client.Close()
+}
+
+// contextError returns the error of ctx when ctx ended a command, else err
+// The deadline of the connection can expire just before ctx is done.
+func contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
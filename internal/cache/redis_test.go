@@ -0,0 +1,203 @@
+package cache
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/programmablemike/fibo/internal/fibonacci"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRedisCache(t *testing.T, opts RedisCacheOptions) (*RedisCache, *miniredis.Miniredis) {
+	server, err := miniredis.Run()
+	assert.NoError(t, err)
+	t.Cleanup(server.Close)
+	opts.Addr = server.Addr()
+	cache, err := NewRedisCache(opts)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, cache.Close())
+	})
+	return cache, server
+}
+
+func TestRedisCacheReadWriteEntry(t *testing.T) {
+	cache, _ := newTestRedisCache(t, DefaultRedisCacheOptions)
+	large, _ := fibonacci.NewNumberFromDecimalString("354224848179261915075")
+	assert.NoError(t, cache.Write(0, fibonacci.NewNumber(0)))
+	assert.NoError(t, cache.Write(100, large))
+
+	v, err := cache.Read(0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fibonacci.NewNumber(0).Cmp(v))
+	v, err = cache.Read(100)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, large.Cmp(v))
+	_, err = cache.Read(1)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestRedisCacheTTL(t *testing.T) {
+	opts := DefaultRedisCacheOptions
+	opts.TTL = time.Minute
+	cache, server := newTestRedisCache(t, opts)
+	assert.NoError(t, cache.Write(12, fibonacci.NewNumber(144)))
+	assert.Equal(t, time.Minute, server.TTL("fibo:cache:12"))
+
+	server.FastForward(2 * time.Minute)
+	_, err := cache.Read(12)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestRedisCacheClearKeepsForeignKeys(t *testing.T) {
+	cache, server := newTestRedisCache(t, DefaultRedisCacheOptions)
+	assert.NoError(t, server.Set("other:key", "untouched"))
+	for i := uint64(0); i < 50; i++ {
+		assert.NoError(t, cache.Write(i, fibonacci.NewNumber(int64(i))))
+	}
+	assert.NoError(t, cache.Clear())
+	_, err := cache.Read(7)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, []string{"other:key"}, server.Keys())
+}
+
+func TestRedisCacheAuth(t *testing.T) {
+	server, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer server.Close()
+	server.RequireAuth("secret")
+
+	opts := DefaultRedisCacheOptions
+	opts.Addr = server.Addr()
+	_, err = NewRedisCache(opts)
+	assert.Error(t, err)
+
+	opts.Password = "secret"
+	cache, err := NewRedisCache(opts)
+	assert.NoError(t, err)
+	assert.NoError(t, cache.Close())
+}
+
+func TestRedisCacheWriteBatch(t *testing.T) {
+	opts := DefaultRedisCacheOptions
+	opts.TTL = time.Hour
+	cache, server := newTestRedisCache(t, opts)
+	entries := make([]fibonacci.Entry, 100)
+	for i := range entries {
+		entries[i] = fibonacci.Entry{Ordinal: uint64(i), Value: fibonacci.FastDoubling(uint64(i))}
+	}
+	assert.NoError(t, cache.WriteBatch(entries))
+	assert.Equal(t, time.Hour, server.TTL("fibo:cache:99"))
+
+	v, err := cache.Read(99)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fibonacci.FastDoubling(99).Cmp(v))
+	assert.NoError(t, cache.Write(100, fibonacci.FastDoubling(100)))
+}
+
+func TestRedisCacheReadBatch(t *testing.T) {
+	cache, _ := newTestRedisCache(t, DefaultRedisCacheOptions)
+	ordinals := make([]uint64, 0, 2*readBatchSize)
+	for i := uint64(0); i < 2*readBatchSize; i++ {
+		ordinals = append(ordinals, i)
+		if i%2 == 0 {
+			assert.NoError(t, cache.Write(i, fibonacci.FastDoubling(i)))
+		}
+	}
+	values, err := cache.ReadBatch(ordinals)
+	assert.NoError(t, err)
+	assert.Len(t, values, readBatchSize)
+	assert.Equal(t, 0, fibonacci.FastDoubling(1998).Cmp(values[1998]))
+	assert.NotContains(t, values, uint64(1999))
+}
+
+func TestRedisCacheInspect(t *testing.T) {
+	cache, server := newTestRedisCache(t, DefaultRedisCacheOptions)
+	server.Set("fibo:cache:other", "not an ordinal")
+	for _, i := range []uint64{0, 5, 6, 100} {
+		assert.NoError(t, cache.Write(i, fibonacci.FastDoubling(i)))
+	}
+
+	entries, err := cache.List(1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []EntryInfo{{Ordinal: 5, Size: 1}, {Ordinal: 6, Size: 1}}, entries)
+
+	info, err := cache.Info(100)
+	assert.NoError(t, err)
+	assert.Equal(t, &EntryInfo{Ordinal: 100, Size: 9}, info)
+	info, err = cache.Info(0)
+	assert.NoError(t, err)
+	assert.Equal(t, &EntryInfo{Ordinal: 0, Size: 0}, info)
+	_, err = cache.Info(7)
+	assert.Equal(t, ErrNotFound, err)
+
+	stats, err := cache.Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, &Stats{Entries: 4, Bytes: 11, MaxOrdinal: 100}, stats)
+}
+
+func TestRedisCacheCheck(t *testing.T) {
+	cache, server := newTestRedisCache(t, DefaultRedisCacheOptions)
+	assert.Equal(t, map[string]error{"redis": nil}, cache.Check(context.Background()))
+	server.Close()
+	assert.Error(t, cache.Check(context.Background())["redis"])
+}
+
+// newStalledServer starts a server that accepts connections but never replies
+func newStalledServer(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestRedisCacheCheckStalled(t *testing.T) {
+	opts := DefaultRedisCacheOptions
+	opts.Addr = newStalledServer(t)
+	opts.ReadTimeout = 0
+	cache := newRedisCache(opts)
+	defer cache.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.Equal(t, map[string]error{"redis": context.DeadlineExceeded}, cache.Check(ctx))
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+}
+
+func TestRedisCacheTimeouts(t *testing.T) {
+	opts := DefaultRedisCacheOptions
+	opts.Addr = newStalledServer(t)
+	opts.ReadTimeout = 50 * time.Millisecond
+	cache := newRedisCache(opts)
+	defer cache.Close()
+
+	start := time.Now()
+	_, err := cache.Read(1)
+	var netErr net.Error
+	assert.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+	_, err = cache.ReadBatch([]uint64{1, 2})
+	assert.ErrorAs(t, err, &netErr)
+	_, err = cache.Stats()
+	assert.ErrorAs(t, err, &netErr)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+}
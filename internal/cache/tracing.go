@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/programmablemike/fibo/internal/fibonacci"
+	"github.com/programmablemike/fibo/internal/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+	gorm "gorm.io/gorm"
+)
+
+// tracer creates the spans of the SQL statements, they're dropped unless the server sets up tracing
+var tracer = otel.Tracer("github.com/programmablemike/fibo/internal/cache")
+
+// spanInstanceKey stores the span of a statement between the callbacks around it
+const spanInstanceKey = "fibo:span"
+
+// registerer is a point of gorm's callback chain a callback can be registered at
+type registerer interface {
+	Register(name string, fn func(*gorm.DB)) error
+}
+
+// tracingPlugin wraps every statement gorm issues in a span
+// The spans are children of the span in the statement's context, which is the caller's context for
+// the cache returned by WithContext.
+type tracingPlugin struct{}
+
+func (tracingPlugin) Name() string {
+	return "fibo:tracing"
+}
+
+func (p tracingPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	processors := []struct {
+		operation string
+		before    registerer
+		after     registerer
+	}{
+		{"create", cb.Create().Before("gorm:create"), cb.Create().After("gorm:create")},
+		{"query", cb.Query().Before("gorm:query"), cb.Query().After("gorm:query")},
+		{"update", cb.Update().Before("gorm:update"), cb.Update().After("gorm:update")},
+		{"delete", cb.Delete().Before("gorm:delete"), cb.Delete().After("gorm:delete")},
+		{"row", cb.Row().Before("gorm:row"), cb.Row().After("gorm:row")},
+		{"raw", cb.Raw().Before("gorm:raw"), cb.Raw().After("gorm:raw")},
+	}
+	for _, proc := range processors {
+		if err := proc.before.Register("fibo:before_"+proc.operation, startStatementSpan(proc.operation)); err != nil {
+			return err
+		}
+		if err := proc.after.Register("fibo:after_"+proc.operation, endStatementSpan); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startStatementSpan returns the callback that starts the span of a statement
+func startStatementSpan(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx := db.Statement.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		_, span := tracer.Start(ctx, "postgres."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBOperationKey.String(operation)),
+		)
+		if db.Statement.Table != "" {
+			span.SetAttributes(semconv.DBSQLTableKey.String(db.Statement.Table))
+		}
+		db.InstanceSet(spanInstanceKey, span)
+	}
+}
+
+// endStatementSpan records the SQL and the outcome of a statement and ends its span
+func endStatementSpan(db *gorm.DB) {
+	v, ok := db.InstanceGet(spanInstanceKey)
+	if !ok {
+		return
+	}
+	span := v.(trace.Span)
+	span.SetAttributes(
+		semconv.DBStatementKey.String(db.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", db.Statement.RowsAffected),
+	)
+	// A missing row is how the cache misses, it's not a failure of the statement
+	if err := db.Error; err != nil && err != gorm.ErrRecordNotFound {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// WithContext returns a view of the cache whose statements use ctx, so that their spans are
+// children of the span in ctx, and that logs with the logger of ctx
+func (c *Cache) WithContext(ctx context.Context) fibonacci.Memoizer {
+	return &Cache{db: c.db.WithContext(ctx), opts: c.opts, migrating: c.migrating, requestLog: logging.FromContext(ctx)}
+}
@@ -0,0 +1,229 @@
+// Verifies that the memoized values are actually Fibonacci numbers
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/programmablemike/fibo/internal/fibonacci"
+	log "github.com/sirupsen/logrus"
+)
+
+// VerifyMethod selects how stored entries are checked
+type VerifyMethod string
+
+const (
+	// VerifyRecurrence checks that consecutive entries satisfy F(n) = F(n-1) + F(n-2)
+	VerifyRecurrence VerifyMethod = "recurrence"
+	// VerifyFingerprint checks every entry against F(n) mod p computed by fast doubling
+	VerifyFingerprint VerifyMethod = "fingerprint"
+	// VerifyBoth runs both checks
+	VerifyBoth VerifyMethod = "both"
+)
+
+// VerifyAction selects what happens to entries that fail verification
+type VerifyAction string
+
+const (
+	// ActionReport only reports bad entries
+	ActionReport VerifyAction = "report"
+	// ActionRepair overwrites bad entries with freshly computed values
+	ActionRepair VerifyAction = "repair"
+	// ActionEvict deletes bad entries so they are recomputed on demand
+	ActionEvict VerifyAction = "evict"
+)
+
+// ParseVerifyMethod converts a user supplied string into a VerifyMethod
+func ParseVerifyMethod(v string) (VerifyMethod, error) {
+	switch m := VerifyMethod(v); m {
+	case VerifyRecurrence, VerifyFingerprint, VerifyBoth:
+		return m, nil
+	default:
+		return "", fmt.Errorf("invalid verify method %q (expected recurrence, fingerprint or both)", v)
+	}
+}
+
+// ParseVerifyAction converts a user supplied string into a VerifyAction
+func ParseVerifyAction(v string) (VerifyAction, error) {
+	switch a := VerifyAction(v); a {
+	case ActionReport, ActionRepair, ActionEvict:
+		return a, nil
+	default:
+		return "", fmt.Errorf("invalid verify action %q (expected report, repair or evict)", v)
+	}
+}
+
+// VerifyResult summarizes a verification pass
+type VerifyResult struct {
+	Checked  uint64   // Number of entries that were checked
+	Bad      []uint64 // Ordinals that failed verification in ascending order
+	Repaired int      // Number of entries that were rewritten
+	Evicted  int      // Number of entries that were deleted
+}
+
+// Verify checks every entry in the cache and applies the action to the ones that are wrong
+// It stops with the error of ctx once ctx is done.
+//
+// The recurrence check carries the expected value of a bad entry forward so that only the bad
+// entry is reported. At the start of a run of consecutive ordinals it can't tell which of three
+// inconsistent entries is wrong so it reports the last one. Combined with repair or evict that's
+// still safe because the affected entries are recomputed from scratch.
+func Verify(ctx context.Context, m fibonacci.Memoizer, method VerifyMethod, action VerifyAction) (*VerifyResult, error) {
+	it, ok := m.(Iterator)
+	if !ok {
+		return nil, fmt.Errorf("the cache backend does not support iterating over entries")
+	}
+	deleter, canDelete := m.(Deleter)
+	if action == ActionEvict && !canDelete {
+		return nil, fmt.Errorf("the cache backend does not support evicting entries")
+	}
+
+	result := &VerifyResult{Bad: []uint64{}}
+	bad := make(map[uint64]bool)
+	var prev [2]struct {
+		ordinal uint64
+		value   *fibonacci.Number
+	}
+	seen := 0
+	err := it.Each(func(ordinal uint64, value *fibonacci.Number) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		result.Checked++
+		if method == VerifyFingerprint || method == VerifyBoth {
+			expected := fibonacci.Fingerprint(ordinal, fibonacci.FingerprintModulus)
+			if value.Sign() < 0 || fibonacci.NumberFingerprint(value, fibonacci.FingerprintModulus) != expected {
+				bad[ordinal] = true
+			}
+		}
+		if method == VerifyRecurrence || method == VerifyBoth {
+			// expected is unknown for the first two entries of a run of consecutive ordinals
+			var expected *fibonacci.Number
+			switch {
+			case ordinal < 2:
+				expected = fibonacci.NewNumber(int64(ordinal))
+			case seen >= 2 && prev[0].ordinal == ordinal-2 && prev[1].ordinal == ordinal-1:
+				expected = fibonacci.NewNumber(0).Add(prev[0].value, prev[1].value)
+			}
+			switch {
+			case expected != nil:
+				if expected.Cmp(value) != 0 {
+					bad[ordinal] = true
+				}
+				value = expected
+			case bad[ordinal]:
+				// A bad entry that can't be corrected starts a new run after it
+				seen = 0
+				return nil
+			}
+			prev[0] = prev[1]
+			prev[1].ordinal, prev[1].value = ordinal, value
+			seen++
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	for ordinal := range bad {
+		result.Bad = append(result.Bad, ordinal)
+	}
+	sortOrdinals(result.Bad)
+
+	// Entries are fixed up after iterating so that we never modify a backend mid-scan
+	for _, ordinal := range result.Bad {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		switch action {
+		case ActionRepair:
+			// Some backends return the oldest row for an ordinal so the bad one has to go first
+			if canDelete {
+				if err := deleter.Delete(ordinal); err != nil {
+					return result, err
+				}
+			}
+			if err := m.Write(ordinal, fibonacci.FastDoubling(ordinal)); err != nil {
+				return result, err
+			}
+			result.Repaired++
+		case ActionEvict:
+			if err := deleter.Delete(ordinal); err != nil {
+				return result, err
+			}
+			result.Evicted++
+		}
+	}
+	return result, nil
+}
+
+// Scrubber periodically verifies the cache in the background
+type Scrubber struct {
+	memoizer fibonacci.Memoizer
+	interval time.Duration
+	method   VerifyMethod
+	action   VerifyAction
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewScrubber creates a scrubber, call Start to begin scrubbing
+func NewScrubber(m fibonacci.Memoizer, interval time.Duration, method VerifyMethod, action VerifyAction) *Scrubber {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scrubber{
+		memoizer: m,
+		interval: interval,
+		method:   method,
+		action:   action,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Start runs the scrubber until Stop is called
+func (s *Scrubber) Start() {
+	log.Infof("Scrubbing the cache every %s (method=%s, action=%s).", s.interval, s.method, s.action)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				s.scrub()
+			}
+		}
+	}()
+}
+
+// Stop cancels the current pass and stops the scrubber
+func (s *Scrubber) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+func (s *Scrubber) scrub() {
+	start := time.Now()
+	result, err := Verify(s.ctx, s.memoizer, s.method, s.action)
+	if errors.Is(err, context.Canceled) {
+		log.Infof("Cache scrub cancelled after %d entries.", result.Checked)
+		return
+	}
+	if err != nil {
+		log.Errorf("Cache scrub failed: %s", err)
+		return
+	}
+	if len(result.Bad) > 0 {
+		log.Warnf("Cache scrub found %d bad entries out of %d (repaired=%d, evicted=%d): %v",
+			len(result.Bad), result.Checked, result.Repaired, result.Evicted, result.Bad)
+		return
+	}
+	log.Infof("Cache scrub checked %d entries in %s.", result.Checked, time.Since(start))
+}
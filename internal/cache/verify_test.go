@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/programmablemike/fibo/internal/fibonacci"
+	"github.com/stretchr/testify/assert"
+)
+
+// newCorruptedFileCache memoizes F(0)..F(49) and then corrupts F(20)
+func newCorruptedFileCache(t *testing.T) *FileCache {
+	cache, err := NewFileCache(t.TempDir(), fileCacheTestOptions)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, cache.Close())
+	})
+	fibonacci.NewGenerator(cache).Compute(50)
+	assert.NoError(t, cache.Write(20, fibonacci.NewNumber(6766)))
+	return cache
+}
+
+func TestVerifyFingerprint(t *testing.T) {
+	cache := newCorruptedFileCache(t)
+	result, err := Verify(context.Background(), cache, VerifyFingerprint, ActionReport)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(50), result.Checked)
+	assert.Equal(t, []uint64{20}, result.Bad)
+}
+
+func TestVerifyRecurrence(t *testing.T) {
+	cache := newCorruptedFileCache(t)
+	result, err := Verify(context.Background(), cache, VerifyRecurrence, ActionReport)
+	assert.NoError(t, err)
+	// F(21) and F(22) are checked against the expected F(20)
+	assert.Equal(t, []uint64{20}, result.Bad)
+}
+
+func TestVerifyRepair(t *testing.T) {
+	cache := newCorruptedFileCache(t)
+	result, err := Verify(context.Background(), cache, VerifyBoth, ActionRepair)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Repaired)
+
+	v, err := cache.Read(20)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fibonacci.NewNumber(6765).Cmp(v))
+	result, err = Verify(context.Background(), cache, VerifyBoth, ActionReport)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Bad)
+}
+
+func TestVerifyEvict(t *testing.T) {
+	cache := newCorruptedFileCache(t)
+	result, err := Verify(context.Background(), cache, VerifyFingerprint, ActionEvict)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Evicted)
+
+	_, err = cache.Read(20)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, 0, fibonacci.NewNumber(6765).Cmp(fibonacci.NewGenerator(cache).Compute(20)))
+}
+
+func TestVerifyCancelled(t *testing.T) {
+	cache := newCorruptedFileCache(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err := Verify(ctx, cache, VerifyBoth, ActionRepair)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, uint64(0), result.Checked)
+	assert.Equal(t, 0, result.Repaired)
+}
@@ -0,0 +1,83 @@
+package codec
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// maxDepth bounds the nesting of decoded arrays and maps
+const maxDepth = 100
+
+// cborEncMode writes bignums with tags 2 and 3 even when they fit in an integer, so that the type
+// doesn't depend on the value, and sorts the map keys so that the output is deterministic
+var cborEncMode = mustCBOREncMode(cbor.EncOptions{
+	Sort:          cbor.SortBytewiseLexical,
+	BigIntConvert: cbor.BigIntConvertNone,
+})
+
+// cborDecMode decodes maps with string keys, the lengths of arrays and maps are only bounded by
+// the size of the input
+var cborDecMode = mustCBORDecMode(cbor.DecOptions{
+	MaxNestedLevels:  maxDepth,
+	MaxArrayElements: math.MaxInt32,
+	MaxMapPairs:      math.MaxInt32,
+	DefaultMapType:   reflect.TypeOf(map[string]interface{}(nil)),
+})
+
+func mustCBOREncMode(opts cbor.EncOptions) cbor.EncMode {
+	em, err := opts.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	return em
+}
+
+func mustCBORDecMode(opts cbor.DecOptions) cbor.DecMode {
+	dm, err := opts.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return dm
+}
+
+func encodeCBOR(w io.Writer, v interface{}) error {
+	return cborEncMode.NewEncoder(w).Encode(toTree(v))
+}
+
+func decodeCBOR(data []byte) (interface{}, error) {
+	d := cborDecMode.NewDecoder(bytes.NewReader(data))
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	if n := len(data) - d.NumBytesRead(); n > 0 {
+		return nil, fmt.Errorf("%d trailing bytes after the value", n)
+	}
+	return bigIntPointers(v), nil
+}
+
+// bigIntPointers replaces the big.Int values of a decoded tree with pointers like the other codecs
+func bigIntPointers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case big.Int:
+		return &v
+	case []interface{}:
+		for i := range v {
+			v[i] = bigIntPointers(v[i])
+		}
+		return v
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = bigIntPointers(v[k])
+		}
+		return v
+	default:
+		return v
+	}
+}
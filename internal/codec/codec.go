@@ -0,0 +1,180 @@
+// Encodes API responses as JSON, CBOR or MessagePack depending on what the client accepts
+package codec
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// Codec encodes and decodes API responses in a single media type
+type Codec interface {
+	// Name is the short name used by the CLI, e.g. "cbor"
+	Name() string
+	ContentType() string
+	// Bignums returns true if *big.Int values are encoded natively instead of as decimal strings
+	Bignums() bool
+	Encode(w io.Writer, v interface{}) error
+	// Decode decodes a single value into maps, slices, strings, numbers, []byte and *big.Int
+	Decode(data []byte) (interface{}, error)
+}
+
+var (
+	JSON    Codec = jsonCodec{}
+	CBOR    Codec = &binaryCodec{name: "cbor", contentType: "application/cbor", encode: encodeCBOR, decode: decodeCBOR}
+	MsgPack Codec = &binaryCodec{name: "msgpack", contentType: "application/msgpack", encode: encodeMsgPack, decode: decodeMsgPack}
+)
+
+// codecs lists the codecs in order of preference when the client accepts several equally
+var codecs = []Codec{JSON, CBOR, MsgPack}
+
+// aliases maps media types that are in use for MessagePack to the codec
+var aliases = map[string]Codec{
+	"application/x-msgpack":   MsgPack,
+	"application/vnd.msgpack": MsgPack,
+}
+
+// ByName returns the codec with the short name
+func ByName(name string) (Codec, error) {
+	for _, c := range codecs {
+		if c.Name() == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid encoding %q (expected json, cbor or msgpack)", name)
+}
+
+// ForContentType returns the codec for a Content-Type header, JSON if it's unknown
+func ForContentType(contentType string) Codec {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return JSON
+	}
+	for _, c := range codecs {
+		if c.ContentType() == mediaType {
+			return c
+		}
+	}
+	if c, ok := aliases[mediaType]; ok {
+		return c
+	}
+	return JSON
+}
+
+// Negotiate picks the codec for an Accept header
+//
+// The codec with the highest quality wins. JSON is used when the header is empty or doesn't
+// list any of the supported media types so that existing clients keep working.
+func Negotiate(accept string) Codec {
+	best, bestQ := JSON, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		var c Codec
+		switch {
+		case mediaType == "*/*" || mediaType == "application/*":
+			c = JSON
+		case aliases[mediaType] != nil:
+			c = aliases[mediaType]
+		default:
+			for _, candidate := range codecs {
+				if candidate.ContentType() == mediaType {
+					c = candidate
+				}
+			}
+		}
+		if c != nil && q > bestQ {
+			best, bestQ = c, q
+		}
+	}
+	return best
+}
+
+// Unmarshal decodes data with the codec into v like json.Unmarshal does
+// Bignums are converted to decimal strings so they can be decoded into the string fields of
+// the response types.
+func Unmarshal(c Codec, data []byte, v interface{}) error {
+	if c == JSON {
+		return json.Unmarshal(data, v)
+	}
+	tree, err := c.Decode(data)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(normalize(tree))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// normalize converts a decoded tree into values that encoding/json handles like JSON input
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case *big.Int:
+		return v.String()
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = normalize(v[i])
+		}
+		return v
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = normalize(v[k])
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string        { return "json" }
+func (jsonCodec) ContentType() string { return "application/json" }
+func (jsonCodec) Bignums() bool       { return false }
+
+func (jsonCodec) Encode(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+func (jsonCodec) Decode(data []byte) (interface{}, error) {
+	var v interface{}
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// binaryCodec encodes the trees of toTree with a codec library
+type binaryCodec struct {
+	name        string
+	contentType string
+	encode      func(w io.Writer, v interface{}) error
+	decode      func(data []byte) (interface{}, error)
+}
+
+func (c *binaryCodec) Name() string        { return c.name }
+func (c *binaryCodec) ContentType() string { return c.contentType }
+func (c *binaryCodec) Bignums() bool       { return true }
+
+func (c *binaryCodec) Encode(w io.Writer, v interface{}) error {
+	return c.encode(w, v)
+}
+
+func (c *binaryCodec) Decode(data []byte) (interface{}, error) {
+	return c.decode(data)
+}
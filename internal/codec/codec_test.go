@@ -0,0 +1,181 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testInner struct {
+	Status string `json:"status"`
+	Value  string `json:"value"`
+	// Number replaces Value in the binary encodings
+	Number *big.Int `json:"-" codec:"value"`
+}
+
+type testResponse struct {
+	testInner
+	Count   uint64            `json:"count"`
+	Skipped string            `json:"skipped,omitempty"`
+	Values  []string          `json:"values"`
+	Labels  map[string]string `json:"labels"`
+	Created time.Time         `json:"created"`
+	hidden  int
+}
+
+func encode(t *testing.T, c Codec, v interface{}) []byte {
+	var buf bytes.Buffer
+	assert.NoError(t, c.Encode(&buf, v))
+	return buf.Bytes()
+}
+
+func TestCBOREncoding(t *testing.T) {
+	tests := []struct {
+		Value    interface{}
+		Expected string
+	}{
+		{Value: uint64(0), Expected: "00"},
+		{Value: 23, Expected: "17"},
+		{Value: 24, Expected: "1818"},
+		{Value: 1000000, Expected: "1a000f4240"},
+		{Value: -1000, Expected: "3903e7"},
+		{Value: "IETF", Expected: "6449455446"},
+		{Value: []byte{1, 2}, Expected: "420102"},
+		{Value: []int{1, 2, 3}, Expected: "83010203"},
+		{Value: map[string]bool{"a": true}, Expected: "a16161f5"},
+		{Value: nil, Expected: "f6"},
+		{Value: 1.5, Expected: "fb3ff8000000000000"},
+		// RFC 8949 appendix A: 18446744073709551616 and -18446744073709551617
+		{Value: new(big.Int).Lsh(big.NewInt(1), 64), Expected: "c249010000000000000000"},
+		{Value: new(big.Int).Neg(new(big.Int).Add(new(big.Int).Lsh(big.NewInt(1), 64), big.NewInt(1))), Expected: "c349010000000000000000"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.Expected, hex.EncodeToString(encode(t, CBOR, test.Value)), "%v", test.Value)
+	}
+}
+
+func TestMsgPackEncoding(t *testing.T) {
+	tests := []struct {
+		Value    interface{}
+		Expected string
+	}{
+		{Value: 0, Expected: "00"},
+		{Value: 200, Expected: "ccc8"},
+		{Value: 70000, Expected: "ce00011170"},
+		{Value: -5, Expected: "fb"},
+		{Value: -200, Expected: "d1ff38"},
+		{Value: "a", Expected: "a161"},
+		{Value: []byte{1, 2}, Expected: "c4020102"},
+		{Value: []int{1, 2}, Expected: "920102"},
+		{Value: map[string]int{"a": 1}, Expected: "81a16101"},
+		{Value: false, Expected: "c2"},
+		{Value: big.NewInt(258), Expected: "d5010102"},
+		{Value: big.NewInt(-1), Expected: "d40201"},
+		{Value: big.NewInt(0), Expected: "c70001"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.Expected, hex.EncodeToString(encode(t, MsgPack, test.Value)), "%v", test.Value)
+	}
+}
+
+func TestStructRoundTrip(t *testing.T) {
+	large, _ := new(big.Int).SetString("354224848179261915075", 10)
+	created := time.Date(2021, 8, 1, 12, 0, 0, 0, time.UTC)
+	res := testResponse{
+		testInner: testInner{Status: "OK", Number: large},
+		Count:     3,
+		Values:    []string{"1", "1", "2"},
+		Labels:    map[string]string{"b": "2", "a": "1"},
+		Created:   created,
+		hidden:    7,
+	}
+	for _, c := range []Codec{JSON, CBOR, MsgPack} {
+		data := encode(t, c, res)
+		tree, err := c.Decode(data)
+		assert.NoError(t, err, c.Name())
+		m := tree.(map[string]interface{})
+		assert.NotContains(t, m, "skipped", c.Name())
+		assert.NotContains(t, m, "hidden", c.Name())
+		if c.Bignums() {
+			assert.Equal(t, large, m["value"], c.Name())
+		} else {
+			assert.Equal(t, "", m["value"], c.Name())
+		}
+
+		var decoded testResponse
+		assert.NoError(t, Unmarshal(c, data, &decoded), c.Name())
+		assert.Equal(t, "OK", decoded.Status, c.Name())
+		assert.Equal(t, uint64(3), decoded.Count, c.Name())
+		assert.Equal(t, res.Values, decoded.Values, c.Name())
+		assert.Equal(t, res.Labels, decoded.Labels, c.Name())
+		assert.True(t, created.Equal(decoded.Created), c.Name())
+		if c.Bignums() {
+			assert.Equal(t, large.String(), decoded.Value, c.Name())
+		}
+	}
+}
+
+func TestInterfaceFields(t *testing.T) {
+	large, _ := new(big.Int).SetString("354224848179261915075", 10)
+	for _, c := range []Codec{CBOR, MsgPack} {
+		for _, v := range []interface{}{"37", uint64(55), large, nil} {
+			data := encode(t, c, struct {
+				Value interface{} `json:"value"`
+			}{Value: v})
+			tree, err := c.Decode(data)
+			assert.NoError(t, err, c.Name())
+			assert.Contains(t, tree.(map[string]interface{}), "value", c.Name())
+		}
+	}
+}
+
+func TestDecodeRejectsBadInput(t *testing.T) {
+	for _, input := range []string{"", "62ff", "9b7fffffffffffffff", "c2", "0000", "bf"} {
+		data, _ := hex.DecodeString(input)
+		_, err := CBOR.Decode(data)
+		assert.Error(t, err, "cbor %s", input)
+	}
+	for _, input := range []string{"", "a2ff", "dd7fffffff", "d401", "c1", "0000"} {
+		data, _ := hex.DecodeString(input)
+		_, err := MsgPack.Decode(data)
+		assert.Error(t, err, "msgpack %s", input)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		Accept   string
+		Expected Codec
+	}{
+		{Accept: "", Expected: JSON},
+		{Accept: "*/*", Expected: JSON},
+		{Accept: "text/html", Expected: JSON},
+		{Accept: "application/cbor", Expected: CBOR},
+		{Accept: "application/x-msgpack", Expected: MsgPack},
+		{Accept: "application/json;q=0.5, application/msgpack", Expected: MsgPack},
+		{Accept: "application/cbor;q=0.2, */*;q=0.9", Expected: JSON},
+		{Accept: "application/cbor, application/msgpack", Expected: CBOR},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.Expected.Name(), Negotiate(test.Accept).Name(), test.Accept)
+	}
+	assert.Equal(t, MsgPack, ForContentType("application/vnd.msgpack"))
+	assert.Equal(t, CBOR, ForContentType("application/cbor; charset=binary"))
+	_, err := ByName("xml")
+	assert.Error(t, err)
+}
+
+func TestBignumRoundTrip(t *testing.T) {
+	large, _ := new(big.Int).SetString("-354224848179261915075", 10)
+	for _, c := range []Codec{CBOR, MsgPack} {
+		for _, v := range []*big.Int{big.NewInt(0), big.NewInt(55), big.NewInt(-1), large} {
+			tree, err := c.Decode(encode(t, c, []interface{}{v}))
+			assert.NoError(t, err, c.Name())
+			assert.Equal(t, []interface{}{v}, tree, "%s %s", c.Name(), v)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package codec
+
+import (
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	bigIntType = reflect.TypeOf(big.Int{})
+	timeType   = reflect.TypeOf(time.Time{})
+)
+
+// toTree converts v into the maps, slices, strings, numbers, []byte and *big.Int written by the
+// binary codecs
+//
+// Struct fields are named by their json tags and omitempty is honored. A field with a
+// `codec:"name"` tag (a pointer or slice) is only written by the binary codecs, when it's not nil it
+// replaces the json field with the same name. This lets responses carry a *big.Int next to its
+// decimal string, which the struct tags of the codec libraries can't express.
+// Times are written as RFC 3339 strings like in JSON.
+func toTree(v interface{}) interface{} {
+	return reflectTree(reflect.ValueOf(v))
+}
+
+func reflectTree(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Kind() == reflect.Ptr && v.Type().Elem() == bigIntType {
+			return v.Interface().(*big.Int)
+		}
+		return reflectTree(v.Elem())
+	}
+	switch v.Type() {
+	case timeType:
+		return v.Interface().(time.Time).Format(time.RFC3339Nano)
+	case bigIntType:
+		b := v.Interface().(big.Int)
+		return &b
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint()
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.String:
+		return v.String()
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Bytes()
+		}
+		return arrayTree(v)
+	case reflect.Array:
+		return arrayTree(v)
+	case reflect.Map:
+		// Only string keys are supported
+		if v.IsNil() || v.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		m := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			m[iter.Key().String()] = reflectTree(iter.Value())
+		}
+		return m
+	case reflect.Struct:
+		fields := structFields(v)
+		m := make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			m[f.name] = reflectTree(f.value)
+		}
+		return m
+	default:
+		// Channels and functions can't be represented, encoding/json fails on them
+		return nil
+	}
+}
+
+func arrayTree(v reflect.Value) []interface{} {
+	items := make([]interface{}, v.Len())
+	for i := range items {
+		items[i] = reflectTree(v.Index(i))
+	}
+	return items
+}
+
+type field struct {
+	name  string
+	value reflect.Value
+	depth int // Embedding depth, shallower fields hide deeper ones with the same name
+}
+
+// structFields returns the fields of a struct
+func structFields(v reflect.Value) []field {
+	var fields, overrides []field
+	collectFields(v, 0, &fields, &overrides)
+
+	// Shallower fields win like in encoding/json
+	byName := make(map[string]int)
+	var unique []field
+	for _, f := range fields {
+		i, ok := byName[f.name]
+		switch {
+		case !ok:
+			byName[f.name] = len(unique)
+			unique = append(unique, f)
+		case f.depth < unique[i].depth:
+			unique[i] = f
+		}
+	}
+	for _, f := range overrides {
+		if f.value.IsNil() {
+			continue
+		}
+		if i, ok := byName[f.name]; ok {
+			unique[i] = f
+			continue
+		}
+		byName[f.name] = len(unique)
+		unique = append(unique, f)
+	}
+	return unique
+}
+
+func collectFields(v reflect.Value, depth int, fields *[]field, overrides *[]field) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		fv := v.Field(i)
+		if name, ok := sf.Tag.Lookup("codec"); ok && (fv.Kind() == reflect.Ptr || fv.Kind() == reflect.Slice) {
+			*overrides = append(*overrides, field{name: name, value: fv, depth: depth})
+			continue
+		}
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options := tag, ""
+		if i := strings.IndexByte(tag, ','); i >= 0 {
+			name, options = tag[:i], tag[i+1:]
+		}
+		if sf.Anonymous && name == "" && fv.Kind() == reflect.Struct {
+			collectFields(fv, depth+1, fields, overrides)
+			continue
+		}
+		if sf.PkgPath != "" {
+			continue // Unexported
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		if strings.Contains(","+options+",", ",omitempty,") && isEmptyValue(fv) {
+			continue
+		}
+		*fields = append(*fields, field{name: name, value: fv, depth: depth})
+	}
+}
+
+// isEmptyValue matches the definition of empty used by omitempty in encoding/json
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
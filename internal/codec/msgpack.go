@@ -0,0 +1,78 @@
+package codec
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MessagePack has no standard bignum type so bignums use application specific extension
+// types holding the big-endian magnitude
+const (
+	msgpackExtPositiveBignum int8 = 1
+	msgpackExtNegativeBignum int8 = 2
+)
+
+func init() {
+	// The extension type depends on the sign, so the encoder is registered for the type instead of
+	// an extension ID
+	msgpack.Register((*big.Int)(nil), encodeMsgPackBignum, nil)
+	msgpack.RegisterExtDecoder(msgpackExtPositiveBignum, (*big.Int)(nil), decodeMsgPackBignum(false))
+	msgpack.RegisterExtDecoder(msgpackExtNegativeBignum, (*big.Int)(nil), decodeMsgPackBignum(true))
+}
+
+func encodeMsgPackBignum(e *msgpack.Encoder, v reflect.Value) error {
+	if v.IsNil() {
+		return e.EncodeNil()
+	}
+	n := v.Interface().(*big.Int)
+	typ := msgpackExtPositiveBignum
+	if n.Sign() < 0 {
+		typ = msgpackExtNegativeBignum
+	}
+	b := n.Bytes()
+	if err := e.EncodeExtHeader(typ, len(b)); err != nil {
+		return err
+	}
+	_, err := e.Writer().Write(b)
+	return err
+}
+
+func decodeMsgPackBignum(negative bool) func(d *msgpack.Decoder, v reflect.Value, extLen int) error {
+	return func(d *msgpack.Decoder, v reflect.Value, extLen int) error {
+		b := make([]byte, extLen)
+		if err := d.ReadFull(b); err != nil {
+			return err
+		}
+		n := v.Interface().(*big.Int).SetBytes(b)
+		if negative {
+			n.Neg(n)
+		}
+		return nil
+	}
+}
+
+func encodeMsgPack(w io.Writer, v interface{}) error {
+	e := msgpack.NewEncoder(w)
+	e.UseCompactInts(true)
+	e.SetSortMapKeys(true)
+	return e.Encode(toTree(v))
+}
+
+func decodeMsgPack(data []byte) (interface{}, error) {
+	r := bytes.NewReader(data)
+	d := msgpack.NewDecoder(r)
+	d.UseLooseInterfaceDecoding(true)
+	v, err := d.DecodeInterface()
+	if err != nil {
+		return nil, err
+	}
+	if r.Len() > 0 {
+		return nil, fmt.Errorf("%d trailing bytes after the value", r.Len())
+	}
+	return v, nil
+}
@@ -0,0 +1,144 @@
+package fibonacci
+
+import (
+	"context"
+	"sort"
+
+	"github.com/programmablemike/fibo/internal/logging"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// batchStepLimit is the largest gap between two ordinals of a batch that's crossed by stepping
+// through the recurrence, larger gaps are jumped with the addition formulas
+const batchStepLimit = 256
+
+// Batch calls fn with the values of the ordinals in ascending order, once per distinct ordinal
+//
+// Cached values are read with a single bulk read when the memoizer supports it. The missing values
+// are computed in one ascending pass where every value builds on the previous one: small gaps are
+// stepped through with the recurrence and larger ones are jumped with
+//
+//	F(m+d)   = F(m+1)*F(d) + F(m)*F(d-1)
+//	F(m+d+1) = F(m+1)*F(d+1) + F(m)*F(d)
+//
+// which multiplies by the much smaller F(d) instead of recomputing F(m+d) from scratch. Only gaps
+// wider than the previous ordinal start over with fast doubling. The computed values are memoized
+// with a single batch write.
+func (g *Generator) Batch(ctx context.Context, ordinals []uint64, fn func(ordinal uint64, value *Number, cached bool) error) (err error) {
+	ordinals = sortedUnique(ordinals)
+	ctx, span := tracer.Start(ctx, "fibonacci.Batch", trace.WithAttributes(attribute.Int("fibo.ordinals", len(ordinals))))
+	defer func() { endSpan(span, err) }()
+	cached, err := g.readBatch(ctx, ordinals)
+	if err != nil {
+		logging.FromContext(ctx).Warnf("Failed to read the cached batch values, computing all of them: %s", err)
+		cached = nil
+	}
+	g.observer.CacheHits(len(cached))
+	g.observer.CacheMisses(len(ordinals) - len(cached))
+
+	computed, err := g.computeBatch(ctx, ordinals, cached, fn)
+	if err != nil {
+		return err
+	}
+	if len(computed) > 0 {
+		if err := g.writeBatch(ctx, computed); err != nil {
+			logging.FromContext(ctx).Errorf("Failed to write to cache: %s", err)
+		}
+	}
+	return nil
+}
+
+// computeBatch is the ascending pass of Batch, it returns the computed entries
+func (g *Generator) computeBatch(ctx context.Context, ordinals []uint64, cached map[uint64]*Number, fn func(ordinal uint64, value *Number, cached bool) error) (computed []Entry, err error) {
+	ctx, span := tracer.Start(ctx, "fibonacci.ComputeBatch")
+	defer func() {
+		span.SetAttributes(attribute.Int("fibo.computed", len(computed)))
+		endSpan(span, err)
+	}()
+
+	var a, b *Number // F(m) and F(m+1) of the last computed ordinal m
+	m := uint64(0)
+	for _, n := range ordinals {
+		if err := ctx.Err(); err != nil {
+			return computed, err
+		}
+		if v, ok := cached[n]; ok {
+			if err := fn(n, v, true); err != nil {
+				return computed, err
+			}
+			continue
+		}
+		done := g.observer.ComputeStarted(n)
+		switch d := n - m; {
+		case a != nil && d <= batchStepLimit:
+			for ; m < n; m++ {
+				a.Add(a, b)
+				a, b = b, a
+			}
+		case a != nil && d < m:
+			a, b, err = jump(ctx, a, b, d)
+		default:
+			a, b, err = fastDoublingPair(ctx, n, nil)
+		}
+		done()
+		if err != nil {
+			return computed, err
+		}
+		m = n
+		value := NewNumber(0).Set(a)
+		computed = append(computed, Entry{Ordinal: n, Value: value})
+		if err := fn(n, value, false); err != nil {
+			return computed, err
+		}
+	}
+	return computed, nil
+}
+
+// jump returns F(m+d) and F(m+d+1) from F(m) and F(m+1)
+func jump(ctx context.Context, fm *Number, fm1 *Number, d uint64) (*Number, *Number, error) {
+	fd, fd1, err := fastDoublingPair(ctx, d, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	fdm1 := NewNumber(0).Sub(fd1, fd) // F(d-1)
+	t := NewNumber(0)
+	a := NewNumber(0).Mul(fm1, fd)
+	a.Add(a, t.Mul(fm, fdm1))
+	b := NewNumber(0).Mul(fm1, fd1)
+	b.Add(b, t.Mul(fm, fd))
+	return a, b, nil
+}
+
+// readBatch reads the cached values with a single bulk read when the memoizer supports it
+func (g *Generator) readBatch(ctx context.Context, ordinals []uint64) (values map[uint64]*Number, err error) {
+	ctx, span := tracer.Start(ctx, "memoizer.ReadBatch", trace.WithAttributes(attribute.Int("fibo.ordinals", len(ordinals))))
+	defer func() {
+		span.SetAttributes(attribute.Int("fibo.cache_hits", len(values)))
+		endSpan(span, err)
+	}()
+	cache := g.memoizer(ctx)
+	if br, ok := cache.(BatchReader); ok {
+		return br.ReadBatch(ordinals)
+	}
+	values = make(map[uint64]*Number, len(ordinals))
+	for _, ordinal := range ordinals {
+		if v, err := cache.Read(ordinal); err == nil {
+			values[ordinal] = v
+		}
+	}
+	return values, nil
+}
+
+// sortedUnique returns the distinct ordinals in ascending order
+func sortedUnique(ordinals []uint64) []uint64 {
+	sorted := append([]uint64(nil), ordinals...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	unique := sorted[:0]
+	for i, n := range sorted {
+		if i == 0 || n != sorted[i-1] {
+			unique = append(unique, n)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,61 @@
+package fibonacci
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// BatchReadMemoryCache is a BatchMemoryCache that records the size of every batch read from it
+type BatchReadMemoryCache struct {
+	*BatchMemoryCache
+	reads []int
+}
+
+func (bc *BatchReadMemoryCache) ReadBatch(ordinals []uint64) (map[uint64]*Number, error) {
+	bc.reads = append(bc.reads, len(ordinals))
+	values := make(map[uint64]*Number)
+	for _, ordinal := range ordinals {
+		if v, ok := bc.table[ordinal]; ok {
+			values[ordinal] = v
+		}
+	}
+	return values, nil
+}
+
+func TestBatch(t *testing.T) {
+	c := &BatchReadMemoryCache{BatchMemoryCache: &BatchMemoryCache{MemoryCache: NewMemoryCache(map[uint64]*Number{
+		12: NewNumber(144),
+	})}}
+	g := NewGenerator(c)
+
+	// Steps, jumps and restarts with fast doubling
+	ordinals := []uint64{20000, 12, 0, 5, 5, 300, 301, 1000, 1500, 7, 100000}
+	var got []uint64
+	var cached []uint64
+	err := g.Batch(context.Background(), ordinals, func(ordinal uint64, value *Number, fromCache bool) error {
+		got = append(got, ordinal)
+		if fromCache {
+			cached = append(cached, ordinal)
+		}
+		assert.Equal(t, 0, FastDoubling(ordinal).Cmp(value), "F(%d)", ordinal)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{0, 5, 7, 12, 300, 301, 1000, 1500, 20000, 100000}, got)
+	assert.Equal(t, []uint64{12}, cached)
+	assert.Equal(t, []int{10}, c.reads)
+	assert.Equal(t, []int{9}, c.batches)
+	assert.Equal(t, 0, FastDoubling(100000).Cmp(c.table[100000]))
+}
+
+func TestBatchCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	g := NewGenerator(NewMockEmptyCache())
+	err := g.Batch(ctx, []uint64{1, 2, 3}, func(ordinal uint64, value *Number, cached bool) error {
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,87 @@
+package fibonacci
+
+import (
+	"context"
+	"math"
+	"math/bits"
+)
+
+// FingerprintModulus is the Mersenne prime 2^61 - 1 used for modular fingerprints
+const FingerprintModulus uint64 = 1<<61 - 1
+
+// FastDoubling computes F(n) directly without touching the memoizer
+// It uses the identities:
+//
+//	F(2k)   = F(k) * (2*F(k+1) - F(k))
+//	F(2k+1) = F(k+1)^2 + F(k)^2
+//
+// which takes O(log n) big-number multiplications.
+func FastDoubling(n uint64) *Number {
+	v, _ := fastDoubling(context.Background(), n, nil)
+	return v
+}
+
+// fastDoubling is FastDoubling with cancellation and progress reporting
+// The numbers double in size every round so most of the time goes into the last few rounds,
+// progress is weighted accordingly.
+func fastDoubling(ctx context.Context, n uint64, progress func(float64)) (*Number, error) {
+	a, _, err := fastDoublingPair(ctx, n, progress)
+	return a, err
+}
+
+// fastDoublingPair is fastDoubling that also returns F(n+1)
+func fastDoublingPair(ctx context.Context, n uint64, progress func(float64)) (*Number, *Number, error) {
+	a := NewNumber(0) // F(k)
+	b := NewNumber(1) // F(k+1)
+	t := NewNumber(0)
+	rounds := bits.Len64(n)
+	for i := rounds - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		if progress != nil {
+			done := rounds - 1 - i
+			progress((math.Ldexp(1, done) - 1) / (math.Ldexp(1, rounds) - 1))
+		}
+		// c = F(2k), d = F(2k+1)
+		c := NewNumber(0).Lsh(b, 1)
+		c.Sub(c, a)
+		c.Mul(c, a)
+		d := NewNumber(0).Mul(a, a)
+		d.Add(d, t.Mul(b, b))
+		if n&(1<<uint(i)) == 0 {
+			a, b = c, d
+		} else {
+			a, b = d, c.Add(c, d)
+		}
+	}
+	return a, b, nil
+}
+
+// Fingerprint computes F(n) mod m using fast doubling on machine words
+// It's a cheap way to check a stored value without recomputing the full number.
+func Fingerprint(n uint64, m uint64) uint64 {
+	mulmod := func(x, y uint64) uint64 {
+		hi, lo := bits.Mul64(x, y)
+		return bits.Rem64(hi, lo, m)
+	}
+	a := uint64(0)     // F(k) mod m
+	b := uint64(1) % m // F(k+1) mod m
+	for i := bits.Len64(n) - 1; i >= 0; i-- {
+		// 2*b + m - a can't overflow as long as m < 2^62
+		c := mulmod(a, (2*b+m-a)%m)
+		d := (mulmod(a, a) + mulmod(b, b)) % m
+		if n&(1<<uint(i)) == 0 {
+			a, b = c, d
+		} else {
+			a, b = d, (c+d)%m
+		}
+	}
+	return a
+}
+
+// NumberFingerprint reduces a value mod m so that it can be compared with Fingerprint
+func NumberFingerprint(v *Number, m uint64) uint64 {
+	mod := NewNumber(0).SetUint64(m)
+	return NewNumber(0).Mod(v, mod).Uint64()
+}
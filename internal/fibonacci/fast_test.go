@@ -0,0 +1,34 @@
+package fibonacci
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFastDoubling(t *testing.T) {
+	for _, v := range fibonacciTests {
+		assert.Equal(t, 0, v.Expected.Cmp(FastDoubling(v.Ordinal)))
+	}
+	g := NewGenerator(NewMemoryCache(nil))
+	assert.Equal(t, 0, g.Compute(1000).Cmp(FastDoubling(1000)))
+	assert.Equal(t, 0, g.Compute(1001).Cmp(FastDoubling(1001)))
+}
+
+func TestFingerprint(t *testing.T) {
+	for _, v := range fibonacciTests {
+		assert.Equal(t, NumberFingerprint(v.Expected, FingerprintModulus), Fingerprint(v.Ordinal, FingerprintModulus))
+	}
+	for _, n := range []uint64{100, 1000, 4095, 4096} {
+		expected := NumberFingerprint(FastDoubling(n), FingerprintModulus)
+		assert.Equal(t, expected, Fingerprint(n, FingerprintModulus))
+		// A small modulus exercises the wrap-around paths
+		assert.Equal(t, NumberFingerprint(FastDoubling(n), 97), Fingerprint(n, 97))
+	}
+}
+
+func TestFingerprintDetectsCorruption(t *testing.T) {
+	v := FastDoubling(500)
+	v.Add(v, NewNumber(1))
+	assert.NotEqual(t, Fingerprint(500, FingerprintModulus), NumberFingerprint(v, FingerprintModulus))
+}
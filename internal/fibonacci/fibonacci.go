@@ -2,10 +2,15 @@
 package fibonacci
 
 import (
+	"context"
+	"fmt"
 	"math/big"
 	"strconv"
 
+	"github.com/programmablemike/fibo/internal/logging"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Number is a convenience wrapper around big.Int
@@ -23,41 +28,81 @@ func NewNumberFromDecimalString(v string) (*Number, bool) {
 	return result.SetString(v, 10)
 }
 
+// contextCheckInterval is how many iterations long running loops run between cancellation checks
+const contextCheckInterval = 1024
+
 type Memoizer interface {
 	Write(ordinal uint64, value *Number) error
 	Read(ordinal uint64) (*Number, error)
 	Clear() error
 }
 
+// Entry is a single memoized value
+type Entry struct {
+	Ordinal uint64
+	Value   *Number
+}
+
+// BatchWriter is implemented by memoizers that can persist many entries in a single round trip
+type BatchWriter interface {
+	WriteBatch(entries []Entry) error
+}
+
+// BatchReader is implemented by memoizers that can read many entries in a single round trip
+// Ordinals that aren't cached are left out of the result.
+type BatchReader interface {
+	ReadBatch(ordinals []uint64) (map[uint64]*Number, error)
+}
+
 func Uint64ToString(v uint64) string {
 	return strconv.FormatUint(v, 10)
 }
 
 type Generator struct {
-	cache Memoizer
+	cache    Memoizer
+	observer Observer
 }
 
 func NewGenerator(cache Memoizer) *Generator {
 	// TODO: Convert this to using a goroutine for concurrent execution
 	return &Generator{
-		cache: cache,
+		cache:    cache,
+		observer: nopObserver{},
 	}
 }
 
+// Cache returns the memoizer backing the generator
+func (g *Generator) Cache() Memoizer {
+	return g.cache
+}
+
+// CacheContext returns the memoizer backing the generator, bound to ctx when it supports it
+func (g *Generator) CacheContext(ctx context.Context) Memoizer {
+	return g.memoizer(ctx)
+}
+
 // ClearCache wipes the memoizer's Postgres DB
 func (g *Generator) ClearCache() error {
 	return g.cache.Clear()
 }
 
 func (g *Generator) FindOrdinalsInRange(low *Number, high *Number) uint64 {
-	log.Debugf("Counting ordinals in range %s to %s...", low.String(), high.String())
+	count, _ := g.CountOrdinalsContext(context.Background(), low, high, nil)
+	return count
+}
+
+// CountOrdinalsContext is FindOrdinalsInRange with cancellation and progress reporting
+// Progress is the bit length of the current Fibonacci value relative to high.
+func (g *Generator) CountOrdinalsContext(ctx context.Context, low *Number, high *Number, progress func(float64)) (count uint64, err error) {
+	_, span := tracer.Start(ctx, "fibonacci.CountOrdinals", trace.WithAttributes(attribute.Int("fibo.bits", high.BitLen())))
+	defer func() { endSpan(span, err) }()
+	// The bounds can have millions of digits, only their sizes are logged
+	logging.FromContext(ctx).WithFields(log.Fields{"low_bits": low.BitLen(), "high_bits": high.BitLen()}).Debug("Counting ordinals")
 
 	// Initialize the first three fibonacci values
 	f0 := NewNumber(0)
 	f1 := NewNumber(1)
 	f2 := NewNumber(1)
-	// Contains the count of valid ordinals
-	count := uint64(0)
 
 	// Values for x.Cmp(y):
 	//   -1 if x <  y
@@ -70,8 +115,17 @@ func (g *Generator) FindOrdinalsInRange(low *Number, high *Number) uint64 {
 		f0 = NewNumber(0).Set(f1)
 		f1 = NewNumber(0).Set(f2)
 		f2 = NewNumber(0).Add(f0, f1)
+		// Checking every iteration would dominate the cost of counting small ranges
+		if count%contextCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return count, err
+			}
+			if progress != nil {
+				progress(float64(f0.BitLen()) / float64(high.BitLen()))
+			}
+		}
 	}
-	return count
+	return count, nil
 }
 
 // Compute Get the fibonacci value for the given ordinal
@@ -96,15 +150,104 @@ func (g Generator) Compute(n uint64) *Number {
 	}
 }
 
+// ComputeContext gets the fibonacci value for the given ordinal like Compute, but can be cancelled
+// and reports progress, which makes it suitable for very large ordinals in background jobs
+//
+// A cached value is returned as is. Otherwise the value is computed with fast doubling,
+// which doesn't recurse through every smaller ordinal, and the result is memoized.
+func (g *Generator) ComputeContext(ctx context.Context, n uint64, progress func(float64)) (*Number, error) {
+	value, _, err := g.lookup(ctx, n, progress)
+	return value, err
+}
+
+// Lookup is ComputeContext without progress reporting that also returns whether the value was
+// served from the cache
+func (g *Generator) Lookup(ctx context.Context, n uint64) (*Number, bool, error) {
+	return g.lookup(ctx, n, nil)
+}
+
+func (g *Generator) lookup(ctx context.Context, n uint64, progress func(float64)) (value *Number, cached bool, err error) {
+	ctx, span := tracer.Start(ctx, "fibonacci.Lookup", trace.WithAttributes(ordinalAttribute("fibo.ordinal", n)))
+	defer func() {
+		span.SetAttributes(attribute.Bool("fibo.cache_hit", cached))
+		endSpan(span, err)
+	}()
+	if value, err := g.read(ctx, n); err == nil {
+		return value, true, nil
+	}
+	if value, err = g.compute(ctx, n, progress); err != nil {
+		return nil, false, err
+	}
+	g.write(ctx, n, value)
+	return value, false, nil
+}
+
+// compute computes F(n) with fast doubling, which is where the time goes for large ordinals
+func (g *Generator) compute(ctx context.Context, n uint64, progress func(float64)) (*Number, error) {
+	ctx, span := tracer.Start(ctx, "fibonacci.FastDoubling", trace.WithAttributes(ordinalAttribute("fibo.ordinal", n)))
+	done := g.observer.ComputeStarted(n)
+	value, err := fastDoubling(ctx, n, progress)
+	done()
+	if err == nil {
+		span.SetAttributes(attribute.Int("fibo.bits", value.BitLen()))
+	}
+	endSpan(span, err)
+	return value, err
+}
+
+// Sequence calls fn with the fibonacci values for every ordinal in the range [from, to]
+// Only the first value is looked up (or computed), the rest are generated by the recurrence.
+func (g *Generator) Sequence(ctx context.Context, from uint64, to uint64, fn func(ordinal uint64, value *Number) error) (err error) {
+	ctx, span := tracer.Start(ctx, "fibonacci.Sequence", trace.WithAttributes(
+		ordinalAttribute("fibo.from", from),
+		ordinalAttribute("fibo.to", to),
+	))
+	defer func() { endSpan(span, err) }()
+	if from > to {
+		return fmt.Errorf("invalid range %s to %s", Uint64ToString(from), Uint64ToString(to))
+	}
+	a, err := g.ComputeContext(ctx, from, nil)
+	if err != nil {
+		return err
+	}
+	a = NewNumber(0).Set(a)
+	b, err := g.ComputeContext(ctx, from+1, nil)
+	if err != nil {
+		return err
+	}
+	b = NewNumber(0).Set(b)
+	for n := from; ; n++ {
+		if (n-from)%contextCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		if err := fn(n, NewNumber(0).Set(a)); err != nil {
+			return err
+		}
+		if n == to {
+			return nil
+		}
+		a.Add(a, b)
+		a, b = b, a
+	}
+}
+
 // readCachedOrCompute will read a value from the database if it exists
 // otherwise it will compute the value and store it in the cache for future use
+// The recursion isn't traced, it would create a span per ordinal.
 func (g *Generator) readCachedOrCompute(ordinal uint64) *Number {
 	value, err := g.cache.Read(ordinal)
 	if err != nil {
+		g.observer.CacheMisses(1)
 		value = g.Compute(ordinal)
 		if err := g.cache.Write(ordinal, value); err != nil {
 			log.Errorf("Failed to write to cache")
+		} else {
+			g.observer.CacheWrites(1)
 		}
+		return value
 	}
+	g.observer.CacheHits(1)
 	return value
 }
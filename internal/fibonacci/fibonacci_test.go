@@ -1,6 +1,7 @@
 package fibonacci
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
@@ -140,3 +141,57 @@ func BenchmarkFibonacciCached(b *testing.B) {
 		}
 	}
 }
+
+func TestFibonacciComputeContext(t *testing.T) {
+	c := NewMemoryCache(nil)
+	g := NewGenerator(c)
+	var progress []float64
+	v, err := g.ComputeContext(context.Background(), 1000, func(p float64) {
+		progress = append(progress, p)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, g.Compute(1000).Cmp(v))
+	assert.Contains(t, c.table, uint64(1000))
+	assert.IsIncreasing(t, progress)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = g.ComputeContext(ctx, 5000, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestFibonacciLookup(t *testing.T) {
+	g := NewGenerator(NewMemoryCache(nil))
+	v, cached, err := g.Lookup(context.Background(), 100)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	assert.Equal(t, "354224848179261915075", v.String())
+
+	v, cached, err = g.Lookup(context.Background(), 100)
+	assert.NoError(t, err)
+	assert.True(t, cached)
+	assert.Equal(t, "354224848179261915075", v.String())
+}
+
+func TestFibonacciSequence(t *testing.T) {
+	g := NewGenerator(NewMemoryCache(nil))
+	var values []*Number
+	err := g.Sequence(context.Background(), 5, 12, func(ordinal uint64, value *Number) error {
+		assert.Equal(t, uint64(5+len(values)), ordinal)
+		values = append(values, value)
+		return nil
+	})
+	assert.NoError(t, err)
+	for i, v := range values {
+		assert.Equal(t, fibonacciTests[5+i].Expected, v)
+	}
+	assert.Error(t, g.Sequence(context.Background(), 12, 5, nil))
+}
+
+func TestFibonacciOrdinalCountCancelled(t *testing.T) {
+	g := NewGenerator(NewMockEmptyCache())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := g.CountOrdinalsContext(ctx, NewNumber(0), FastDoubling(100000), nil)
+	assert.ErrorIs(t, err, context.Canceled)
+}
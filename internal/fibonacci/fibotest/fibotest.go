@@ -0,0 +1,42 @@
+// Test doubles shared by the tests of the packages built on a fibonacci.Generator
+package fibotest
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/programmablemike/fibo/internal/fibonacci"
+)
+
+// MemoryCache is a goroutine safe in-memory memoizer
+type MemoryCache struct {
+	mu    sync.Mutex
+	table map[uint64]*fibonacci.Number
+}
+
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{table: make(map[uint64]*fibonacci.Number)}
+}
+
+func (mc *MemoryCache) Write(ordinal uint64, value *fibonacci.Number) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.table[ordinal] = value
+	return nil
+}
+
+func (mc *MemoryCache) Read(ordinal uint64) (*fibonacci.Number, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if value, ok := mc.table[ordinal]; ok {
+		return value, nil
+	}
+	return fibonacci.NewNumber(-1), fmt.Errorf("Value not in map")
+}
+
+func (mc *MemoryCache) Clear() error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.table = make(map[uint64]*fibonacci.Number)
+	return nil
+}
@@ -0,0 +1,300 @@
+package fibonacci
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// FormatKind selects how a Number is rendered
+type FormatKind string
+
+const (
+	FormatDecimal    FormatKind = "decimal" // Base 10 digits
+	FormatHex        FormatKind = "hex"     // Lowercase base 16 digits without a prefix
+	FormatBase       FormatKind = "base"    // Digits in any base from 2 to 62
+	FormatBytes      FormatKind = "bytes"   // Raw big-endian bytes
+	FormatScientific FormatKind = "sci"     // Scientific notation with a number of significant digits
+	FormatGrouped    FormatKind = "grouped" // Decimal digits in groups of three
+)
+
+// chunkDigits is the number of digits WriteTo converts at once
+const chunkDigits = 4096
+
+// zeros pads the chunks of WriteTo
+var zeros = []byte(strings.Repeat("0", chunkDigits))
+
+const (
+	defaultSignificantDigits = 6
+	maxSignificantDigits     = 1000
+	defaultGroupSeparator    = ","
+)
+
+// NumberFormat describes how to render a Number
+type NumberFormat struct {
+	Kind      FormatKind
+	Base      int    // Base of FormatBase
+	Digits    int    // Significant digits of FormatScientific
+	Separator string // Group separator of FormatGrouped
+}
+
+// DecimalFormat is the default format
+var DecimalFormat = NumberFormat{Kind: FormatDecimal, Base: 10}
+
+// ParseNumberFormat parses a format spec of the form "name[:arg]"
+//
+// The supported specs are decimal, hex, base:N (2 <= N <= 62), bytes, sci[:DIGITS] and
+// grouped[:SEPARATOR]. An empty spec is decimal.
+func ParseNumberFormat(spec string) (NumberFormat, error) {
+	name, arg := spec, ""
+	hasArg := false
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		name, arg, hasArg = spec[:i], spec[i+1:], true
+	}
+	switch FormatKind(name) {
+	case "", FormatDecimal:
+		if !hasArg {
+			return DecimalFormat, nil
+		}
+	case FormatHex:
+		if !hasArg {
+			return NumberFormat{Kind: FormatHex, Base: 16}, nil
+		}
+	case FormatBytes:
+		if !hasArg {
+			return NumberFormat{Kind: FormatBytes}, nil
+		}
+	case FormatBase:
+		base, err := strconv.Atoi(arg)
+		if err != nil || base < 2 || base > big.MaxBase {
+			return NumberFormat{}, fmt.Errorf("invalid base %q (expected 2 to %d)", arg, big.MaxBase)
+		}
+		return NumberFormat{Kind: FormatBase, Base: base}, nil
+	case FormatScientific:
+		digits := defaultSignificantDigits
+		if hasArg {
+			var err error
+			if digits, err = strconv.Atoi(arg); err != nil || digits < 1 || digits > maxSignificantDigits {
+				return NumberFormat{}, fmt.Errorf("invalid number of significant digits %q (expected 1 to %d)", arg, maxSignificantDigits)
+			}
+		}
+		return NumberFormat{Kind: FormatScientific, Digits: digits}, nil
+	case FormatGrouped:
+		separator := defaultGroupSeparator
+		if hasArg {
+			separator = arg
+		}
+		return NumberFormat{Kind: FormatGrouped, Separator: separator}, nil
+	}
+	return NumberFormat{}, fmt.Errorf("invalid format %q (expected decimal, hex, base:N, bytes, sci[:DIGITS] or grouped[:SEPARATOR])", spec)
+}
+
+// String returns the spec of the format
+func (f NumberFormat) String() string {
+	switch f.Kind {
+	case FormatBase:
+		return fmt.Sprintf("%s:%d", f.Kind, f.Base)
+	case FormatScientific:
+		return fmt.Sprintf("%s:%d", f.Kind, f.Digits)
+	case FormatGrouped:
+		return fmt.Sprintf("%s:%s", f.Kind, f.Separator)
+	default:
+		return string(f.Kind)
+	}
+}
+
+// Binary returns true if the format isn't text
+func (f NumberFormat) Binary() bool {
+	return f.Kind == FormatBytes
+}
+
+// Append appends the formatted value to dst, the bytes format appends the raw bytes
+func (f NumberFormat) Append(dst []byte, v *Number) []byte {
+	switch f.Kind {
+	case FormatBytes:
+		return append(dst, v.Bytes()...)
+	case FormatHex, FormatBase:
+		return v.Append(dst, f.Base)
+	case FormatScientific:
+		// Enough bits for the requested digits plus guard bits so that rounding only happens in Append
+		prec := uint(float64(f.Digits)*3.33) + 64
+		return new(big.Float).SetPrec(prec).SetInt(v).Append(dst, 'e', f.Digits-1)
+	case FormatGrouped:
+		return appendGrouped(dst, v.String(), f.Separator)
+	default:
+		return v.Append(dst, 10)
+	}
+}
+
+// Text returns the formatted value as a string that can be embedded in JSON or a line of text
+// The bytes format is base64 encoded.
+func (f NumberFormat) Text(v *Number) string {
+	if f.Binary() {
+		return base64.StdEncoding.EncodeToString(v.Bytes())
+	}
+	return string(f.Append(nil, v))
+}
+
+// WriteTo writes the formatted value to w without building the whole text in memory
+// The digits are split off with divisions by powers of the base and written in chunks of
+// chunkDigits, the bytes format writes the raw bytes.
+func (f NumberFormat) WriteTo(w io.Writer, v *Number) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	switch f.Kind {
+	case FormatDecimal, FormatHex, FormatBase:
+		writeDigits(bw, v, f.Base)
+	case FormatGrouped:
+		d := DecimalDigits(v)
+		if v.Sign() < 0 {
+			bw.WriteByte('-')
+		}
+		first := d % 3
+		if first == 0 {
+			first = 3
+		}
+		writeDigits(&groupWriter{w: bw, separator: f.Separator, next: first}, new(big.Int).Abs(v), 10)
+	default:
+		bw.Write(f.Append(nil, v))
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// writeDigits writes v in base, the errors are kept by the buffered writer
+func writeDigits(w io.Writer, v *big.Int, base int) {
+	if v.Sign() < 0 {
+		w.Write([]byte{'-'})
+		v = new(big.Int).Abs(v)
+	}
+	// powers[k] is base^(chunkDigits·2^k), v < powers[k+1] splits into halves of chunkDigits·2^k digits
+	powers := []*big.Int{new(big.Int).Exp(big.NewInt(int64(base)), big.NewInt(chunkDigits), nil)}
+	for powers[len(powers)-1].Cmp(v) <= 0 {
+		last := powers[len(powers)-1]
+		powers = append(powers, new(big.Int).Mul(last, last))
+	}
+	buf := make([]byte, 0, chunkDigits)
+	var write func(x *big.Int, level int, pad int)
+	write = func(x *big.Int, level int, pad int) {
+		if level < 0 {
+			buf = x.Append(buf[:0], base)
+			if pad > len(buf) {
+				w.Write(zeros[:pad-len(buf)])
+			}
+			w.Write(buf)
+			return
+		}
+		n := chunkDigits << level
+		q, r := new(big.Int).QuoRem(x, powers[level], new(big.Int))
+		switch {
+		case pad > 0:
+			write(q, level-1, pad-n)
+		case q.Sign() > 0:
+			write(q, level-1, 0)
+		default:
+			write(r, level-1, 0)
+			return
+		}
+		write(r, level-1, n)
+	}
+	write(v, len(powers)-2, 0)
+}
+
+// groupWriter inserts the separator between the groups of three digits written through it
+type groupWriter struct {
+	w         io.Writer
+	separator string
+	next      int // Digits until the next separator
+}
+
+func (g *groupWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		if g.next == 0 {
+			io.WriteString(g.w, g.separator)
+			g.next = 3
+		}
+		n := g.next
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := g.w.Write(p[:n]); err != nil {
+			return 0, err
+		}
+		p, g.next = p[n:], g.next-n
+	}
+	return written, nil
+}
+
+// countingWriter counts the bytes written to w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// DecimalDigits returns the number of decimal digits of |v| without converting it to a string
+// The digits come from log10|v|, estimated from the top bits of v. Only a value within the error
+// of the estimate from a power of ten is compared with that power.
+func DecimalDigits(v *Number) int {
+	if v.Sign() == 0 {
+		return 1
+	}
+	log := log10Abs(v)
+	d := int(math.Floor(log)) + 1
+	if frac := log - math.Floor(log); frac > log10Error && frac < 1-log10Error {
+		return d
+	}
+	// Close to a power of ten, d is off by at most one
+	d = int(math.Floor(log + 0.5))
+	pow := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(d)), nil)
+	if pow.CmpAbs(v) <= 0 {
+		return d + 1
+	}
+	return d
+}
+
+// log10Error bounds the error of log10Abs, the float error of its exponent grows with the bit length
+const log10Error = 1e-6
+
+// log10Abs returns log10|v| computed from the top two words of v
+func log10Abs(v *Number) float64 {
+	words := v.Bits()
+	n := len(words)
+	top := float64(words[n-1])
+	exp := 0
+	if n > 1 {
+		top = top*math.Exp2(bits.UintSize) + float64(words[n-2])
+		exp = (n - 2) * bits.UintSize
+	}
+	return math.Log10(top) + float64(exp)*math.Log10(2)
+}
+
+// appendGrouped inserts the separator between every group of three digits from the right
+func appendGrouped(dst []byte, digits string, separator string) []byte {
+	if strings.HasPrefix(digits, "-") {
+		dst = append(dst, '-')
+		digits = digits[1:]
+	}
+	first := len(digits) % 3
+	if first == 0 {
+		first = 3
+	}
+	dst = append(dst, digits[:first]...)
+	for i := first; i < len(digits); i += 3 {
+		dst = append(dst, separator...)
+		dst = append(dst, digits[i:i+3]...)
+	}
+	return dst
+}
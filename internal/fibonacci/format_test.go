@@ -0,0 +1,98 @@
+package fibonacci
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNumberFormats(t *testing.T) {
+	f100 := FastDoubling(100) // 354224848179261915075
+	tests := []struct {
+		Spec     string
+		Value    *Number
+		Expected string
+	}{
+		{Spec: "", Value: f100, Expected: "354224848179261915075"},
+		{Spec: "decimal", Value: f100, Expected: "354224848179261915075"},
+		{Spec: "hex", Value: f100, Expected: "1333db76a7c594bfc3"},
+		{Spec: "base:2", Value: NewNumber(144), Expected: "10010000"},
+		{Spec: "base:62", Value: NewNumber(61), Expected: "Z"},
+		{Spec: "bytes", Value: NewNumber(258), Expected: "\x01\x02"},
+		{Spec: "sci", Value: f100, Expected: "3.54225e+20"},
+		{Spec: "sci:3", Value: f100, Expected: "3.54e+20"},
+		{Spec: "sci:1", Value: NewNumber(0), Expected: "0e+00"},
+		{Spec: "grouped", Value: f100, Expected: "354,224,848,179,261,915,075"},
+		{Spec: "grouped", Value: NewNumber(144), Expected: "144"},
+		{Spec: "grouped:_", Value: NewNumber(1597), Expected: "1_597"},
+	}
+	for _, test := range tests {
+		format, err := ParseNumberFormat(test.Spec)
+		assert.NoError(t, err, test.Spec)
+		assert.Equal(t, test.Expected, string(format.Append(nil, test.Value)), test.Spec)
+	}
+}
+
+func TestNumberFormatWriteTo(t *testing.T) {
+	// Values of several chunks, with chunks of zeros and exactly on the chunk boundaries
+	values := []*Number{NewNumber(0), NewNumber(-1597), FastDoubling(100), FastDoubling(100000)}
+	for _, exp := range []int64{chunkDigits - 1, chunkDigits, 2 * chunkDigits, 5000} {
+		pow := new(Number).Exp(NewNumber(10), NewNumber(exp), nil)
+		values = append(values, pow, new(Number).Sub(pow, NewNumber(1)), new(Number).Add(pow, NewNumber(7)))
+	}
+	for _, spec := range []string{"decimal", "hex", "base:7", "base:62", "bytes", "sci", "grouped", "grouped:_"} {
+		format, err := ParseNumberFormat(spec)
+		assert.NoError(t, err, spec)
+		for _, v := range values {
+			var sb strings.Builder
+			n, err := format.WriteTo(&sb, v)
+			assert.NoError(t, err, spec)
+			want := string(format.Append(nil, v))
+			assert.Equal(t, int64(len(want)), n, spec)
+			assert.True(t, want == sb.String(), "%s of a %d bit value", spec, v.BitLen())
+		}
+	}
+}
+
+func TestNumberFormatText(t *testing.T) {
+	format, err := ParseNumberFormat("bytes")
+	assert.NoError(t, err)
+	assert.True(t, format.Binary())
+	assert.Equal(t, "AQI=", format.Text(NewNumber(258)))
+
+	format, err = ParseNumberFormat("hex")
+	assert.NoError(t, err)
+	assert.False(t, format.Binary())
+	assert.Equal(t, "ff", format.Text(NewNumber(255)))
+}
+
+func TestParseNumberFormatErrors(t *testing.T) {
+	for _, spec := range []string{"octal", "base", "base:1", "base:63", "sci:0", "sci:x", "hex:2", "decimal:1"} {
+		_, err := ParseNumberFormat(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestDecimalDigits(t *testing.T) {
+	for _, v := range []string{"0", "1", "9", "10", "99", "100", "999999999999999999999", "1000000000000000000000"} {
+		n, _ := NewNumberFromDecimalString(v)
+		assert.Equal(t, len(v), DecimalDigits(n), v)
+	}
+	for _, ordinal := range []uint64{1000, 4782, 100000} {
+		v := FastDoubling(ordinal)
+		assert.Equal(t, len(v.String()), DecimalDigits(v), ordinal)
+	}
+	// Every value of a sequence and the neighbours of large powers of ten
+	a, b := NewNumber(0), NewNumber(1)
+	for ordinal := 0; ordinal < 5000; ordinal++ {
+		assert.Equal(t, len(a.String()), DecimalDigits(a), ordinal)
+		a, b = b, a.Add(a, b)
+	}
+	for _, exp := range []int64{19, 20, 1000, 5000} {
+		pow := new(Number).Exp(NewNumber(10), NewNumber(exp), nil)
+		for _, v := range []*Number{new(Number).Sub(pow, NewNumber(1)), pow, new(Number).Neg(pow)} {
+			assert.Equal(t, len(new(Number).Abs(v).String()), DecimalDigits(v), v.BitLen())
+		}
+	}
+}
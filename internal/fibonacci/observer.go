@@ -0,0 +1,30 @@
+package fibonacci
+
+// Observer is told about the cache traffic and computations of a Generator
+// It lets the server export metrics without the generator depending on a metrics library.
+type Observer interface {
+	// CacheHits is called with the number of values served from the memoizer
+	CacheHits(n int)
+	// CacheMisses is called with the number of values the memoizer didn't have
+	CacheMisses(n int)
+	// CacheWrites is called with the number of values persisted to the memoizer
+	CacheWrites(n int)
+	// ComputeStarted is called before a value is computed, the returned function once it's done
+	ComputeStarted(ordinal uint64) func()
+}
+
+// nopObserver is the observer of generators that aren't observed
+type nopObserver struct{}
+
+func (nopObserver) CacheHits(int)                {}
+func (nopObserver) CacheMisses(int)              {}
+func (nopObserver) CacheWrites(int)              {}
+func (nopObserver) ComputeStarted(uint64) func() { return func() {} }
+
+// SetObserver reports the generator's cache traffic and computations to o
+func (g *Generator) SetObserver(o Observer) {
+	if o == nil {
+		o = nopObserver{}
+	}
+	g.observer = o
+}
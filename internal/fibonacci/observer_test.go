@@ -0,0 +1,62 @@
+package fibonacci
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// CountingObserver tallies the events reported by a Generator
+type CountingObserver struct {
+	hits, misses, writes int
+	computed             []uint64
+	inFlight             int
+}
+
+func (o *CountingObserver) CacheHits(n int)   { o.hits += n }
+func (o *CountingObserver) CacheMisses(n int) { o.misses += n }
+func (o *CountingObserver) CacheWrites(n int) { o.writes += n }
+
+func (o *CountingObserver) ComputeStarted(ordinal uint64) func() {
+	o.computed = append(o.computed, ordinal)
+	o.inFlight++
+	return func() { o.inFlight-- }
+}
+
+func TestObserverLookup(t *testing.T) {
+	o := &CountingObserver{}
+	g := NewGenerator(NewMemoryCache(nil))
+	g.SetObserver(o)
+	for i := 0; i < 2; i++ {
+		_, _, err := g.Lookup(context.Background(), 100)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, o.hits)
+	assert.Equal(t, 1, o.misses)
+	assert.Equal(t, 1, o.writes)
+	assert.Equal(t, []uint64{100}, o.computed)
+	assert.Equal(t, 0, o.inFlight)
+}
+
+func TestObserverBatch(t *testing.T) {
+	o := &CountingObserver{}
+	c := &BatchMemoryCache{MemoryCache: NewMemoryCache(map[uint64]*Number{12: NewNumber(144)})}
+	g := NewGenerator(c)
+	g.SetObserver(o)
+	err := g.Batch(context.Background(), []uint64{12, 5, 300}, func(ordinal uint64, value *Number, cached bool) error {
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, o.hits)
+	assert.Equal(t, 2, o.misses)
+	assert.Equal(t, 2, o.writes)
+	assert.Equal(t, []uint64{5, 300}, o.computed)
+	assert.Equal(t, 0, o.inFlight)
+
+	// Warming only writes
+	o = &CountingObserver{}
+	g.SetObserver(o)
+	assert.NoError(t, g.Warm(context.Background(), 99, 1, nil))
+	assert.Equal(t, CountingObserver{writes: 100}, *o)
+}
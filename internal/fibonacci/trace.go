@@ -0,0 +1,76 @@
+package fibonacci
+
+import (
+	"context"
+	"math"
+
+	"github.com/programmablemike/fibo/internal/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of the generator, they're dropped unless the server sets up tracing
+var tracer = otel.Tracer("github.com/programmablemike/fibo/internal/fibonacci")
+
+// ContextBinder is implemented by memoizers that can tie their work to a context
+// The memoizer returned by WithContext is used for the calls made on behalf of a traced caller, so
+// that the spans of its queries are children of the caller's span.
+type ContextBinder interface {
+	WithContext(ctx context.Context) Memoizer
+}
+
+// ordinalAttribute records an ordinal on a span, as a string if it doesn't fit an int64
+func ordinalAttribute(key string, n uint64) attribute.KeyValue {
+	if n > math.MaxInt64 {
+		return attribute.String(key, Uint64ToString(n))
+	}
+	return attribute.Int64(key, int64(n))
+}
+
+// endSpan records the error of the traced work, if any, and ends the span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// memoizer returns the memoizer bound to ctx when it supports it
+func (g *Generator) memoizer(ctx context.Context) Memoizer {
+	if b, ok := g.cache.(ContextBinder); ok {
+		return b.WithContext(ctx)
+	}
+	return g.cache
+}
+
+// read reads a single value from the memoizer, a miss isn't an error of the span
+func (g *Generator) read(ctx context.Context, ordinal uint64) (*Number, error) {
+	ctx, span := tracer.Start(ctx, "memoizer.Read", trace.WithAttributes(ordinalAttribute("fibo.ordinal", ordinal)))
+	defer span.End()
+	value, err := g.memoizer(ctx).Read(ordinal)
+	span.SetAttributes(attribute.Bool("fibo.cache_hit", err == nil))
+	if err != nil {
+		g.observer.CacheMisses(1)
+		return nil, err
+	}
+	g.observer.CacheHits(1)
+	return value, nil
+}
+
+// write memoizes a single value, failures are only logged since the value can be computed again
+func (g *Generator) write(ctx context.Context, ordinal uint64, value *Number) {
+	ctx, span := tracer.Start(ctx, "memoizer.Write", trace.WithAttributes(
+		ordinalAttribute("fibo.ordinal", ordinal),
+		attribute.Int("fibo.bits", value.BitLen()),
+	))
+	err := g.memoizer(ctx).Write(ordinal, value)
+	endSpan(span, err)
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Failed to write to cache: %s", err)
+		return
+	}
+	g.observer.CacheWrites(1)
+}
@@ -0,0 +1,53 @@
+package fibonacci
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// BoundMemoryCache is a MemoryCache that records the contexts it's bound to
+type BoundMemoryCache struct {
+	*MemoryCache
+	contexts []context.Context
+}
+
+func (bc *BoundMemoryCache) WithContext(ctx context.Context) Memoizer {
+	bc.contexts = append(bc.contexts, ctx)
+	return bc.MemoryCache
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	c := &BoundMemoryCache{MemoryCache: NewMemoryCache(nil)}
+	g := NewGenerator(c)
+	_, _, err := g.Lookup(context.Background(), 1000)
+	assert.NoError(t, err)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	lookup := spans["fibonacci.Lookup"]
+	if !assert.NotNil(t, lookup) {
+		return
+	}
+	for _, name := range []string{"memoizer.Read", "fibonacci.FastDoubling", "memoizer.Write"} {
+		if assert.Contains(t, spans, name) {
+			assert.Equal(t, lookup.SpanContext().SpanID(), spans[name].Parent().SpanID(), name)
+		}
+	}
+	// The memoizer is bound to the context of the read and write spans
+	if assert.Len(t, c.contexts, 2) {
+		assert.Equal(t, spans["memoizer.Read"].SpanContext(), trace.SpanContextFromContext(c.contexts[0]))
+		assert.Equal(t, spans["memoizer.Write"].SpanContext(), trace.SpanContextFromContext(c.contexts[1]))
+	}
+}
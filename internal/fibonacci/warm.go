@@ -0,0 +1,94 @@
+package fibonacci
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// warmBatchSize is the number of entries persisted per batch while warming the cache
+const warmBatchSize = 1000
+
+// warmBatchBytes bounds the size of the values of a batch, large values fill a batch long
+// before it has warmBatchSize entries
+var warmBatchBytes = 16 << 20
+
+// Warm precomputes values up to and including the ordinal to and persists them in the memoizer
+//
+// With step == 1 every value is stored. With a larger step only checkpoint pairs F(k*step-1),
+// F(k*step) are stored, which is enough for Compute to stop recursing at the nearest checkpoint
+// while using a fraction of the storage. progress is called with the highest ordinal persisted
+// after every batch.
+func (g *Generator) Warm(ctx context.Context, to uint64, step uint64, progress func(done uint64)) (err error) {
+	ctx, span := tracer.Start(ctx, "fibonacci.Warm", trace.WithAttributes(
+		ordinalAttribute("fibo.to", to),
+		ordinalAttribute("fibo.step", step),
+	))
+	defer func() { endSpan(span, err) }()
+	if step == 0 {
+		return fmt.Errorf("step must be at least 1")
+	}
+	batch := make([]Entry, 0, warmBatchSize)
+	batchBytes := 0 // Size of the values in the batch
+	flush := func(done uint64) error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := g.writeBatch(ctx, batch); err != nil {
+			return err
+		}
+		batch = batch[:0]
+		batchBytes = 0
+		if progress != nil {
+			progress(done)
+		}
+		return nil
+	}
+
+	a := NewNumber(0) // F(n)
+	b := NewNumber(1) // F(n+1)
+	for n := uint64(0); ; n++ {
+		checkpoint := step == 1 || (n+1)%step == 0 || n%step == 0 || n+1 == to || n == to
+		if checkpoint {
+			batch = append(batch, Entry{Ordinal: n, Value: NewNumber(0).Set(a)})
+			batchBytes += (a.BitLen() + 7) / 8
+		}
+		if len(batch) == warmBatchSize || batchBytes >= warmBatchBytes {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := flush(n); err != nil {
+				return err
+			}
+		}
+		if n == to {
+			break
+		}
+		a.Add(a, b)
+		a, b = b, a
+	}
+	return flush(to)
+}
+
+// writeBatch persists entries with a single bulk write when the memoizer supports it
+func (g *Generator) writeBatch(ctx context.Context, entries []Entry) (err error) {
+	ctx, span := tracer.Start(ctx, "memoizer.WriteBatch", trace.WithAttributes(attribute.Int("fibo.entries", len(entries))))
+	defer func() { endSpan(span, err) }()
+	cache := g.memoizer(ctx)
+	if bw, ok := cache.(BatchWriter); ok {
+		if err := bw.WriteBatch(entries); err != nil {
+			return err
+		}
+		g.observer.CacheWrites(len(entries))
+		return nil
+	}
+	for _, e := range entries {
+		if err := cache.Write(e.Ordinal, e.Value); err != nil {
+			return err
+		}
+		g.observer.CacheWrites(1)
+	}
+	return nil
+}
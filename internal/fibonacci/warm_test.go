@@ -0,0 +1,78 @@
+package fibonacci
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// BatchMemoryCache is a MemoryCache that records the size of every batch written to it
+type BatchMemoryCache struct {
+	*MemoryCache
+	batches []int
+}
+
+func (bc *BatchMemoryCache) WriteBatch(entries []Entry) error {
+	bc.batches = append(bc.batches, len(entries))
+	for _, e := range entries {
+		bc.table[e.Ordinal] = e.Value
+	}
+	return nil
+}
+
+func TestWarmEveryOrdinal(t *testing.T) {
+	c := &BatchMemoryCache{MemoryCache: NewMemoryCache(nil)}
+	g := NewGenerator(c)
+	var progress []uint64
+	assert.NoError(t, g.Warm(context.Background(), 2500, 1, func(done uint64) {
+		progress = append(progress, done)
+	}))
+	assert.Equal(t, []int{1000, 1000, 501}, c.batches)
+	assert.Equal(t, []uint64{999, 1999, 2500}, progress)
+	for _, v := range fibonacciTests {
+		assert.Equal(t, v.Expected, c.table[v.Ordinal])
+	}
+	assert.Equal(t, 0, FastDoubling(2500).Cmp(c.table[2500]))
+}
+
+func TestWarmBatchBytes(t *testing.T) {
+	defer func(v int) { warmBatchBytes = v }(warmBatchBytes)
+	warmBatchBytes = 1000
+	c := &BatchMemoryCache{MemoryCache: NewMemoryCache(nil)}
+	g := NewGenerator(c)
+	assert.NoError(t, g.Warm(context.Background(), 2500, 1, nil))
+	// F(2500) has 217 bytes so the batches shrink as the values grow
+	assert.Greater(t, len(c.batches), 3)
+	assert.Less(t, c.batches[len(c.batches)-1], 10)
+	total := 0
+	for _, n := range c.batches {
+		total += n
+	}
+	assert.Equal(t, 2501, total)
+	assert.Equal(t, 0, FastDoubling(2500).Cmp(c.table[2500]))
+}
+
+func TestWarmCheckpoints(t *testing.T) {
+	c := NewMemoryCache(nil)
+	g := NewGenerator(c)
+	assert.NoError(t, g.Warm(context.Background(), 105, 50, nil))
+	var ordinals []uint64
+	for ordinal := range c.table {
+		ordinals = append(ordinals, ordinal)
+	}
+	assert.ElementsMatch(t, []uint64{0, 49, 50, 99, 100, 104, 105}, ordinals)
+	assert.Equal(t, 0, FastDoubling(100).Cmp(c.table[100]))
+
+	// Compute only has to walk back to the nearest checkpoint pair
+	assert.Equal(t, 0, FastDoubling(80).Cmp(g.Compute(80)))
+	assert.NotContains(t, c.table, uint64(30))
+}
+
+func TestWarmCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	g := NewGenerator(NewMemoryCache(nil))
+	assert.ErrorIs(t, g.Warm(ctx, 5000, 1, nil), context.Canceled)
+	assert.Error(t, g.Warm(context.Background(), 10, 0, nil))
+}
@@ -0,0 +1,158 @@
+// Runs long Fibonacci computations asynchronously and persists their state
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/programmablemike/fibo/internal/ratelimit"
+)
+
+// ErrNotFound is returned when a job ID is unknown
+var ErrNotFound = errors.New("job not found")
+
+const (
+	// MaxSequenceLength is the largest range of a sequence job
+	MaxSequenceLength = 1000000
+	// MaxSequenceDigits bounds the decimal digits of a sequence job, its result is kept in memory
+	MaxSequenceDigits = 1 << 28
+)
+
+// Kind is the operation a job performs
+type Kind string
+
+const (
+	KindCalculate Kind = "calculate" // Compute F(Ordinal)
+	KindCount     Kind = "count"     // Count the ordinals with F(n) <= Number
+	KindSequence  Kind = "sequence"  // Compute F(From)..F(To)
+	KindWarm      Kind = "warm"      // Warm the cache up to To with Step
+)
+
+// State is the lifecycle state of a job
+type State string
+
+const (
+	StatePending   State = "pending"
+	StateRunning   State = "running"
+	StateSucceeded State = "succeeded"
+	StateFailed    State = "failed"
+	StateCancelled State = "cancelled"
+)
+
+// Finished returns true for the terminal states
+func (s State) Finished() bool {
+	return s == StateSucceeded || s == StateFailed || s == StateCancelled
+}
+
+// Job is a single asynchronous computation
+//
+// The parameters that are used depend on the Kind. Result isn't part of the JSON
+// representation because it can be megabytes long, it's served separately.
+type Job struct {
+	ID        string    `json:"id" gorm:"primaryKey"`
+	Kind      Kind      `json:"kind"`
+	State     State     `json:"state"`
+	Ordinal   uint64    `json:"ordinal,omitempty"`
+	Number    string    `json:"number,omitempty"`
+	From      uint64    `json:"from,omitempty"`
+	To        uint64    `json:"to,omitempty"`
+	Step      uint64    `json:"step,omitempty"`
+	Progress  float64   `json:"progress"`
+	Result    string    `json:"-"`
+	Error     string    `json:"error,omitempty"`
+	Owner     string    `json:"owner,omitempty"` // Name of the API key that submitted the job
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Validate checks that the parameters required by the job kind are present
+func (j *Job) Validate() error {
+	switch j.Kind {
+	case KindCalculate:
+		return nil
+	case KindCount:
+		if j.Number == "" {
+			return fmt.Errorf("count jobs require a number")
+		}
+		return nil
+	case KindSequence:
+		if j.From > j.To {
+			return fmt.Errorf("sequence jobs require from <= to")
+		}
+		if j.To-j.From >= MaxSequenceLength {
+			return fmt.Errorf("sequence jobs are limited to %d values", MaxSequenceLength)
+		}
+		if ratelimit.RangeDigits(j.From, j.To) > MaxSequenceDigits {
+			return fmt.Errorf("sequence jobs are limited to %d digits", MaxSequenceDigits)
+		}
+		return nil
+	case KindWarm:
+		if j.Step == 0 {
+			j.Step = 1
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid job kind %q (expected calculate, count, sequence or warm)", j.Kind)
+	}
+}
+
+// Store persists jobs so that they survive restarts
+type Store interface {
+	SaveJob(job *Job) error
+	LoadJob(id string) (*Job, error)
+	// UnfinishedJobs returns the jobs that are pending or running
+	UnfinishedJobs() ([]*Job, error)
+}
+
+// newID creates a random job ID
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// MemoryStore keeps jobs in memory, it's used when the cache backend can't store jobs
+type MemoryStore struct {
+	mu   sync.Mutex
+	jobs map[string]Job
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{jobs: make(map[string]Job)}
+}
+
+func (ms *MemoryStore) SaveJob(job *Job) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.jobs[job.ID] = *job
+	return nil
+}
+
+func (ms *MemoryStore) LoadJob(id string) (*Job, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	job, ok := ms.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &job, nil
+}
+
+func (ms *MemoryStore) UnfinishedJobs() ([]*Job, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	var jobs []*Job
+	for _, job := range ms.jobs {
+		if !job.State.Finished() {
+			job := job
+			jobs = append(jobs, &job)
+		}
+	}
+	return jobs, nil
+}
@@ -0,0 +1,330 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/programmablemike/fibo/internal/fibonacci"
+	log "github.com/sirupsen/logrus"
+)
+
+// progressSaveInterval limits how often progress updates are written to the store
+const progressSaveInterval = 1 * time.Second
+
+// ErrShuttingDown is returned for jobs submitted once Shutdown was called
+var ErrShuttingDown = errors.New("the server is shutting down")
+
+// Manager runs submitted jobs on a bounded number of workers
+type Manager struct {
+	gen      *fibonacci.Generator
+	store    Store
+	workers  chan struct{}
+	stopping chan struct{} // Closed by Shutdown so that pending jobs don't start
+	wg       sync.WaitGroup
+
+	mu          sync.Mutex
+	active      map[string]*activeJob // The jobs queued or running on this instance
+	closing     bool
+	interrupted bool // Running jobs were cancelled by Shutdown
+}
+
+// activeJob is a job owned by a goroutine of the manager
+type activeJob struct {
+	cancel context.CancelFunc
+	done   chan struct{} // Closed once the goroutine has recorded the outcome of the job
+}
+
+// NewManager creates a manager that runs up to workers jobs at the same time
+func NewManager(gen *fibonacci.Generator, store Store, workers int) *Manager {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Manager{
+		gen:      gen,
+		store:    store,
+		workers:  make(chan struct{}, workers),
+		stopping: make(chan struct{}),
+		active:   make(map[string]*activeJob),
+	}
+}
+
+// Resume requeues the jobs that were pending when the server last stopped
+// They are restarted from the beginning. Shutdown leaves the jobs it interrupts pending, so a job
+// that is still running was interrupted by a crash. It's failed instead of resumed, in case it
+// caused the crash, and so are the jobs that don't pass the current validation.
+func (m *Manager) Resume() error {
+	jobs, err := m.store.UnfinishedJobs()
+	if err != nil {
+		return fmt.Errorf("failed to load unfinished jobs: %w", err)
+	}
+	for _, job := range jobs {
+		var reason error
+		if job.State == StateRunning {
+			reason = errors.New("the server stopped while the job was running")
+		} else {
+			reason = job.Validate()
+		}
+		if reason != nil {
+			log.Warnf("Not resuming %s job %s: %s", job.Kind, job.ID, reason)
+			job.State = StateFailed
+			job.Error = reason.Error()
+			job.UpdatedAt = time.Now().UTC()
+			if err := m.store.SaveJob(job); err != nil {
+				return fmt.Errorf("failed to save job: %w", err)
+			}
+			continue
+		}
+		log.Infof("Resuming %s job %s...", job.Kind, job.ID)
+		job.State = StatePending
+		job.Progress = 0
+		if err := m.enqueue(job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Submit validates and persists a new job and queues it for execution
+func (m *Manager) Submit(job *Job) (*Job, error) {
+	if err := job.Validate(); err != nil {
+		return nil, err
+	}
+	id, err := newID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create job ID: %w", err)
+	}
+	now := time.Now().UTC()
+	job.ID = id
+	job.State = StatePending
+	job.Progress = 0
+	job.Result = ""
+	job.Error = ""
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	if err := m.enqueue(job); err != nil {
+		return nil, err
+	}
+	log.Infof("Submitted %s job %s.", job.Kind, job.ID)
+	return job, nil
+}
+
+// Get returns the current state of a job
+func (m *Manager) Get(id string) (*Job, error) {
+	return m.store.LoadJob(id)
+}
+
+// Cancel stops a pending or running job
+// A job owned by this instance is cancelled by its worker, which records the outcome, so a job that
+// finished in the meantime keeps its result.
+func (m *Manager) Cancel(id string) (*Job, error) {
+	job, err := m.store.LoadJob(id)
+	if err != nil {
+		return nil, err
+	}
+	if job.State.Finished() {
+		return job, fmt.Errorf("job %s already %s", id, job.State)
+	}
+	m.mu.Lock()
+	active, ok := m.active[id]
+	m.mu.Unlock()
+	if ok {
+		active.cancel()
+		<-active.done
+		if job, err = m.store.LoadJob(id); err != nil {
+			return nil, err
+		}
+		if job.State == StateCancelled {
+			log.Infof("Cancelled job %s.", id)
+			return job, nil
+		}
+		if job.State.Finished() {
+			return job, fmt.Errorf("job %s already %s", id, job.State)
+		}
+		// Interrupted by Shutdown, the worker left it pending
+	}
+	// A job owned by another instance (or left over from a crash) has no worker here so the
+	// cancellation is recorded directly
+	job.State = StateCancelled
+	job.UpdatedAt = time.Now().UTC()
+	if err := m.store.SaveJob(job); err != nil {
+		return nil, err
+	}
+	log.Infof("Cancelled job %s.", id)
+	return job, nil
+}
+
+// enqueue saves the job and starts a goroutine that waits for a free worker
+// The worker gets its own copy of the job so the caller's copy can be read safely.
+func (m *Manager) enqueue(submitted *Job) error {
+	m.mu.Lock()
+	if m.closing {
+		m.mu.Unlock()
+		return ErrShuttingDown
+	}
+	m.wg.Add(1)
+	m.mu.Unlock()
+	if err := m.store.SaveJob(submitted); err != nil {
+		m.wg.Done()
+		return fmt.Errorf("failed to save job: %w", err)
+	}
+	job := *submitted
+	ctx, cancel := context.WithCancel(context.Background())
+	active := &activeJob{cancel: cancel, done: make(chan struct{})}
+	m.mu.Lock()
+	m.active[job.ID] = active
+	if m.interrupted {
+		cancel()
+	}
+	m.mu.Unlock()
+
+	go func() {
+		defer func() {
+			m.mu.Lock()
+			delete(m.active, job.ID)
+			m.mu.Unlock()
+			cancel()
+			close(active.done)
+			m.wg.Done()
+		}()
+		select {
+		case m.workers <- struct{}{}:
+			defer func() { <-m.workers }()
+		case <-m.stopping:
+			// The job stays pending in the store and is resumed on restart
+			return
+		case <-ctx.Done():
+			m.finish(&job, ctx.Err())
+			return
+		}
+		m.run(ctx, &job)
+	}()
+	return nil
+}
+
+// Shutdown stops accepting jobs and waits for the running ones to finish
+// Pending jobs aren't started. When ctx ends first the running jobs are interrupted and saved as
+// pending, so that Resume restarts them like the jobs that didn't start.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	if !m.closing {
+		m.closing = true
+		close(m.stopping)
+	}
+	m.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	m.mu.Lock()
+	m.interrupted = true
+	for _, active := range m.active {
+		active.cancel()
+	}
+	m.mu.Unlock()
+	<-done
+	return ctx.Err()
+}
+
+// run executes a job and records its outcome
+func (m *Manager) run(ctx context.Context, job *Job) {
+	job.State = StateRunning
+	job.UpdatedAt = time.Now().UTC()
+	if err := m.store.SaveJob(job); err != nil {
+		log.Errorf("Failed to save job %s: %s", job.ID, err)
+	}
+
+	lastSave := time.Now()
+	progress := func(p float64) {
+		job.Progress = p
+		if time.Since(lastSave) < progressSaveInterval {
+			return
+		}
+		lastSave = time.Now()
+		job.UpdatedAt = lastSave.UTC()
+		if err := m.store.SaveJob(job); err != nil {
+			log.Errorf("Failed to save job %s progress: %s", job.ID, err)
+		}
+	}
+
+	var err error
+	switch job.Kind {
+	case KindCalculate:
+		var v *fibonacci.Number
+		if v, err = m.gen.ComputeContext(ctx, job.Ordinal, progress); err == nil {
+			job.Result = v.String()
+		}
+	case KindCount:
+		number, ok := fibonacci.NewNumberFromDecimalString(job.Number)
+		if !ok {
+			err = fmt.Errorf("failed to parse Fibonacci number value")
+			break
+		}
+		var count uint64
+		if count, err = m.gen.CountOrdinalsContext(ctx, fibonacci.NewNumber(0), number, progress); err == nil {
+			job.Result = fibonacci.Uint64ToString(count)
+		}
+	case KindSequence:
+		var sb strings.Builder
+		total := float64(job.To-job.From) + 1
+		err = m.gen.Sequence(ctx, job.From, job.To, func(ordinal uint64, value *fibonacci.Number) error {
+			sb.WriteString(value.String())
+			sb.WriteByte('\n')
+			progress(float64(ordinal-job.From+1) / total)
+			return nil
+		})
+		if err == nil {
+			job.Result = sb.String()
+		}
+	case KindWarm:
+		err = m.gen.Warm(ctx, job.To, job.Step, func(done uint64) {
+			progress(float64(done) / float64(job.To+1))
+		})
+	}
+	m.finish(job, err)
+}
+
+// finish records the terminal state of a job
+func (m *Manager) finish(job *Job, err error) {
+	m.mu.Lock()
+	interrupted := m.interrupted
+	m.mu.Unlock()
+	if interrupted && errors.Is(err, context.Canceled) {
+		log.Warnf("Interrupted job %s, it will be resumed on restart.", job.ID)
+		job.State = StatePending
+		job.Progress = 0
+		job.UpdatedAt = time.Now().UTC()
+		if err := m.store.SaveJob(job); err != nil {
+			log.Errorf("Failed to save job %s: %s", job.ID, err)
+		}
+		return
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		job.State = StateCancelled
+	case err != nil:
+		job.State = StateFailed
+		job.Error = err.Error()
+		log.Errorf("Job %s failed: %s", job.ID, err)
+	default:
+		job.State = StateSucceeded
+		job.Progress = 1
+		log.Infof("Job %s succeeded.", job.ID)
+	}
+	job.UpdatedAt = time.Now().UTC()
+	if job.State == StateCancelled {
+		job.Result = ""
+	}
+	if err := m.store.SaveJob(job); err != nil {
+		log.Errorf("Failed to save job %s: %s", job.ID, err)
+	}
+}
@@ -0,0 +1,201 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/programmablemike/fibo/internal/fibonacci"
+	"github.com/programmablemike/fibo/internal/fibonacci/fibotest"
+	"github.com/stretchr/testify/assert"
+)
+
+// waitFor polls the store until the job reaches a finished state
+func waitFor(t *testing.T, m *Manager, id string) *Job {
+	var job *Job
+	assert.Eventually(t, func() bool {
+		var err error
+		job, err = m.Get(id)
+		return err == nil && job.State.Finished()
+	}, 5*time.Second, 10*time.Millisecond)
+	return job
+}
+
+func TestManagerRunsJobs(t *testing.T) {
+	m := NewManager(fibonacci.NewGenerator(fibotest.NewMemoryCache()), NewMemoryStore(), 2)
+	tests := []struct {
+		Job      Job
+		Expected string
+	}{
+		{Job: Job{Kind: KindCalculate, Ordinal: 100}, Expected: "354224848179261915075"},
+		{Job: Job{Kind: KindCount, Number: "120"}, Expected: "12"},
+		{Job: Job{Kind: KindSequence, From: 10, To: 13}, Expected: "55\n89\n144\n233\n"},
+		{Job: Job{Kind: KindWarm, To: 50, Step: 1}, Expected: ""},
+	}
+	for _, test := range tests {
+		job := test.Job
+		submitted, err := m.Submit(&job)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, submitted.ID)
+
+		finished := waitFor(t, m, submitted.ID)
+		assert.Equal(t, StateSucceeded, finished.State, finished.Error)
+		assert.Equal(t, 1.0, finished.Progress)
+		assert.Equal(t, test.Expected, finished.Result)
+	}
+}
+
+func TestManagerRejectsInvalidJobs(t *testing.T) {
+	m := NewManager(fibonacci.NewGenerator(fibotest.NewMemoryCache()), NewMemoryStore(), 1)
+	_, err := m.Submit(&Job{Kind: "divide"})
+	assert.Error(t, err)
+	_, err = m.Submit(&Job{Kind: KindSequence, From: 10, To: 5})
+	assert.Error(t, err)
+	_, err = m.Submit(&Job{Kind: KindSequence, From: 0, To: MaxSequenceLength})
+	assert.Error(t, err)
+	_, err = m.Submit(&Job{Kind: KindSequence, From: 10000000, To: 10000000 + 1000})
+	assert.Error(t, err)
+	_, err = m.Get("missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestManagerCancel(t *testing.T) {
+	// A single worker that's kept busy means the second job stays pending until it's cancelled
+	m := NewManager(fibonacci.NewGenerator(fibotest.NewMemoryCache()), NewMemoryStore(), 1)
+	m.workers <- struct{}{}
+	job, err := m.Submit(&Job{Kind: KindCalculate, Ordinal: 10})
+	assert.NoError(t, err)
+
+	cancelled, err := m.Cancel(job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, StateCancelled, cancelled.State)
+	assert.Equal(t, StateCancelled, waitFor(t, m, job.ID).State)
+	_, err = m.Cancel(job.ID)
+	assert.Error(t, err)
+}
+
+// gatedStore holds the save of the succeeded jobs until release is closed
+type gatedStore struct {
+	Store
+	saving  chan struct{}
+	release chan struct{}
+}
+
+func (s *gatedStore) SaveJob(job *Job) error {
+	if job.State == StateSucceeded {
+		close(s.saving)
+		<-s.release
+	}
+	return s.Store.SaveJob(job)
+}
+
+func TestManagerCancelFinishedJob(t *testing.T) {
+	store := &gatedStore{Store: NewMemoryStore(), saving: make(chan struct{}), release: make(chan struct{})}
+	m := NewManager(fibonacci.NewGenerator(fibotest.NewMemoryCache()), store, 1)
+	job, err := m.Submit(&Job{Kind: KindCalculate, Ordinal: 10})
+	assert.NoError(t, err)
+
+	// The job is still running in the store when it's cancelled, but its worker is saving its result
+	<-store.saving
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(store.release)
+	}()
+	finished, err := m.Cancel(job.ID)
+	assert.Error(t, err)
+	assert.Equal(t, StateSucceeded, finished.State)
+	finished = waitFor(t, m, job.ID)
+	assert.Equal(t, StateSucceeded, finished.State)
+	assert.Equal(t, "55", finished.Result)
+}
+
+func TestManagerResume(t *testing.T) {
+	store := NewMemoryStore()
+	assert.NoError(t, store.SaveJob(&Job{ID: "interrupted", Kind: KindCalculate, Ordinal: 12, State: StatePending}))
+	assert.NoError(t, store.SaveJob(&Job{ID: "done", Kind: KindCalculate, Ordinal: 11, State: StateSucceeded, Result: "89"}))
+	// Jobs left running crashed the server, and jobs that are too large now aren't resumed
+	assert.NoError(t, store.SaveJob(&Job{ID: "crashed", Kind: KindCalculate, Ordinal: 13, State: StateRunning, Progress: 0.5}))
+	assert.NoError(t, store.SaveJob(&Job{ID: "huge", Kind: KindSequence, From: 0, To: 10000000, State: StatePending}))
+
+	m := NewManager(fibonacci.NewGenerator(fibotest.NewMemoryCache()), store, 1)
+	assert.NoError(t, m.Resume())
+	job := waitFor(t, m, "interrupted")
+	assert.Equal(t, StateSucceeded, job.State)
+	assert.Equal(t, "144", job.Result)
+	for _, id := range []string{"crashed", "huge"} {
+		job, err := m.Get(id)
+		assert.NoError(t, err)
+		assert.Equal(t, StateFailed, job.State, id)
+		assert.NotEmpty(t, job.Error, id)
+	}
+}
+
+// SlowCache is a MemoryCache whose writes take a millisecond
+type SlowCache struct {
+	*fibotest.MemoryCache
+}
+
+func (sc SlowCache) Write(ordinal uint64, value *fibonacci.Number) error {
+	time.Sleep(time.Millisecond)
+	return sc.MemoryCache.Write(ordinal, value)
+}
+
+func TestManagerShutdown(t *testing.T) {
+	// Pending jobs aren't started and stay pending
+	m := NewManager(fibonacci.NewGenerator(fibotest.NewMemoryCache()), NewMemoryStore(), 1)
+	m.workers <- struct{}{}
+	pending, err := m.Submit(&Job{Kind: KindCalculate, Ordinal: 10})
+	assert.NoError(t, err)
+	assert.NoError(t, m.Shutdown(context.Background()))
+	job, err := m.Get(pending.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, StatePending, job.State)
+	_, err = m.Submit(&Job{Kind: KindCalculate, Ordinal: 10})
+	assert.ErrorIs(t, err, ErrShuttingDown)
+
+	// Running jobs are interrupted once the grace period is over and left pending for the restart
+	store := NewMemoryStore()
+	m = NewManager(fibonacci.NewGenerator(SlowCache{fibotest.NewMemoryCache()}), store, 1)
+	running, err := m.Submit(&Job{Kind: KindWarm, To: 100000, Step: 1})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		job, err := m.Get(running.ID)
+		return err == nil && job.State == StateRunning
+	}, 5*time.Second, 10*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, m.Shutdown(ctx), context.DeadlineExceeded)
+	unfinished, err := store.UnfinishedJobs()
+	assert.NoError(t, err)
+	if assert.Len(t, unfinished, 1) {
+		assert.Equal(t, running.ID, unfinished[0].ID)
+		assert.Equal(t, StatePending, unfinished[0].State)
+	}
+}
+
+func TestManagerFinishWrappedCancellation(t *testing.T) {
+	// Backends wrap the cancellation of their queries
+	cancelled := fmt.Errorf("failed to write batch: %w", context.Canceled)
+	store := NewMemoryStore()
+	m := NewManager(fibonacci.NewGenerator(fibotest.NewMemoryCache()), store, 1)
+	job := &Job{ID: "cancelled", Kind: KindWarm, To: 10, State: StateRunning}
+	m.finish(job, cancelled)
+	saved, err := store.LoadJob(job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, StateCancelled, saved.State)
+
+	m.interrupted = true
+	job = &Job{ID: "interrupted", Kind: KindWarm, To: 10, State: StateRunning}
+	m.finish(job, cancelled)
+	saved, err = store.LoadJob(job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, StatePending, saved.State)
+}
+
+func TestComputeContextIsCancellable(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := fibonacci.NewGenerator(fibotest.NewMemoryCache()).ComputeContext(ctx, 1000000, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,163 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/programmablemike/fibo/internal/route"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader carries the ID of a request, it's taken from the caller when it's valid
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the request IDs accepted from callers
+const maxRequestIDLength = 128
+
+// maxLoggedPathLength truncates the paths in the access log, paths can carry huge numbers
+const maxLoggedPathLength = 256
+
+// routeSampling is the access log sampling of the routes
+type routeSampling struct {
+	rates  map[string]uint64  // One in N requests to the route is logged
+	counts map[string]*uint64 // Number of requests to the route so far
+}
+
+var (
+	samplingMu sync.RWMutex
+	sampling   = &routeSampling{}
+)
+
+// ParseSampling parses route=N pairs into access log sampling rates
+// Only one in N requests to the route is logged, 0 only logs its failures. Pairs are separated by
+// commas or spaces, so the rates can be given as a list or as a single string.
+func ParseSampling(pairs []string) (map[string]uint64, error) {
+	rates := map[string]uint64{}
+	for _, item := range pairs {
+		for _, pair := range strings.FieldsFunc(item, func(r rune) bool { return r == ',' || r == ' ' }) {
+			i := strings.LastIndex(pair, "=")
+			if i <= 0 {
+				return nil, fmt.Errorf("invalid access log sampling %q (expected ROUTE=N)", pair)
+			}
+			n, err := strconv.ParseUint(pair[i+1:], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid access log sampling %q (expected ROUTE=N)", pair)
+			}
+			rates[pair[:i]] = n
+		}
+	}
+	return rates, nil
+}
+
+// SetSampling replaces the access log sampling rates of the routes
+func SetSampling(rates map[string]uint64) {
+	s := &routeSampling{rates: rates, counts: make(map[string]*uint64, len(rates))}
+	for route := range rates {
+		s.counts[route] = new(uint64)
+	}
+	samplingMu.Lock()
+	defer samplingMu.Unlock()
+	sampling = s
+}
+
+// sample returns true if the request to the route should be logged
+// Server errors are always logged.
+func sample(route string, status int) bool {
+	if status >= http.StatusInternalServerError {
+		return true
+	}
+	samplingMu.RLock()
+	s := sampling
+	samplingMu.RUnlock()
+	n, ok := s.rates[route]
+	if !ok {
+		return true
+	}
+	if n == 0 {
+		return false
+	}
+	return (atomic.AddUint64(s.counts[route], 1)-1)%n == 0
+}
+
+// Middleware assigns every request to the routes of a mux.Router an ID and writes the access log
+// The ID is taken from the X-Request-ID header when the caller sent a valid one and is echoed in
+// the response. The handlers get a logger with the request ID, and the trace ID when the request
+// is traced, from FromContext.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := RequestID(r.Header.Get(RequestIDHeader))
+		w.Header().Set(RequestIDHeader, id)
+		fields := log.Fields{"request_id": id}
+		if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+			fields["trace_id"] = sc.TraceID().String()
+		}
+		logger := FromContext(r.Context()).WithFields(fields)
+		r = r.WithContext(NewContext(r.Context(), logger))
+
+		m := httpsnoop.CaptureMetrics(next, w, r)
+		tpl := route.Template(r)
+		if !sample(tpl, m.Code) {
+			return
+		}
+		path := r.URL.Path
+		if len(path) > maxLoggedPathLength {
+			path = path[:maxLoggedPathLength] + "..."
+		}
+		entry := logger.WithFields(log.Fields{
+			"method":      r.Method,
+			"route":       tpl,
+			"path":        path,
+			"status":      m.Code,
+			"bytes":       m.Written,
+			"duration_ms": float64(m.Duration.Microseconds()) / 1000,
+			"remote_addr": r.RemoteAddr,
+			"user_agent":  r.UserAgent(),
+		})
+		if m.Code >= http.StatusInternalServerError {
+			entry.Error("Request failed")
+			return
+		}
+		entry.Info("Request handled")
+	})
+}
+
+// RequestID returns the request ID sent by a caller when it's valid, or a new one
+func RequestID(sent string) string {
+	if validRequestID(sent) {
+		return sent
+	}
+	return newRequestID()
+}
+
+// validRequestID returns true if a request ID sent by a caller is safe to log and echo
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("-_.:", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random 128-bit ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
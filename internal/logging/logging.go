@@ -0,0 +1,67 @@
+// Configures the logs and carries request-scoped loggers through contexts
+package logging
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Format is how log entries are written
+type Format string
+
+const (
+	// FormatText writes key=value lines for people reading the output
+	FormatText Format = "text"
+	// FormatJSON writes an object per line for log collectors
+	FormatJSON Format = "json"
+)
+
+// ParseFormat converts a configuration string into a Format
+func ParseFormat(v string) (Format, error) {
+	switch f := Format(v); f {
+	case FormatText, FormatJSON:
+		return f, nil
+	default:
+		return "", fmt.Errorf("invalid log format %q (expected text or json)", v)
+	}
+}
+
+// Configure sets the format and the level of the standard logger
+func Configure(format Format, level string) error {
+	lvl, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case FormatJSON:
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		log.SetFormatter(&log.TextFormatter{})
+	}
+	log.SetLevel(lvl)
+	return nil
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the logger
+func NewContext(ctx context.Context, logger *log.Entry) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or Default when there's none
+func FromContext(ctx context.Context) *log.Entry {
+	if ctx != nil {
+		if logger, ok := ctx.Value(contextKey{}).(*log.Entry); ok {
+			return logger
+		}
+	}
+	return Default()
+}
+
+// Default returns a logger without fields that writes to the standard logger
+func Default() *log.Entry {
+	return log.NewEntry(log.StandardLogger())
+}
@@ -0,0 +1,119 @@
+package logging
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFormat(t *testing.T) {
+	f, err := ParseFormat("json")
+	assert.NoError(t, err)
+	assert.Equal(t, FormatJSON, f)
+	_, err = ParseFormat("logfmt")
+	assert.Error(t, err)
+}
+
+func TestParseSampling(t *testing.T) {
+	rates, err := ParseSampling([]string{"/healthz=0,/metrics=10", "/v1/fibonacci/{ordinal}=100"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]uint64{"/healthz": 0, "/metrics": 10, "/v1/fibonacci/{ordinal}": 100}, rates)
+	// Environment variables are a single string
+	rates, err = ParseSampling([]string{"/healthz=0 /metrics=10"})
+	assert.NoError(t, err)
+	assert.Len(t, rates, 2)
+	for _, bad := range []string{"/metrics", "=10", "/metrics=ten", "/metrics=-1"} {
+		_, err = ParseSampling([]string{bad})
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	assert.NotNil(t, FromContext(context.Background()))
+	logger := log.WithField("request_id", "abc")
+	assert.Equal(t, logger, FromContext(NewContext(context.Background(), logger)))
+}
+
+// serve sends a request through the middleware and returns the response and the access log entries
+func serve(req *http.Request, status int) (*httptest.ResponseRecorder, []*log.Entry, *log.Entry) {
+	logger, hook := test.NewNullLogger()
+	req = req.WithContext(NewContext(req.Context(), log.NewEntry(logger)))
+	var handlerLogger *log.Entry
+	r := mux.NewRouter()
+	r.Use(Middleware)
+	r.HandleFunc("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlerLogger = FromContext(r.Context())
+		w.WriteHeader(status)
+		w.Write([]byte("hello"))
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w, hook.AllEntries(), handlerLogger
+}
+
+func TestMiddleware(t *testing.T) {
+	req := httptest.NewRequest("GET", "/items/42", nil)
+	req.Header.Set("User-Agent", "fibo-test")
+	w, entries, handlerLogger := serve(req, http.StatusOK)
+
+	id := w.Header().Get(RequestIDHeader)
+	assert.Len(t, id, 32)
+	assert.Equal(t, id, handlerLogger.Data["request_id"])
+	if !assert.Len(t, entries, 1) {
+		return
+	}
+	e := entries[0]
+	assert.Equal(t, log.InfoLevel, e.Level)
+	assert.Equal(t, id, e.Data["request_id"])
+	assert.Equal(t, "GET", e.Data["method"])
+	assert.Equal(t, "/items/{id}", e.Data["route"])
+	assert.Equal(t, "/items/42", e.Data["path"])
+	assert.Equal(t, http.StatusOK, e.Data["status"])
+	assert.Equal(t, int64(5), e.Data["bytes"])
+	assert.Equal(t, "fibo-test", e.Data["user_agent"])
+	assert.Contains(t, e.Data, "duration_ms")
+}
+
+func TestMiddlewareRequestID(t *testing.T) {
+	req := httptest.NewRequest("GET", "/items/42", nil)
+	req.Header.Set(RequestIDHeader, "client-id.1")
+	w, entries, _ := serve(req, http.StatusOK)
+	assert.Equal(t, "client-id.1", w.Header().Get(RequestIDHeader))
+	assert.Equal(t, "client-id.1", entries[0].Data["request_id"])
+
+	// IDs that aren't safe to log are replaced
+	for _, bad := range []string{"bad id", "bad\nid", strings.Repeat("a", maxRequestIDLength+1)} {
+		req = httptest.NewRequest("GET", "/items/42", nil)
+		req.Header.Set(RequestIDHeader, bad)
+		w, _, _ = serve(req, http.StatusOK)
+		assert.Len(t, w.Header().Get(RequestIDHeader), 32)
+	}
+}
+
+func TestMiddlewareSampling(t *testing.T) {
+	SetSampling(map[string]uint64{"/items/{id}": 3})
+	defer SetSampling(nil)
+	logged := 0
+	for i := 0; i < 9; i++ {
+		_, entries, _ := serve(httptest.NewRequest("GET", "/items/42", nil), http.StatusOK)
+		logged += len(entries)
+	}
+	assert.Equal(t, 3, logged)
+
+	// Server errors are always logged, even for routes that are never logged
+	SetSampling(map[string]uint64{"/items/{id}": 0})
+	_, entries, _ := serve(httptest.NewRequest("GET", "/items/42", nil), http.StatusOK)
+	assert.Empty(t, entries)
+	_, entries, _ = serve(httptest.NewRequest("GET", "/items/42", nil), http.StatusBadGateway)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, log.ErrorLevel, entries[0].Level)
+		assert.Equal(t, "Request failed", entries[0].Message)
+	}
+}
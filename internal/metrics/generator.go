@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	cacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_hits_total",
+		Help:      "Number of values served from the memoizer cache.",
+	})
+	cacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_misses_total",
+		Help:      "Number of values that weren't in the memoizer cache.",
+	})
+	cacheWrites = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_writes_total",
+		Help:      "Number of values persisted to the memoizer cache.",
+	})
+	computeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "compute_duration_seconds",
+		Help:      "Duration of the Fibonacci computations by the number of decimal digits of the ordinal.",
+		// From 100µs to about 7 minutes
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 12),
+	}, []string{"ordinal_digits"})
+	computationsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "computations_in_flight",
+		Help:      "Number of Fibonacci computations that are running.",
+	})
+)
+
+// GeneratorObserver exports the cache traffic and computations of a fibonacci.Generator
+type GeneratorObserver struct{}
+
+func (GeneratorObserver) CacheHits(n int) {
+	cacheHits.Add(float64(n))
+}
+
+func (GeneratorObserver) CacheMisses(n int) {
+	cacheMisses.Add(float64(n))
+}
+
+func (GeneratorObserver) CacheWrites(n int) {
+	cacheWrites.Add(float64(n))
+}
+
+// ComputeStarted buckets the computation by the order of magnitude of the ordinal, the computing
+// time grows much faster than the ordinal so finer buckets wouldn't tell more
+func (GeneratorObserver) ComputeStarted(ordinal uint64) func() {
+	computationsInFlight.Inc()
+	start := time.Now()
+	return func() {
+		computationsInFlight.Dec()
+		digits := strconv.Itoa(len(strconv.FormatUint(ordinal, 10)))
+		computeDuration.WithLabelValues(digits).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,44 @@
+// Exports the Prometheus metrics of the server
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/programmablemike/fibo/internal/route"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// namespace prefixes the names of all the metrics
+const namespace = "fibo"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of the HTTP requests by route, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+)
+
+// Middleware counts the requests to the routes of a mux.Router and measures their latency
+// Routes are labelled with their path template so that the ordinals in the paths don't create a
+// series per value. Requests that match no route never reach the middleware.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// httpsnoop keeps the interfaces of w, like the access log, so event streams and WebSocket
+		// upgrades still work
+		m := httpsnoop.CaptureMetrics(next, w, r)
+		tpl := route.Template(r)
+		code := strconv.Itoa(m.Code)
+		httpRequests.WithLabelValues(tpl, r.Method, code).Inc()
+		httpDuration.WithLabelValues(tpl, r.Method, code).Observe(m.Duration.Seconds())
+	})
+}
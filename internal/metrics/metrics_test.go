@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/programmablemike/fibo/internal/fibonacci"
+	"github.com/programmablemike/fibo/internal/fibonacci/fibotest"
+	"github.com/programmablemike/fibo/internal/route"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	r := mux.NewRouter()
+	r.Use(Middleware)
+	r.HandleFunc("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["id"] == "missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	}).Methods("GET")
+	ok := httpRequests.WithLabelValues("/items/{id}", "GET", "200")
+	notFound := httpRequests.WithLabelValues("/items/{id}", "GET", "404")
+	okBefore, notFoundBefore := testutil.ToFloat64(ok), testutil.ToFloat64(notFound)
+
+	for _, path := range []string{"/items/1", "/items/2", "/items/missing"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	assert.Equal(t, okBefore+2, testutil.ToFloat64(ok))
+	assert.Equal(t, notFoundBefore+1, testutil.ToFloat64(notFound))
+	// One latency series per route, method and status code
+	assert.GreaterOrEqual(t, testutil.CollectAndCount(httpDuration), 2)
+}
+
+func TestMiddlewareFlushes(t *testing.T) {
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := w.(http.Flusher)
+		assert.True(t, ok)
+		// The recorder can't be hijacked so neither can the wrapper
+		_, ok = w.(http.Hijacker)
+		assert.False(t, ok)
+		w.Write([]byte("data"))
+		w.(http.Flusher).Flush()
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.True(t, w.Flushed)
+	assert.Equal(t, 1.0, testutil.ToFloat64(httpRequests.WithLabelValues(route.Unknown, "GET", "200")))
+}
+
+func TestGeneratorObserver(t *testing.T) {
+	hits, misses, writes := testutil.ToFloat64(cacheHits), testutil.ToFloat64(cacheMisses), testutil.ToFloat64(cacheWrites)
+	gen := fibonacci.NewGenerator(fibotest.NewMemoryCache())
+	gen.SetObserver(GeneratorObserver{})
+	for i := 0; i < 2; i++ {
+		_, _, err := gen.Lookup(context.Background(), 12345)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, hits+1, testutil.ToFloat64(cacheHits))
+	assert.Equal(t, misses+1, testutil.ToFloat64(cacheMisses))
+	assert.Equal(t, writes+1, testutil.ToFloat64(cacheWrites))
+	assert.Equal(t, float64(0), testutil.ToFloat64(computationsInFlight))
+	assert.GreaterOrEqual(t, testutil.CollectAndCount(computeDuration), 1)
+
+	done := GeneratorObserver{}.ComputeStarted(7)
+	assert.Equal(t, float64(1), testutil.ToFloat64(computationsInFlight))
+	done()
+	assert.Equal(t, float64(0), testutil.ToFloat64(computationsInFlight))
+
+	expected := `
+# HELP fibo_computations_in_flight Number of Fibonacci computations that are running.
+# TYPE fibo_computations_in_flight gauge
+fibo_computations_in_flight 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(computationsInFlight, strings.NewReader(expected)))
+}
+
+func TestDBStatsCollector(t *testing.T) {
+	c := NewDBStatsCollector("postgres", func() sql.DBStats {
+		return sql.DBStats{MaxOpenConnections: 10, OpenConnections: 3, InUse: 1, Idle: 2, WaitCount: 4, WaitDuration: 1500 * time.Millisecond}
+	})
+	expected := `
+# HELP fibo_db_open_connections Number of established connections, both in use and idle.
+# TYPE fibo_db_open_connections gauge
+fibo_db_open_connections{db="postgres"} 3
+# HELP fibo_db_wait_duration_seconds_total Time blocked waiting for new connections.
+# TYPE fibo_db_wait_duration_seconds_total counter
+fibo_db_wait_duration_seconds_total{db="postgres"} 1.5
+`
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected), "fibo_db_open_connections", "fibo_db_wait_duration_seconds_total"))
+	assert.Equal(t, 9, testutil.CollectAndCount(c))
+}
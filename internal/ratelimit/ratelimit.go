@@ -0,0 +1,220 @@
+// Throttles the clients of the HTTP and gRPC servers with token buckets, requests cost tokens by the
+// number of digits of the values they ask for
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// digitsPerOrdinal is log10 of the golden ratio, F(n) has about 0.209·n decimal digits
+const digitsPerOrdinal = 0.20898764024997873
+
+// sweepInterval is how often the buckets that have refilled are forgotten
+const sweepInterval = time.Minute
+
+// RateLimit is a token bucket refilled with Rate tokens per second that holds up to Burst tokens
+// A zero Rate doesn't limit the requests.
+type RateLimit struct {
+	Rate  float64
+	Burst float64
+}
+
+// ParseRouteRateLimits parses ROUTE=RATE[:BURST] pairs into the rate limits of the routes
+// Routes are HTTP route templates or gRPC full method names. The burst defaults to the rate, or to
+// 1 token for rates below 1 per second.
+func ParseRouteRateLimits(pairs []string) (map[string]RateLimit, error) {
+	limits := map[string]RateLimit{}
+	for _, item := range pairs {
+		for _, pair := range strings.FieldsFunc(item, func(r rune) bool { return r == ',' || r == ' ' }) {
+			i := strings.LastIndex(pair, "=")
+			if i <= 0 {
+				return nil, fmt.Errorf("invalid route rate limit %q (expected ROUTE=RATE[:BURST])", pair)
+			}
+			rate, burst := pair[i+1:], ""
+			if j := strings.Index(rate, ":"); j >= 0 {
+				rate, burst = rate[:j], rate[j+1:]
+			}
+			limit, err := parseRateLimit(rate, burst)
+			if err != nil {
+				return nil, fmt.Errorf("invalid route rate limit %q: %w", pair, err)
+			}
+			limits[pair[:i]] = limit
+		}
+	}
+	return limits, nil
+}
+
+// parseRateLimit parses a rate and an optional burst
+func parseRateLimit(rate string, burst string) (RateLimit, error) {
+	r, err := strconv.ParseFloat(rate, 64)
+	if err != nil || r < 0 || math.IsInf(r, 0) {
+		return RateLimit{}, fmt.Errorf("the rate must be a positive number of tokens per second")
+	}
+	limit := RateLimit{Rate: r, Burst: math.Max(r, 1)}
+	if burst != "" {
+		b, err := strconv.ParseFloat(burst, 64)
+		if err != nil || b < 1 || math.IsInf(b, 0) {
+			return RateLimit{}, fmt.Errorf("the burst must be at least 1 token")
+		}
+		limit.Burst = b
+	}
+	return limit, nil
+}
+
+// bucket is the token bucket of a client for a route
+type bucket struct {
+	tokens float64
+	last   time.Time // When tokens was last refilled
+	full   time.Time // When the bucket is full again
+}
+
+// Limiter throttles the requests of every client with token buckets
+// The routes with their own limit have their own buckets, the other routes share a bucket per
+// client. A request takes one token plus one per digitsPerToken digits of the values it asks for.
+// It's let through while the bucket holds a token and may leave the bucket in debt, so a client
+// asking for huge values waits for as long as its values cost.
+// A nil Limiter lets every request through.
+type Limiter struct {
+	limit          RateLimit            // Limit of the routes without their own
+	routes         map[string]RateLimit // Limits of the routes
+	digitsPerToken float64
+	now            func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// FromConfig creates a Limiter from the CLI flags/environment/.fiborc
+func FromConfig() (*Limiter, error) {
+	routes, err := ParseRouteRateLimits(viper.GetStringSlice("rate_limit_routes"))
+	if err != nil {
+		return nil, err
+	}
+	return New(
+		RateLimit{Rate: viper.GetFloat64("rate_limit"), Burst: viper.GetFloat64("rate_limit_burst")},
+		routes,
+		viper.GetFloat64("rate_limit_digits_per_token"),
+	), nil
+}
+
+// New creates a Limiter, a digitsPerToken of 0 makes every request cost one token
+func New(limit RateLimit, routes map[string]RateLimit, digitsPerToken float64) *Limiter {
+	if limit.Burst < 1 {
+		limit.Burst = math.Max(limit.Rate, 1)
+	}
+	if digitsPerToken <= 0 {
+		digitsPerToken = math.Inf(1)
+	}
+	return &Limiter{
+		limit:          limit,
+		routes:         routes,
+		digitsPerToken: digitsPerToken,
+		now:            time.Now,
+		buckets:        map[string]*bucket{},
+	}
+}
+
+// Enabled returns true if any route is limited
+func (l *Limiter) Enabled() bool {
+	if l == nil {
+		return false
+	}
+	if l.limit.Rate > 0 {
+		return true
+	}
+	for _, limit := range l.routes {
+		if limit.Rate > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Take removes cost tokens from the bucket of the client for the route, or returns how long it
+// takes to refill a token
+func (l *Limiter) Take(client string, route string, cost float64) (time.Duration, bool) {
+	if l == nil {
+		return 0, true
+	}
+	limit, ok := l.routes[route]
+	if !ok {
+		limit, route = l.limit, ""
+	}
+	if limit.Rate <= 0 || cost <= 0 {
+		return 0, true
+	}
+	return l.take(client+" "+route, limit, cost)
+}
+
+// take removes cost tokens from a bucket when it holds a token, or returns how long it takes to
+// refill one
+func (l *Limiter) take(key string, limit RateLimit, cost float64) (time.Duration, bool) {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: limit.Burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(limit.Burst, b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+	if need := math.Min(cost, 1); b.tokens < need {
+		return seconds((need - b.tokens) / limit.Rate), false
+	}
+	b.tokens -= cost
+	b.full = now.Add(seconds((limit.Burst - b.tokens) / limit.Rate))
+	return 0, true
+}
+
+// sweep forgets the buckets that have refilled, a new bucket starts full anyway
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if !now.Before(b.full) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// seconds converts seconds into a duration, the debt of huge requests would overflow it
+func seconds(s float64) time.Duration {
+	if s >= float64(math.MaxInt64)/float64(time.Second) {
+		return math.MaxInt64
+	}
+	return time.Duration(s * float64(time.Second))
+}
+
+// Tokens converts decimal digits into tokens
+func (l *Limiter) Tokens(digits float64) float64 {
+	if l == nil {
+		return 0
+	}
+	return digits / l.digitsPerToken
+}
+
+// EstimateDigits returns the approximate number of decimal digits of F(ordinal)
+func EstimateDigits(ordinal uint64) float64 {
+	return float64(ordinal) * digitsPerOrdinal
+}
+
+// RangeDigits returns the approximate number of decimal digits of F(from) to F(to)
+func RangeDigits(from uint64, to uint64) float64 {
+	if to < from {
+		return 0
+	}
+	// The digits grow linearly so the sum is the count times the mean
+	return (float64(to-from) + 1) * (EstimateDigits(from) + EstimateDigits(to)) / 2
+}
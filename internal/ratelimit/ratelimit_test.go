@@ -0,0 +1,90 @@
+package ratelimit
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRouteRateLimits(t *testing.T) {
+	limits, err := ParseRouteRateLimits([]string{"/v1/fibonacci/{ordinal}=5:20,/healthz=0", "/metrics=0.5", "/fibo.v1.Fibo/Calculate=2"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]RateLimit{
+		"/v1/fibonacci/{ordinal}": {Rate: 5, Burst: 20},
+		"/healthz":                {Rate: 0, Burst: 1},
+		"/metrics":                {Rate: 0.5, Burst: 1},
+		"/fibo.v1.Fibo/Calculate": {Rate: 2, Burst: 2},
+	}, limits)
+	for _, bad := range []string{"/metrics", "=5", "/metrics=fast", "/metrics=-1", "/metrics=5:0", "/metrics=5:x"} {
+		_, err = ParseRouteRateLimits([]string{bad})
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestLimiterTake(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := New(RateLimit{Rate: 2, Burst: 4}, map[string]RateLimit{"/healthz": {}, "/metrics": {Rate: 1, Burst: 1}}, 0)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 4; i++ {
+		_, ok := l.Take("a", "/v1/fibonacci/{ordinal}", 1)
+		assert.True(t, ok)
+	}
+	// The routes without their own limit share the bucket
+	wait, ok := l.Take("a", "/v1/sequence/{from}/{to}", 1)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+	// Other clients and the routes with their own limit have their own bucket, a 0 rate isn't limited
+	_, ok = l.Take("b", "/v1/fibonacci/{ordinal}", 1)
+	assert.True(t, ok)
+	_, ok = l.Take("a", "/metrics", 1)
+	assert.True(t, ok)
+	_, ok = l.Take("a", "/healthz", 1)
+	assert.True(t, ok)
+
+	now = now.Add(time.Second)
+	_, ok = l.Take("a", "", 2)
+	assert.True(t, ok)
+	// Expensive requests leave the bucket in debt
+	now = now.Add(time.Hour)
+	_, ok = l.Take("a", "", 100)
+	assert.True(t, ok)
+	wait, ok = l.Take("a", "", 1)
+	assert.False(t, ok)
+	assert.Equal(t, 48500*time.Millisecond, wait)
+
+	// Refilled buckets are forgotten
+	now = now.Add(time.Hour)
+	l.Take("c", "", 1)
+	assert.Len(t, l.buckets, 1)
+}
+
+func TestLimiterHugeDebt(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := New(RateLimit{Rate: 1, Burst: 1}, nil, 1)
+	l.now = func() time.Time { return now }
+
+	// The debt of an unbounded range outlasts any duration and isn't forgiven by the sweep
+	_, ok := l.Take("a", "", l.Tokens(RangeDigits(0, math.MaxUint64)))
+	assert.True(t, ok)
+	now = now.Add(24 * time.Hour)
+	wait, ok := l.Take("a", "", 1)
+	assert.False(t, ok)
+	assert.Equal(t, time.Duration(math.MaxInt64), wait)
+}
+
+func TestNilLimiter(t *testing.T) {
+	var l *Limiter
+	assert.False(t, l.Enabled())
+	assert.Zero(t, l.Tokens(1000))
+	_, ok := l.Take("a", "", 1)
+	assert.True(t, ok)
+}
+
+func TestRangeDigits(t *testing.T) {
+	assert.InDelta(t, 10449382, RangeDigits(50000000, 50000000), 1)
+	assert.InDelta(t, 627, RangeDigits(1000, 1002), 1)
+	assert.Zero(t, RangeDigits(2, 1))
+}
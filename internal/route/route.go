@@ -0,0 +1,23 @@
+// Names the routes of the requests served by a mux.Router for the middlewares
+package route
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Unknown names the requests that match no route
+const Unknown = "unknown"
+
+// Template returns the path template of the route matched by the request
+// Labelling by template keeps the ordinals in the paths from creating a metric series, a span
+// name or a bucket per value.
+func Template(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if tpl, err := current.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return Unknown
+}
@@ -0,0 +1,177 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/programmablemike/fibo/internal/auth"
+	"github.com/programmablemike/fibo/internal/jobs"
+	"github.com/programmablemike/fibo/internal/logging"
+	"github.com/programmablemike/fibo/internal/route"
+)
+
+// publicRoutes don't need credentials, probes and scrapers don't have any
+var publicRoutes = map[string]bool{
+	"/":             true,
+	"/healthz":      true,
+	"/readyz":       true,
+	"/metrics":      true,
+	"/openapi.json": true,
+	"/docs":         true,
+}
+
+// routeScopes are the scopes of the routes that need more than auth.ScopeRead, by method and template
+var routeScopes = map[string]auth.Scope{
+	"DELETE /fibo/cache":              auth.ScopeAdmin,
+	"GET /fibo/cache/export":          auth.ScopeAdmin,
+	"POST /fibo/cache/import":         auth.ScopeAdmin,
+	"POST /fibo/cache/verify":         auth.ScopeAdmin,
+	"POST /fibo/cache/warm":           auth.ScopeAdmin,
+	"POST /fibo/jobs":                 auth.ScopeComputeLarge,
+	"DELETE /fibo/jobs/{id}":          auth.ScopeComputeLarge,
+	"DELETE /v1/cache":                auth.ScopeAdmin,
+	"GET /v1/cache/export":            auth.ScopeAdmin,
+	"POST /v1/cache/import":           auth.ScopeAdmin,
+	"POST /v1/cache/verify":           auth.ScopeAdmin,
+	"POST /v1/cache/warm":             auth.ScopeAdmin,
+	"GET /v1/cache/entries":           auth.ScopeAdmin,
+	"GET /v1/cache/entries/{ordinal}": auth.ScopeAdmin,
+	"GET /v1/cache/stats":             auth.ScopeAdmin,
+	"POST /v1/jobs":                   auth.ScopeComputeLarge,
+	"DELETE /v1/jobs/{id}":            auth.ScopeComputeLarge,
+}
+
+// authorizer checks that the clients are allowed the routes they request
+type authorizer struct {
+	auth    *auth.Authenticator
+	streams streamLimits
+}
+
+// Middleware authenticates the requests and rejects the ones whose client lacks the scope of the
+// route, or of the ordinals in the path or the query
+// The handlers get the principal from auth.FromContext and a logger with its name.
+func (a authorizer) Middleware(next http.Handler) http.Handler {
+	if !a.auth.Enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tpl := route.Template(r)
+		if publicRoutes[tpl] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		writeError := writeGenericError
+		if strings.HasPrefix(r.URL.Path, "/v1/") {
+			writeError = writeProblem
+		}
+		p, err := a.auth.Authenticate(auth.RequestToken(r.Header))
+		if err != nil {
+			unauthorized(w, r, err.Error(), writeError)
+			return
+		}
+		scope, ok := routeScopes[r.Method+" "+tpl]
+		if !ok {
+			scope = a.requestScope(r, tpl)
+		}
+		if !a.allow(w, r, p, scope, writeError) {
+			return
+		}
+		ctx := auth.NewContext(r.Context(), p)
+		ctx = logging.NewContext(ctx, logging.FromContext(ctx).WithField("client", p.Name))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestScope returns the scope of the ordinals a request computes
+// Sequence streams are checked against the last ordinal they can reach, which is bounded even
+// without a to ordinal. Requests whose ordinals can't be parsed are rejected by the handlers.
+func (a authorizer) requestScope(r *http.Request, route string) auth.Scope {
+	if route == streamRoute {
+		if _, to, err := a.streams.streamRange(r); err == nil {
+			return a.auth.OrdinalScope(to)
+		}
+		return auth.ScopeRead
+	}
+	if _, to, ok := requestRange(r); ok {
+		return a.auth.OrdinalScope(to)
+	}
+	return auth.ScopeRead
+}
+
+// allowBatch checks the scope of the largest ordinal of a batch
+func (a authorizer) allowBatch(w http.ResponseWriter, r *http.Request, ordinals []uint64, writeError errorWriter) bool {
+	p := auth.FromContext(r.Context())
+	if p == nil {
+		return true
+	}
+	var max uint64
+	for _, ord := range ordinals {
+		if ord > max {
+			max = ord
+		}
+	}
+	return a.allow(w, r, p, a.auth.OrdinalScope(max), writeError)
+}
+
+// allowJob checks the scope of a submitted job
+// Warm-ups need the admin scope like the warm-up routes, the other kinds need the scope of their
+// largest ordinal.
+func (a authorizer) allowJob(w http.ResponseWriter, r *http.Request, job *jobs.Job, writeError errorWriter) bool {
+	p := auth.FromContext(r.Context())
+	if p == nil {
+		return true
+	}
+	if job.Kind == jobs.KindWarm {
+		return a.allow(w, r, p, auth.ScopeAdmin, writeError)
+	}
+	max := job.Ordinal
+	if job.To > max {
+		max = job.To
+	}
+	return a.allow(w, r, p, a.auth.OrdinalScope(max), writeError)
+}
+
+// jobOwner is the owner of the jobs submitted by a request, it's empty when authentication is disabled
+func jobOwner(r *http.Request) string {
+	if p := auth.FromContext(r.Context()); p != nil {
+		return p.Name
+	}
+	return ""
+}
+
+// ownsJob returns true if the client of a request submitted the job or is an admin
+// Jobs without an owner were submitted while authentication was disabled and are visible to everyone.
+func ownsJob(r *http.Request, job *jobs.Job) bool {
+	p := auth.FromContext(r.Context())
+	return p == nil || job.Owner == "" || job.Owner == p.Name || p.Allows(auth.ScopeAdmin)
+}
+
+// loadJob returns a job of the client of a request, the jobs of other clients are reported as unknown
+func loadJob(r *http.Request, jobManager *jobs.Manager, id string) (*jobs.Job, error) {
+	job, err := jobManager.Get(id)
+	if err == nil && !ownsJob(r, job) {
+		return nil, jobs.ErrNotFound
+	}
+	return job, err
+}
+
+// allow responds with an error unless the principal has the scope
+// Anonymous clients are asked for credentials, the others are told they lack the scope.
+func (a authorizer) allow(w http.ResponseWriter, r *http.Request, p *auth.Principal, scope auth.Scope, writeError errorWriter) bool {
+	if p.Allows(scope) {
+		return true
+	}
+	if p.Anonymous() {
+		unauthorized(w, r, fmt.Sprintf("an API key with the %s scope is required", scope), writeError)
+		return false
+	}
+	writeError(w, r, http.StatusForbidden, CodeForbidden, fmt.Sprintf("the API key %s lacks the %s scope", p.Name, scope))
+	return false
+}
+
+// unauthorized responds with 401 Unauthorized and the authentication scheme
+func unauthorized(w http.ResponseWriter, r *http.Request, message string, writeError errorWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="fibo"`)
+	writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, message)
+}
@@ -0,0 +1,136 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/programmablemike/fibo/internal/auth"
+	"github.com/programmablemike/fibo/internal/fibonacci"
+	"github.com/programmablemike/fibo/internal/fibonacci/fibotest"
+	"github.com/programmablemike/fibo/internal/jobs"
+	"github.com/stretchr/testify/assert"
+)
+
+// newAuthTestRouter creates a router whose clients need the keys "reader", "large", "other" or "admin"
+func newAuthTestRouter(t *testing.T, anonymous ...auth.Scope) http.Handler {
+	a, err := auth.New(auth.Config{
+		Keys: []auth.Key{
+			{Name: "ci", Hash: auth.HashToken("reader"), Scopes: []auth.Scope{auth.ScopeRead}},
+			{Name: "batch", Hash: auth.HashToken("large"), Scopes: []auth.Scope{auth.ScopeComputeLarge}},
+			{Name: "nightly", Hash: auth.HashToken("other"), Scopes: []auth.Scope{auth.ScopeComputeLarge}},
+			{Name: "ops", Hash: auth.HashToken("admin"), Scopes: []auth.Scope{auth.ScopeAdmin}},
+		},
+		Anonymous:    anonymous,
+		LargeOrdinal: 1000,
+	})
+	assert.NoError(t, err)
+	gen := fibonacci.NewGenerator(fibotest.NewMemoryCache())
+	return NewRouter(gen, jobs.NewManager(gen, jobs.NewMemoryStore(), 1), a, nil, nil)
+}
+
+// serveWithToken sends a request with a bearer token, an empty token sends none
+func serveWithToken(h http.Handler, method string, path string, token string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuthorize(t *testing.T) {
+	r := newAuthTestRouter(t)
+
+	tests := []struct {
+		method string
+		path   string
+		token  string
+		body   string
+		status int
+	}{
+		{"GET", "/healthz", "", "", http.StatusOK},
+		{"GET", "/v1/fibonacci/10", "", "", http.StatusUnauthorized},
+		{"GET", "/v1/fibonacci/10", "wrong", "", http.StatusUnauthorized},
+		{"GET", "/v1/fibonacci/10", "reader", "", http.StatusOK},
+		{"GET", "/v1/fibonacci/1001", "reader", "", http.StatusForbidden},
+		{"GET", "/v1/fibonacci/1001", "large", "", http.StatusOK},
+		{"GET", "/v1/sequence/990/1001", "reader", "", http.StatusForbidden},
+		{"POST", "/v1/fibonacci", "reader", `{"ordinals": [10, 1001]}`, http.StatusForbidden},
+		{"POST", "/v1/fibonacci", "large", `{"ordinals": [10, 1001]}`, http.StatusOK},
+		{"POST", "/v1/jobs", "reader", `{"kind": "calculate", "ordinal": 10}`, http.StatusForbidden},
+		// Warm-up jobs need the scope of the warm-up routes
+		{"POST", "/v1/jobs", "large", `{"kind": "warm", "to": 10}`, http.StatusForbidden},
+		{"POST", "/fibo/jobs", "large", `{"kind": "warm", "to": 10}`, http.StatusForbidden},
+		{"POST", "/v1/jobs", "admin", `{"kind": "warm", "to": 10}`, http.StatusAccepted},
+		{"DELETE", "/v1/cache", "large", "", http.StatusForbidden},
+		{"DELETE", "/fibo/cache", "reader", "", http.StatusForbidden},
+		{"DELETE", "/v1/cache", "admin", "", http.StatusNoContent},
+		{"GET", "/v1/cache/entries", "large", "", http.StatusForbidden},
+		{"GET", "/v1/cache/entries/10", "reader", "", http.StatusForbidden},
+		{"GET", "/v1/cache/stats", "reader", "", http.StatusForbidden},
+		// The test cache can't be inspected, but the request gets through
+		{"GET", "/v1/cache/entries", "admin", "", http.StatusNotImplemented},
+		{"GET", "/v1/cache/stats", "admin", "", http.StatusNotImplemented},
+		{"GET", "/v1/events/sequence?to=5", "reader", "", http.StatusOK},
+		{"GET", "/v1/events/sequence?from=990&to=1001", "reader", "", http.StatusForbidden},
+		// Streams without a to ordinal reach the maximum stream length
+		{"GET", "/v1/events/sequence", "reader", "", http.StatusForbidden},
+		{"GET", "/v1/events/sequence?from=10", "reader", "", http.StatusForbidden},
+		{"DELETE", "/fibo/cache", "admin", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		w := serveWithToken(r, test.method, test.path, test.token, test.body)
+		assert.Equal(t, test.status, w.Code, "%s %s with %q", test.method, test.path, test.token)
+		switch w.Code {
+		case http.StatusUnauthorized:
+			assert.Equal(t, `Bearer realm="fibo"`, w.Header().Get("WWW-Authenticate"))
+			assert.Contains(t, w.Body.String(), string(CodeUnauthorized))
+		case http.StatusForbidden:
+			assert.Contains(t, w.Body.String(), string(CodeForbidden))
+		}
+	}
+
+	// The key can be sent in the X-API-Key header too
+	req := httptest.NewRequest("DELETE", "/v1/cache", nil)
+	req.Header.Set("X-API-Key", "admin")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestAuthorizeAnonymous(t *testing.T) {
+	r := newAuthTestRouter(t, auth.ScopeRead)
+
+	assert.Equal(t, http.StatusOK, serveWithToken(r, "GET", "/v1/fibonacci/10", "", "").Code)
+	// Anonymous clients are asked for a key when they lack the scope
+	assert.Equal(t, http.StatusUnauthorized, serveWithToken(r, "GET", "/v1/fibonacci/1001", "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, serveWithToken(r, "DELETE", "/v1/cache", "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, serveWithToken(r, "GET", "/v1/cache/entries", "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, serveWithToken(r, "GET", "/v1/events/sequence", "", "").Code)
+}
+
+func TestAuthorizeJobOwner(t *testing.T) {
+	r := newAuthTestRouter(t)
+
+	w := serveWithToken(r, "POST", "/v1/jobs", "large", `{"kind": "calculate", "ordinal": 10, "owner": "nightly"}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	job := new(jobs.Job)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), job))
+	assert.Equal(t, "batch", job.Owner)
+
+	// The jobs of other clients are unknown to them, admins see every job
+	for _, path := range []string{"/v1/jobs/" + job.ID, "/v1/jobs/" + job.ID + "/result", "/fibo/jobs/" + job.ID, "/fibo/jobs/" + job.ID + "/result"} {
+		assert.Equal(t, http.StatusNotFound, serveWithToken(r, "GET", path, "other", "").Code, path)
+	}
+	assert.Equal(t, http.StatusNotFound, serveWithToken(r, "DELETE", "/v1/jobs/"+job.ID, "other", "").Code)
+	assert.Equal(t, http.StatusNotFound, serveWithToken(r, "DELETE", "/fibo/jobs/"+job.ID, "other", "").Code)
+	assert.Equal(t, http.StatusOK, serveWithToken(r, "GET", "/v1/jobs/"+job.ID, "large", "").Code)
+	assert.Equal(t, http.StatusOK, serveWithToken(r, "GET", "/v1/jobs/"+job.ID, "admin", "").Code)
+}
@@ -0,0 +1,72 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/programmablemike/fibo/internal/codec"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveBatch posts a batch calculation and returns the recorded response
+func serveBatch(h http.Handler, path string, body string, accept string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestV1Batch(t *testing.T) {
+	r := newTestRouter()
+	serve(t, r, "GET", "/v1/fibonacci/10")
+
+	w := serveBatch(r, "/v1/fibonacci", `{"ordinals": [100, 10, 3], "ranges": [{"from": 2, "to": 4}]}`, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var res Batch
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	var ordinals []uint64
+	for _, v := range res.Values {
+		ordinals = append(ordinals, v.Ordinal)
+	}
+	assert.Equal(t, []uint64{2, 3, 4, 10, 100}, ordinals)
+	assert.Equal(t, float64(3), res.Values[2].Value)
+	assert.Equal(t, true, res.Values[3].Cached)
+	assert.Equal(t, "354224848179261915075", res.Values[4].Value)
+	assert.Equal(t, false, res.Values[4].Cached)
+
+	for body, code := range map[string]ErrorCode{
+		`{}`:                                 CodeInvalidRequest,
+		`{"ordinals": "x"}`:                  CodeInvalidRequest,
+		`{"ranges": [{"from": 5, "to": 1}]}`: CodeInvalidOrdinal,
+		`{"ranges": [{"from": 0, "to": 100000000}]}`: CodeInvalidRequest,
+	} {
+		w := serveBatch(r, "/v1/fibonacci", body, "")
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		assert.Contains(t, w.Body.String(), `"code":"`+string(code)+`"`, body)
+	}
+}
+
+func TestLegacyBatch(t *testing.T) {
+	r := newTestRouter()
+	w := serveBatch(r, "/fibo/calculate?format=hex", `{"ordinals": [10, 10, 20]}`, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var res BatchResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, StatusOK, res.Status)
+	assert.Equal(t, "2", res.GenericResponse.Value)
+	assert.Equal(t, []BatchValue{{Ordinal: 10, Value: "37"}, {Ordinal: 20, Value: "1a6d"}}, res.Values)
+
+	// Decimal values are native bignums in CBOR
+	w = serveBatch(r, "/fibo/calculate", `{"ordinals": [100]}`, codec.CBOR.ContentType())
+	assert.Equal(t, http.StatusOK, w.Code)
+	res = BatchResponse{}
+	assert.NoError(t, codec.Unmarshal(codec.CBOR, w.Body.Bytes(), &res))
+	assert.Equal(t, "354224848179261915075", res.Values[0].Value)
+}
@@ -0,0 +1,94 @@
+package router
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/programmablemike/fibo/internal/cache"
+	"github.com/programmablemike/fibo/internal/fibonacci"
+	"github.com/programmablemike/fibo/internal/fibonacci/fibotest"
+	"github.com/programmablemike/fibo/internal/jobs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestV1CacheInspection(t *testing.T) {
+	fc, err := cache.NewFileCache(t.TempDir(), cache.FileCacheOptions{SyncPolicy: cache.SyncNever})
+	assert.NoError(t, err)
+	defer fc.Close()
+	gen := fibonacci.NewGenerator(fc)
+	r := NewRouter(gen, jobs.NewManager(gen, jobs.NewMemoryStore(), 1), nil, nil, nil)
+
+	status, body := serve(t, r, "GET", "/v1/cache/stats")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, map[string]interface{}{"entries": float64(0), "tombstoned": float64(0), "bytes": float64(0)}, body)
+
+	for _, ordinal := range []uint64{3, 5, 7, 100} {
+		assert.NoError(t, fc.Write(ordinal, fibonacci.FastDoubling(ordinal)))
+	}
+
+	status, body = serve(t, r, "GET", "/v1/cache/entries?from=4&limit=2")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"ordinal": float64(5), "size": float64(1)},
+		map[string]interface{}{"ordinal": float64(7), "size": float64(1)},
+	}, body["entries"])
+	assert.Equal(t, float64(100), body["next"])
+	_, body = serve(t, r, "GET", "/v1/cache/entries?from=100")
+	assert.Len(t, body["entries"], 1)
+	assert.NotContains(t, body, "next")
+
+	status, body = serve(t, r, "GET", "/v1/cache/entries/100?format=hex")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, map[string]interface{}{"ordinal": float64(100), "size": float64(9), "value": "1333db76a7c594bfc3", "format": "hex"}, body)
+	status, body = serve(t, r, "GET", "/v1/cache/entries/4")
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, "not_found", body["code"])
+
+	_, body = serve(t, r, "GET", "/v1/cache/stats")
+	assert.Equal(t, float64(4), body["entries"])
+	assert.Equal(t, float64(100), body["max_ordinal"])
+
+	status, body = serve(t, r, "GET", "/v1/cache/entries?limit=0")
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "invalid_request", body["code"])
+}
+
+func TestV1CacheInspectionNotImplemented(t *testing.T) {
+	r := newTestRouter()
+	for _, path := range []string{"/v1/cache/entries", "/v1/cache/entries/1", "/v1/cache/stats"} {
+		status, body := serve(t, r, "GET", path)
+		assert.Equal(t, http.StatusNotImplemented, status, path)
+		assert.Equal(t, "not_implemented", body["code"], path)
+	}
+}
+
+// ReadOnlyMemoryCache is a MemoryCache that fails to store values
+type ReadOnlyMemoryCache struct {
+	*fibotest.MemoryCache
+}
+
+func (c *ReadOnlyMemoryCache) Write(ordinal uint64, value *fibonacci.Number) error {
+	return errors.New("the cache is read-only")
+}
+
+func TestImportCacheErrors(t *testing.T) {
+	buf := &bytes.Buffer{}
+	ew, err := cache.NewEntryWriter(buf, cache.FormatJSONL)
+	assert.NoError(t, err)
+	assert.NoError(t, ew.WriteEntry(10, fibonacci.NewNumber(55)))
+	assert.NoError(t, ew.Flush())
+
+	gen := fibonacci.NewGenerator(&ReadOnlyMemoryCache{MemoryCache: fibotest.NewMemoryCache()})
+	r := NewRouter(gen, jobs.NewManager(gen, jobs.NewMemoryStore(), 1), nil, nil, nil)
+	for _, path := range []string{"/fibo/cache/import", "/v1/cache/import"} {
+		w := serveWithToken(r, "POST", path, "", buf.String())
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, path)
+		assert.Contains(t, w.Body.String(), `"code":"backend_unavailable"`, path)
+
+		w = serveWithToken(r, "POST", path, "", `{"ordinal":10,"value":"55","checksum":"00000000"}`+"\n")
+		assert.Equal(t, http.StatusBadRequest, w.Code, path)
+		assert.Contains(t, w.Body.String(), `"code":"invalid_request"`, path)
+	}
+}
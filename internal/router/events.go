@@ -0,0 +1,455 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/programmablemike/fibo/internal/fibonacci"
+	"github.com/programmablemike/fibo/internal/jobs"
+	"github.com/programmablemike/fibo/internal/logging"
+	"github.com/spf13/viper"
+)
+
+// The event routes push values and job progress to the client instead of being polled.
+// They speak Server-Sent Events, or WebSocket when the request is an upgrade.
+
+// DefaultMaxStreamLength is the number of terms of a sequence stream when the server doesn't set it
+// It matches the longest synchronous sequence.
+const DefaultMaxStreamLength = maxSequenceLength
+
+// streamRoute is the template of the sequence stream route, its range is in the query
+const streamRoute = "/v1/events/sequence"
+
+// jobEventInterval is how often the job store is polled for progress, it matches how often jobs save it
+var jobEventInterval = 1 * time.Second
+
+var (
+	// wsWriteTimeout bounds every write to a WebSocket client, a stalled client ends the stream
+	wsWriteTimeout = 10 * time.Second
+	// wsPongWait is how long a WebSocket client has to answer a ping before the stream ends
+	wsPongWait = 60 * time.Second
+	// wsPingInterval is how often WebSocket clients are pinged, it must be shorter than wsPongWait
+	wsPingInterval = 50 * time.Second
+)
+
+// errStreamClosed is returned by the sinks once the client can't be written to
+var errStreamClosed = errors.New("the event stream is closed")
+
+// log2Phi is the number of bits F(n) grows by with each ordinal
+const log2Phi = 0.6942419136306174
+
+// Event is a single message of an event stream
+// Server-Sent Events carry the fields as the id, event and data lines, WebSocket messages are
+// the JSON encoded Event.
+type Event struct {
+	ID    string      `json:"id,omitempty"`
+	Event string      `json:"event"`
+	Data  interface{} `json:"data"`
+}
+
+// Term is a value pushed by the sequence stream, the event ID is its ordinal
+type Term struct {
+	Ordinal uint64      `json:"ordinal"`
+	Value   interface{} `json:"value"`
+	Digits  int         `json:"digits"`
+}
+
+// JobProgress is pushed by the job stream whenever the job makes progress
+type JobProgress struct {
+	State    jobs.State `json:"state"`
+	Progress float64    `json:"progress"`
+	// Bits is the estimated bit length of the largest value computed so far
+	Bits uint64 `json:"bits"`
+	// ETA is the estimated number of seconds until the job finishes, once it can be estimated
+	ETA *float64 `json:"eta_seconds,omitempty"`
+}
+
+// eventSink sends events over one of the transports
+type eventSink interface {
+	send(e Event) error
+}
+
+// upgraderFromConfig creates the WebSocket upgrader of the event routes
+// Browsers send the Origin of the page that opens the WebSocket. By default only pages of the
+// server's own origin are allowed, the ws_origins option lists other origins, or * for any.
+func upgraderFromConfig() *websocket.Upgrader {
+	origins := map[string]bool{}
+	for _, item := range viper.GetStringSlice("ws_origins") {
+		for _, origin := range strings.FieldsFunc(item, func(r rune) bool { return r == ',' || r == ' ' }) {
+			origins[strings.ToLower(strings.TrimRight(origin, "/"))] = true
+		}
+	}
+	if len(origins) == 0 {
+		// The default check only allows the server's own origin
+		return &websocket.Upgrader{}
+	}
+	return &websocket.Upgrader{CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" || origins["*"] || origins[strings.ToLower(origin)] {
+			return true
+		}
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}}
+}
+
+// openEventStream starts a WebSocket or Server-Sent Events response
+// The returned context ends when the client goes away. WebSocket clients are pinged and the stream
+// ends when they stop answering, or when a write doesn't complete within wsWriteTimeout.
+func openEventStream(w http.ResponseWriter, r *http.Request, upgrader *websocket.Upgrader) (eventSink, context.Context, func(), error) {
+	if websocket.IsWebSocketUpgrade(r) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// The upgrader has already responded
+			return nil, nil, nil, err
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		})
+		go func() {
+			// Reading handles the control frames and notices when the client closes the connection
+			// or stops answering the pings
+			defer cancel()
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+		go func() {
+			ticker := time.NewTicker(wsPingInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+						cancel()
+						return
+					}
+				case <-ctx.Done():
+					// Closing the connection fails a write that's blocked on the client
+					conn.Close()
+					return
+				}
+			}
+		}()
+		closeStream := func() {
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			conn.Close()
+			cancel()
+		}
+		return &wsSink{conn: conn}, ctx, closeStream, nil
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "streaming is not supported")
+		return nil, nil, nil, fmt.Errorf("the response writer can't flush")
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &sseSink{w: w, flusher: flusher}, r.Context(), func() {}, nil
+}
+
+// sseSink writes events in the text/event-stream format
+type sseSink struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func (s *sseSink) send(e Event) error {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+	if e.ID != "" {
+		fmt.Fprintf(s.w, "id: %s\n", e.ID)
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", e.Event, data); err != nil {
+		return fmt.Errorf("%w: %s", errStreamClosed, err)
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// wsSink writes every event as a JSON text message
+type wsSink struct {
+	conn *websocket.Conn
+}
+
+func (s *wsSink) send(e Event) error {
+	s.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if err := s.conn.WriteJSON(e); err != nil {
+		return fmt.Errorf("%w: %s", errStreamClosed, err)
+	}
+	return nil
+}
+
+// streamEnded returns true if a stream failed because the client went away
+func streamEnded(ctx context.Context, err error) bool {
+	return ctx.Err() != nil || errors.Is(err, errStreamClosed)
+}
+
+// resumeOrdinal returns the ordinal a sequence stream starts at
+// Reconnecting EventSource clients send the ID of the last event they received, which is the
+// last ordinal, in the Last-Event-ID header. WebSocket clients pass it as last_event_id.
+func resumeOrdinal(r *http.Request, from uint64) (uint64, error) {
+	last := r.Header.Get("Last-Event-ID")
+	if last == "" {
+		last = r.URL.Query().Get("last_event_id")
+	}
+	if last == "" {
+		return from, nil
+	}
+	ord, err := strconv.ParseUint(last, 10, 64)
+	if err != nil || ord == math.MaxUint64 {
+		return 0, &requestError{code: CodeInvalidOrdinal, message: "failed to parse the last event ID"}
+	}
+	return ord + 1, nil
+}
+
+// streamLimits bound the sequence streams, which would otherwise compute values for as long as
+// the client listens
+type streamLimits struct {
+	maxOrdinal uint64 // Largest ordinal streamed, 0 means no limit
+	maxLength  uint64 // Most terms streamed by a request
+}
+
+// streamLimitsFromConfig reads the stream limits from the CLI flags/environment/.fiborc
+func streamLimitsFromConfig() streamLimits {
+	s := streamLimits{
+		maxOrdinal: viper.GetUint64("max_ordinal"),
+		maxLength:  viper.GetUint64("max_stream_length"),
+	}
+	if s.maxLength == 0 {
+		s.maxLength = DefaultMaxStreamLength
+	}
+	return s
+}
+
+// streamRange returns the range of a sequence stream
+// Without a to ordinal the stream ends after maxLength terms, or at the synchronous limit. The
+// range is taken from the query so that a reconnecting client, which resumes after the last event
+// it received, ends at the same ordinal.
+func (s streamLimits) streamRange(r *http.Request) (from uint64, to uint64, err error) {
+	l := limits{maxOrdinal: s.maxOrdinal}
+	q := r.URL.Query()
+	if v := q.Get("from"); v != "" {
+		if from, err = l.parseOrdinal(v); err != nil {
+			return
+		}
+	}
+	to = math.MaxUint64
+	if from <= math.MaxUint64-(s.maxLength-1) {
+		to = from + s.maxLength - 1
+	}
+	if s.maxOrdinal > 0 && to > s.maxOrdinal {
+		to = s.maxOrdinal
+	}
+	if v := q.Get("to"); v != "" {
+		if to, err = l.parseOrdinal(v); err != nil {
+			return
+		}
+		if to >= from && to-from >= s.maxLength {
+			err = &requestError{
+				code:    CodeSequenceTooLong,
+				message: fmt.Sprintf("streams are limited to %d values, submit a sequence job for longer ranges", s.maxLength),
+			}
+			return
+		}
+	}
+	if from, err = resumeOrdinal(r, from); err != nil {
+		return
+	}
+	err = l.checkOrdinal(from)
+	return
+}
+
+// parseStreamQuery reads the range and pacing of a sequence stream
+func (l limits) parseStreamQuery(r *http.Request) (from uint64, to uint64, interval time.Duration, err error) {
+	if from, to, err = l.streams.streamRange(r); err != nil {
+		return
+	}
+	q := r.URL.Query()
+	if s := q.Get("interval"); s != "" {
+		if interval, err = time.ParseDuration(s); err != nil || interval < 0 {
+			err = fmt.Errorf("the interval must be a duration like 500ms")
+			return
+		}
+	}
+	return
+}
+
+// streamTerms pushes F(from), F(from+1)... separated by the interval
+// The stream ends with an end event once to has been sent, reconnecting after it resumes past to
+// and only gets the end event. F(from) is the only expensive term, it must be computed within the
+// timeout like a synchronous computation, 0 means no deadline.
+func streamTerms(ctx context.Context, sink eventSink, gen *fibonacci.Generator, from uint64, to uint64, interval time.Duration, timeout time.Duration, format fibonacci.NumberFormat) error {
+	end := Event{Event: "end", Data: map[string]uint64{"to": to}}
+	if from > to {
+		return sink.send(end)
+	}
+	// The deadline cancels a computation that takes too long, it's lifted once F(from) is known
+	start := time.Now()
+	timedOut := func() bool { return false }
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		deadline := time.AfterFunc(timeout, cancel)
+		timedOut = func() bool { return !deadline.Stop() || time.Since(start) >= timeout }
+	}
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	first := true
+	err := gen.Sequence(ctx, from, to, func(ordinal uint64, value *fibonacci.Number) error {
+		if first && timedOut() {
+			return fmt.Errorf("F(%d) was not computed within %s: %w", from, timeout, context.DeadlineExceeded)
+		}
+		if tick != nil && !first {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		first = false
+		return sink.send(Event{
+			ID:    fibonacci.Uint64ToString(ordinal),
+			Event: "value",
+			Data: Term{
+				Ordinal: ordinal,
+				Value:   formatValue(value, format),
+				Digits:  fibonacci.DecimalDigits(value),
+			},
+		})
+	})
+	if err != nil && first && timedOut() {
+		return fmt.Errorf("F(%d) was not computed within %s: %w", from, timeout, context.DeadlineExceeded)
+	}
+	if err != nil {
+		return err
+	}
+	return sink.send(end)
+}
+
+// streamJob pushes the progress of a job until it finishes, then its final status
+func streamJob(ctx context.Context, sink eventSink, jobManager *jobs.Manager, job *jobs.Job) error {
+	ticker := time.NewTicker(jobEventInterval)
+	defer ticker.Stop()
+	var (
+		started       time.Time
+		startProgress float64
+		last          *JobProgress
+	)
+	for {
+		if job.State.Finished() {
+			return sink.send(Event{Event: "finished", Data: newJobStatus(job)})
+		}
+		p := JobProgress{State: job.State, Progress: job.Progress, Bits: jobBits(job)}
+		if job.State == jobs.StateRunning {
+			// The rate is measured from the first progress seen by this stream
+			if started.IsZero() {
+				started, startProgress = time.Now(), job.Progress
+			} else if job.Progress > startProgress {
+				elapsed := time.Since(started).Seconds()
+				eta := elapsed / (job.Progress - startProgress) * (1 - job.Progress)
+				p.ETA = &eta
+			}
+		}
+		if last == nil || last.State != p.State || last.Progress != p.Progress {
+			if err := sink.send(Event{Event: "progress", Data: p}); err != nil {
+				return err
+			}
+			last = &p
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		var err error
+		if job, err = jobManager.Get(job.ID); err != nil {
+			return err
+		}
+	}
+}
+
+// jobBits estimates the bit length of the largest value a job has computed from its progress
+// F(n) has about n*log2(phi) bits, and the progress of every kind is close to linear in the
+// ordinal reached, except count which reports the bit length directly.
+func jobBits(job *jobs.Job) uint64 {
+	var ordinal float64
+	switch job.Kind {
+	case jobs.KindCalculate:
+		ordinal = job.Progress * float64(job.Ordinal)
+	case jobs.KindSequence:
+		ordinal = float64(job.From) + job.Progress*float64(job.To-job.From)
+	case jobs.KindWarm:
+		ordinal = job.Progress * float64(job.To)
+	case jobs.KindCount:
+		return uint64(job.Progress * float64(len(job.Number)) * math.Log2(10))
+	}
+	return uint64(ordinal * log2Phi)
+}
+
+// registerEvents adds the event stream routes to the /v1 router
+func registerEvents(r *mux.Router, gen *fibonacci.Generator, jobManager *jobs.Manager, lim limits) {
+	upgrader := upgraderFromConfig()
+	r.HandleFunc("/events/sequence", func(w http.ResponseWriter, r *http.Request) {
+		from, to, interval, err := lim.parseStreamQuery(r)
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, errorCode(err), err.Error())
+			return
+		}
+		format, err := fibonacci.ParseNumberFormat(r.URL.Query().Get("format"))
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+		sink, ctx, closeStream, err := openEventStream(w, r, upgrader)
+		if err != nil {
+			logging.FromContext(r.Context()).Errorf("Failed to open the event stream: %s", err)
+			return
+		}
+		defer closeStream()
+		logging.FromContext(r.Context()).WithField("from", from).Debug("Streaming Fibonacci sequence")
+		if err := streamTerms(ctx, sink, gen, from, to, interval, lim.timeout, format); err != nil && !streamEnded(ctx, err) {
+			logging.FromContext(r.Context()).Errorf("Failed to stream the sequence: %s", err)
+		}
+	}).Methods("GET")
+
+	r.HandleFunc("/jobs/{id}/events", func(w http.ResponseWriter, r *http.Request) {
+		job, err := loadJob(r, jobManager, mux.Vars(r)["id"])
+		if err != nil {
+			writeJobError(w, r, err, writeProblem)
+			return
+		}
+		sink, ctx, closeStream, err := openEventStream(w, r, upgrader)
+		if err != nil {
+			logging.FromContext(r.Context()).Errorf("Failed to open the event stream: %s", err)
+			return
+		}
+		defer closeStream()
+		if err := streamJob(ctx, sink, jobManager, job); err != nil && !streamEnded(ctx, err) {
+			logging.FromContext(r.Context()).Errorf("Failed to stream job %s: %s", job.ID, err)
+		}
+	}).Methods("GET")
+}
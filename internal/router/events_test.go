@@ -0,0 +1,216 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// sseEvent is an event parsed from a text/event-stream response
+type sseEvent struct {
+	id    string
+	event string
+	data  map[string]interface{}
+}
+
+// readEvents parses a whole text/event-stream response
+func readEvents(t *testing.T, body *bytes.Buffer) []sseEvent {
+	var events []sseEvent
+	for _, block := range strings.Split(strings.TrimSpace(body.String()), "\n\n") {
+		var e sseEvent
+		for _, line := range strings.Split(block, "\n") {
+			field := strings.SplitN(line, ": ", 2)
+			switch field[0] {
+			case "id":
+				e.id = field[1]
+			case "event":
+				e.event = field[1]
+			case "data":
+				assert.NoError(t, json.Unmarshal([]byte(field[1]), &e.data), line)
+			}
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestSequenceEvents(t *testing.T) {
+	r := newTestRouter()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/v1/events/sequence?from=9&to=11", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	events := readEvents(t, w.Body)
+	if assert.Len(t, events, 4) {
+		assert.Equal(t, sseEvent{"9", "value", map[string]interface{}{"ordinal": float64(9), "value": float64(34), "digits": float64(2)}}, events[0])
+		assert.Equal(t, "11", events[2].id)
+		assert.Equal(t, float64(89), events[2].data["value"])
+		assert.Equal(t, sseEvent{"", "end", map[string]interface{}{"to": float64(11)}}, events[3])
+	}
+
+	// A reconnecting client resumes after the last event it received
+	req := httptest.NewRequest("GET", "/v1/events/sequence?from=9&to=11&format=hex", nil)
+	req.Header.Set("Last-Event-ID", "10")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	events = readEvents(t, w.Body)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, "11", events[0].id)
+		assert.Equal(t, "59", events[0].data["value"])
+		assert.Equal(t, "end", events[1].event)
+	}
+
+	status, body := serve(t, r, "GET", "/v1/events/sequence?interval=soon")
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "invalid_request", body["code"])
+}
+
+func TestSequenceEventsLength(t *testing.T) {
+	viper.Set("max_stream_length", 3)
+	defer viper.Set("max_stream_length", 0)
+	r := newTestRouter()
+
+	// Without a to ordinal the stream ends after the maximum length
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/v1/events/sequence?from=5", nil))
+	events := readEvents(t, w.Body)
+	if assert.Len(t, events, 4) {
+		assert.Equal(t, "7", events[2].id)
+		assert.Equal(t, sseEvent{"", "end", map[string]interface{}{"to": float64(7)}}, events[3])
+	}
+	// Reconnecting doesn't extend it
+	req := httptest.NewRequest("GET", "/v1/events/sequence?from=5", nil)
+	req.Header.Set("Last-Event-ID", "7")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Len(t, readEvents(t, w.Body), 1)
+
+	status, body := serve(t, r, "GET", "/v1/events/sequence?from=5&to=8")
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, string(CodeSequenceTooLong), body["code"])
+}
+
+func TestSequenceEventsTimeout(t *testing.T) {
+	viper.Set("request_timeout", time.Nanosecond)
+	defer viper.Set("request_timeout", 0)
+	r := newTestRouter()
+
+	// The stream is closed without any value nor an end event when F(from) takes too long
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/v1/events/sequence?from=100000", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "event:")
+}
+
+func TestSequenceEventsPacing(t *testing.T) {
+	r := newTestRouter()
+	w := httptest.NewRecorder()
+	start := time.Now()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/v1/events/sequence?to=3&interval=20ms", nil))
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(60*time.Millisecond))
+	assert.Len(t, readEvents(t, w.Body), 5)
+}
+
+func TestSequenceEventsWebSocket(t *testing.T) {
+	srv := httptest.NewServer(newTestRouter())
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/v1/events/sequence?from=98&last_event_id=98"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	var e Event
+	assert.NoError(t, conn.ReadJSON(&e))
+	assert.Equal(t, "99", e.ID)
+	assert.Equal(t, "value", e.Event)
+	assert.Equal(t, "218922995834555169026", e.Data.(map[string]interface{})["value"])
+	assert.NoError(t, conn.ReadJSON(&e))
+	assert.Equal(t, "100", e.ID)
+}
+
+func TestJobEvents(t *testing.T) {
+	defer func(interval time.Duration) { jobEventInterval = interval }(jobEventInterval)
+	jobEventInterval = 10 * time.Millisecond
+	r := newTestRouter()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/v1/jobs", strings.NewReader(`{"kind": "calculate", "ordinal": 1000}`)))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var job JobStatus
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &job))
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/v1/jobs/"+job.ID+"/events", nil))
+	events := readEvents(t, w.Body)
+	last := events[len(events)-1]
+	assert.Equal(t, "finished", last.event)
+	assert.Equal(t, "succeeded", last.data["state"])
+	assert.Equal(t, "/v1/jobs/"+job.ID+"/result", last.data["result_url"])
+	for _, e := range events[:len(events)-1] {
+		assert.Equal(t, "progress", e.event)
+	}
+
+	status, _ := serve(t, r, "GET", "/v1/jobs/unknown/events")
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestSequenceEventsWebSocketOrigin(t *testing.T) {
+	dial := func(origins string) error {
+		viper.Set("ws_origins", origins)
+		defer viper.Set("ws_origins", nil)
+		srv := httptest.NewServer(newTestRouter())
+		defer srv.Close()
+		url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/v1/events/sequence?to=1"
+		conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://dashboard.example.com"}})
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+	assert.Error(t, dial(""))
+	assert.Error(t, dial("https://other.example.com"))
+	assert.NoError(t, dial("https://other.example.com,https://dashboard.example.com"))
+	assert.NoError(t, dial("*"))
+}
+
+func TestSequenceEventsWebSocketStalledClient(t *testing.T) {
+	defer func(interval, wait time.Duration) { wsPingInterval, wsPongWait = interval, wait }(wsPingInterval, wsPongWait)
+	wsPingInterval, wsPongWait = 20*time.Millisecond, 50*time.Millisecond
+	srv := httptest.NewServer(newTestRouter())
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/v1/events/sequence?interval=10ms"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	// A client that doesn't answer the pings is disconnected
+	conn.SetPingHandler(func(string) error { return nil })
+	done := make(chan error)
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream didn't end")
+	}
+}
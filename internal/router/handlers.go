@@ -0,0 +1,255 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/programmablemike/fibo/internal/cache"
+	"github.com/programmablemike/fibo/internal/fibonacci"
+	"github.com/programmablemike/fibo/internal/jobs"
+	"github.com/programmablemike/fibo/internal/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// The handlers below serve both the legacy /fibo routes and the /v1 routes. Failures are written
+// with the errorWriter of the API version and the successful responses by its respond function.
+
+// calculateSequence computes F(from) to F(to), or streams them when the client asks for plain text
+func calculateSequence(gen *fibonacci.Generator, lim limits, writeError errorWriter, respond func(w http.ResponseWriter, r *http.Request, from uint64, to uint64, format fibonacci.NumberFormat, values []*fibonacci.Number)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		from, to, err := lim.parseRange(mux.Vars(r))
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errorCode(err), err.Error())
+			return
+		}
+		format, err := fibonacci.ParseNumberFormat(r.URL.Query().Get("format"))
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+
+		logging.FromContext(r.Context()).WithFields(log.Fields{"from": from, "to": to}).Debug("Calculating Fibonacci sequence")
+		ctx, cancel := lim.context(r)
+		defer cancel()
+		r = r.WithContext(ctx)
+		if wantsStream(r) {
+			streamSequence(w, r, gen, from, to, format)
+			return
+		}
+		values := make([]*fibonacci.Number, 0, to-from+1)
+		err = gen.Sequence(r.Context(), from, to, func(ordinal uint64, value *fibonacci.Number) error {
+			values = append(values, value)
+			return nil
+		})
+		if err != nil {
+			status, code := computeError(err)
+			writeError(w, r, status, code, err.Error())
+			return
+		}
+		respond(w, r, from, to, format, values)
+	}
+}
+
+// countOrdinals counts the ordinals whose values are in the range [0, number]
+func countOrdinals(gen *fibonacci.Generator, lim limits, writeError errorWriter, respond func(w http.ResponseWriter, r *http.Request, number *fibonacci.Number, count uint64)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		number, ok := fibonacci.NewNumberFromDecimalString(mux.Vars(r)["number"])
+		if !ok || number.Sign() < 0 {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidRequest, "failed to parse Fibonacci number value")
+			return
+		}
+
+		logging.FromContext(r.Context()).WithField("bits", number.BitLen()).Debug("Counting ordinals")
+		ctx, cancel := lim.context(r)
+		defer cancel()
+		count, err := gen.CountOrdinalsContext(ctx, fibonacci.NewNumber(0), number, nil)
+		if err != nil {
+			status, code := computeError(err)
+			writeError(w, r, status, code, err.Error())
+			return
+		}
+		respond(w, r, number, count)
+	}
+}
+
+// exportCache streams every cache entry in the format selected in the query string
+func exportCache(gen *fibonacci.Generator, writeError errorWriter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format, err := cache.ParseFormat(formatOrDefault(r))
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+		it, ok := gen.Cache().(cache.Iterator)
+		if !ok {
+			writeError(w, r, http.StatusNotImplemented, CodeNotImplemented, "the cache backend does not support exporting")
+			return
+		}
+
+		logging.FromContext(r.Context()).Infof("Exporting the memoizer cache as %s...", format)
+		w.Header().Set("Content-Type", format.ContentType())
+		w.WriteHeader(http.StatusOK)
+		count, err := cache.Export(it, w, format)
+		if err != nil {
+			// The status line has already been sent so the only way to signal
+			// the failure is to abort the response mid-stream
+			logging.FromContext(r.Context()).Errorf("Failed to export the cache after %d entries: %s", count, err)
+			panic(http.ErrAbortHandler)
+		}
+		logging.FromContext(r.Context()).Infof("Exported %d cache entries.", count)
+	}
+}
+
+// importCache writes the entries of the request body into the cache
+// Invalid entries are rejected with 400 and entries the cache fails to store with 503, the entries
+// before the failure stay imported.
+func importCache(gen *fibonacci.Generator, writeError errorWriter, respond func(w http.ResponseWriter, r *http.Request, count int)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format, err := cache.ParseFormat(formatOrDefault(r))
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+
+		logging.FromContext(r.Context()).Infof("Importing %s entries into the memoizer cache...", format)
+		count, err := cache.Import(gen.Cache(), r.Body, format)
+		if err != nil {
+			status, code := http.StatusBadRequest, CodeInvalidRequest
+			var storeErr *cache.StoreError
+			if errors.As(err, &storeErr) {
+				status, code = http.StatusServiceUnavailable, CodeBackendUnavailable
+			}
+			writeError(w, r, status, code, fmt.Sprintf("import failed after %d entries: %s", count, err))
+			return
+		}
+		respond(w, r, count)
+	}
+}
+
+// verifyCache checks the cache entries with the method and action selected in the query string
+func verifyCache(gen *fibonacci.Generator, writeError errorWriter, respond func(w http.ResponseWriter, r *http.Request, result *cache.VerifyResult)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		method, action, err := parseVerifyQuery(r)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+
+		logging.FromContext(r.Context()).Infof("Verifying the memoizer cache (method=%s, action=%s)...", method, action)
+		result, err := cache.Verify(r.Context(), gen.Cache(), method, action)
+		if err != nil {
+			status, code := computeError(err)
+			writeError(w, r, status, code, err.Error())
+			return
+		}
+		respond(w, r, result)
+	}
+}
+
+// startWarmUp starts a cache warm-up in the background, unless one is already running
+func startWarmUp(warm *warmer, writeError errorWriter, respond func(w http.ResponseWriter, r *http.Request, state WarmUp)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		to, step, err := parseWarmQuery(r)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errorCode(err), err.Error())
+			return
+		}
+		state, err := warm.start(to, step)
+		if err != nil {
+			writeError(w, r, http.StatusConflict, CodeConflict, err.Error())
+			return
+		}
+		respond(w, r, state)
+	}
+}
+
+// submitJob queues the job in the request body, once the client is allowed to run it
+// The job is owned by the client that submitted it.
+func submitJob(jobManager *jobs.Manager, lim limits, writeError errorWriter, respond func(w http.ResponseWriter, r *http.Request, job *jobs.Job)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, err := decodeJob(r)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+		if !lim.access.allowJob(w, r, job, writeError) || !lim.rate.allowJob(w, r, job, writeError) {
+			return
+		}
+		job.Owner = jobOwner(r)
+		job, err = jobManager.Submit(job)
+		if err == jobs.ErrShuttingDown {
+			writeError(w, r, http.StatusServiceUnavailable, CodeBackendUnavailable, err.Error())
+			return
+		}
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+		respond(w, r, job)
+	}
+}
+
+// getJob returns the state of a job of the client
+func getJob(jobManager *jobs.Manager, writeError errorWriter, respond func(w http.ResponseWriter, r *http.Request, job *jobs.Job)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, err := loadJob(r, jobManager, mux.Vars(r)["id"])
+		if err != nil {
+			writeJobError(w, r, err, writeError)
+			return
+		}
+		respond(w, r, job)
+	}
+}
+
+// getJobResult returns the result of a job of the client once it has succeeded
+func getJobResult(jobManager *jobs.Manager, writeError errorWriter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, err := loadJob(r, jobManager, mux.Vars(r)["id"])
+		if err != nil {
+			writeJobError(w, r, err, writeError)
+			return
+		}
+		if job.State != jobs.StateSucceeded {
+			writeError(w, r, http.StatusConflict, CodeConflict, fmt.Sprintf("job %s is %s", job.ID, job.State))
+			return
+		}
+		// Results can be megabytes long so they're sent as plain text instead of a JSON string
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, job.Result)
+	}
+}
+
+// cancelJob stops a pending or running job of the client
+func cancelJob(jobManager *jobs.Manager, writeError errorWriter, respond func(w http.ResponseWriter, r *http.Request, job *jobs.Job)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		if _, err := loadJob(r, jobManager, id); err != nil {
+			writeJobError(w, r, err, writeError)
+			return
+		}
+		job, err := jobManager.Cancel(id)
+		if err != nil && job == nil {
+			writeJobError(w, r, err, writeError)
+			return
+		}
+		if err != nil {
+			// The job has already finished
+			writeError(w, r, http.StatusConflict, CodeConflict, err.Error())
+			return
+		}
+		respond(w, r, job)
+	}
+}
+
+// writeJobError responds with 404 for unknown jobs and 503 for store failures
+func writeJobError(w http.ResponseWriter, r *http.Request, err error, writeError errorWriter) {
+	if err == jobs.ErrNotFound {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, err.Error())
+		return
+	}
+	writeError(w, r, http.StatusServiceUnavailable, CodeBackendUnavailable, err.Error())
+}
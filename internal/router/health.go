@@ -0,0 +1,89 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/programmablemike/fibo/internal/cache"
+	"github.com/programmablemike/fibo/internal/fibonacci"
+	"github.com/programmablemike/fibo/internal/logging"
+)
+
+// readinessTimeout bounds the dependency checks of a readiness probe
+const readinessTimeout = 2 * time.Second
+
+const (
+	HealthOK          string = "ok"
+	HealthUnavailable string = "unavailable"
+)
+
+// draining is set while the server shuts down
+var draining int32
+
+// SetDraining makes /readyz fail while the server shuts down so that orchestrators and load
+// balancers stop routing traffic to it before it stops accepting connections
+func SetDraining(v bool) {
+	var i int32
+	if v {
+		i = 1
+	}
+	atomic.StoreInt32(&draining, i)
+}
+
+// Health is the response of the liveness and readiness probes
+type Health struct {
+	Status string `json:"status"`
+	// Checks are the states of the dependencies by name, only set by the readiness probe
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+// HealthCheck is the state of a dependency
+type HealthCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// registerHealth adds the probes for orchestrators
+// /healthz only tells that the process serves requests, restarting it won't fix a broken backend.
+// /readyz fails while the cache is unusable so that traffic is routed to other instances.
+func registerHealth(r *mux.Router, gen *fibonacci.Generator) {
+	r.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeResponse(w, r, http.StatusOK, Health{Status: HealthOK})
+	}).Methods("GET")
+
+	r.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		res := Health{Status: HealthOK, Checks: map[string]HealthCheck{}}
+		checker, ok := gen.Cache().(cache.Checker)
+		if atomic.LoadInt32(&draining) == 1 {
+			res.Status = HealthUnavailable
+			res.Checks["server"] = HealthCheck{Status: HealthUnavailable, Error: "shutting down"}
+		} else if ok {
+			ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+			defer cancel()
+			var failed []string
+			for name, err := range checker.Check(ctx) {
+				if err != nil {
+					res.Status = HealthUnavailable
+					res.Checks[name] = HealthCheck{Status: HealthUnavailable, Error: err.Error()}
+					failed = append(failed, name)
+					continue
+				}
+				res.Checks[name] = HealthCheck{Status: HealthOK}
+			}
+			if len(failed) > 0 {
+				sort.Strings(failed)
+				logging.FromContext(r.Context()).Warnf("Not ready, the checks of %s failed.", strings.Join(failed, ", "))
+			}
+		}
+		status := http.StatusOK
+		if res.Status != HealthOK {
+			status = http.StatusServiceUnavailable
+		}
+		writeResponse(w, r, status, res)
+	}).Methods("GET")
+}
@@ -0,0 +1,74 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/programmablemike/fibo/internal/fibonacci"
+	"github.com/programmablemike/fibo/internal/fibonacci/fibotest"
+	"github.com/programmablemike/fibo/internal/jobs"
+	"github.com/stretchr/testify/assert"
+)
+
+// CheckedMemoryCache is a MemoryCache whose backend can be taken down
+type CheckedMemoryCache struct {
+	*fibotest.MemoryCache
+	err error
+}
+
+func (c *CheckedMemoryCache) Check(ctx context.Context) map[string]error {
+	return map[string]error{"memory": c.err}
+}
+
+func TestHealth(t *testing.T) {
+	status, body := serve(t, newTestRouter(), "GET", "/healthz")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, map[string]interface{}{"status": "ok"}, body)
+
+	// Caches that can't fail are always ready
+	status, body = serve(t, newTestRouter(), "GET", "/readyz")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "ok", body["status"])
+}
+
+func TestReadiness(t *testing.T) {
+	c := &CheckedMemoryCache{MemoryCache: fibotest.NewMemoryCache()}
+	gen := fibonacci.NewGenerator(c)
+	r := NewRouter(gen, jobs.NewManager(gen, jobs.NewMemoryStore(), 1), nil, nil, nil)
+
+	status, body := serve(t, r, "GET", "/readyz")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, map[string]interface{}{"memory": map[string]interface{}{"status": "ok"}}, body["checks"])
+
+	c.err = errors.New("connection refused")
+	status, body = serve(t, r, "GET", "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, "unavailable", body["status"])
+	assert.Equal(t, map[string]interface{}{"memory": map[string]interface{}{"status": "unavailable", "error": "connection refused"}}, body["checks"])
+
+	// The process itself is still alive
+	status, _ = serve(t, r, "GET", "/healthz")
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestDraining(t *testing.T) {
+	gen := fibonacci.NewGenerator(fibotest.NewMemoryCache())
+	jobManager := jobs.NewManager(gen, jobs.NewMemoryStore(), 1)
+	r := NewRouter(gen, jobManager, nil, nil, nil)
+	SetDraining(true)
+	defer SetDraining(false)
+
+	status, body := serve(t, r, "GET", "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, map[string]interface{}{"server": map[string]interface{}{"status": "unavailable", "error": "shutting down"}}, body["checks"])
+	status, _ = serve(t, r, "GET", "/healthz")
+	assert.Equal(t, http.StatusOK, status)
+
+	// Jobs submitted once the manager shuts down are rejected
+	assert.NoError(t, jobManager.Shutdown(context.Background()))
+	w := serveWithToken(r, "POST", "/v1/jobs", "", `{"kind": "calculate", "ordinal": 10}`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "backend_unavailable")
+}
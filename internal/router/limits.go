@@ -0,0 +1,138 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// limits bound the computations that run while a client waits for the response
+type limits struct {
+	maxOrdinal uint64        // Largest ordinal computed synchronously, 0 means no limit
+	timeout    time.Duration // Deadline of a synchronous computation, 0 means no deadline
+	streams    streamLimits  // Bound the sequence streams
+	rate       *rateLimiter  // Throttles the clients
+	access     authorizer    // Checks the scopes of the clients
+}
+
+// limitsFromConfig reads the limits from the CLI flags/environment/.fiborc
+func limitsFromConfig() limits {
+	return limits{
+		maxOrdinal: viper.GetUint64("max_ordinal"),
+		timeout:    viper.GetDuration("request_timeout"),
+		streams:    streamLimitsFromConfig(),
+	}
+}
+
+// context returns the context of a synchronous computation for the request
+func (l limits) context(r *http.Request) (context.Context, context.CancelFunc) {
+	if l.timeout <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	return context.WithTimeout(r.Context(), l.timeout)
+}
+
+// parseOrdinal parses an ordinal that's computed synchronously
+func (l limits) parseOrdinal(s string) (uint64, error) {
+	ord, err := strconv.ParseUint(s, 10, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return 0, &requestError{code: CodeOrdinalTooLarge, message: "ordinals are limited to 64 bits"}
+	}
+	if err != nil {
+		return 0, &requestError{code: CodeInvalidOrdinal, message: "failed to parse ordinal value"}
+	}
+	return ord, l.checkOrdinal(ord)
+}
+
+// checkOrdinal checks an ordinal against the synchronous limit
+func (l limits) checkOrdinal(ord uint64) error {
+	if l.maxOrdinal > 0 && ord > l.maxOrdinal {
+		return &requestError{
+			code:    CodeOrdinalTooLarge,
+			message: fmt.Sprintf("ordinals above %d are only calculated by jobs", l.maxOrdinal),
+		}
+	}
+	return nil
+}
+
+// parseRange parses the from and to ordinals of a synchronous sequence
+func (l limits) parseRange(vars map[string]string) (uint64, uint64, error) {
+	from, err := l.parseOrdinal(vars["from"])
+	if err != nil {
+		return 0, 0, err
+	}
+	to, err := l.parseOrdinal(vars["to"])
+	if err != nil {
+		return 0, 0, err
+	}
+	if to < from {
+		return 0, 0, &requestError{code: CodeInvalidOrdinal, message: "the to ordinal is lower than the from ordinal"}
+	}
+	if to-from >= maxSequenceLength {
+		return 0, 0, &requestError{
+			code:    CodeSequenceTooLong,
+			message: fmt.Sprintf("sequences are limited to %d values, submit a sequence job for longer ranges", maxSequenceLength),
+		}
+	}
+	return from, to, nil
+}
+
+// batchOrdinals lists the ordinals of a batch calculation with the ranges expanded
+func (l limits) batchOrdinals(req *BatchRequest) ([]uint64, error) {
+	count := uint64(len(req.Ordinals))
+	for _, rg := range req.Ranges {
+		if rg.To < rg.From {
+			return nil, &requestError{code: CodeInvalidOrdinal, message: "a range ends before it starts"}
+		}
+		if rg.To-rg.From >= maxSequenceLength {
+			count = maxSequenceLength + 1
+			break
+		}
+		count += rg.To - rg.From + 1
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("the batch has no ordinals")
+	}
+	if count > maxSequenceLength {
+		return nil, fmt.Errorf("batches are limited to %d values, submit jobs for larger batches", maxSequenceLength)
+	}
+	ordinals := append(make([]uint64, 0, count), req.Ordinals...)
+	for _, rg := range req.Ranges {
+		for ord := rg.From; ; ord++ {
+			ordinals = append(ordinals, ord)
+			if ord == rg.To {
+				break
+			}
+		}
+	}
+	for _, ord := range ordinals {
+		if err := l.checkOrdinal(ord); err != nil {
+			return nil, err
+		}
+	}
+	return ordinals, nil
+}
+
+// requestError is an invalid request parameter and the code it's reported with
+type requestError struct {
+	code    ErrorCode
+	message string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+// errorCode returns the code of a requestError, other errors are invalid requests
+func errorCode(err error) ErrorCode {
+	var re *requestError
+	if errors.As(err, &re) {
+		return re.code
+	}
+	return CodeInvalidRequest
+}
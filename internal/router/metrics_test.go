@@ -0,0 +1,22 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	r := newTestRouter()
+	status, _ := serve(t, r, "GET", "/v1/fibonacci/10")
+	assert.Equal(t, http.StatusOK, status)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	// Routes are labelled with their template instead of the requested path
+	assert.Contains(t, w.Body.String(), `fibo_http_requests_total{code="200",method="GET",route="/v1/fibonacci/{ordinal}"}`)
+	assert.NotContains(t, w.Body.String(), `route="/v1/fibonacci/10"`)
+}
@@ -0,0 +1,14 @@
+package router
+
+import _ "embed"
+
+// openAPISpec is the OpenAPI 3 document of every route in NewRouter
+// openapi_test.go checks that the paths and response schemas stay in sync with the code.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// docsPage renders openAPISpec in the browser without any external assets
+//
+//go:embed docs.html
+var docsPage []byte
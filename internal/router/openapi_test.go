@@ -0,0 +1,220 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/programmablemike/fibo/internal/fibonacci"
+	"github.com/programmablemike/fibo/internal/fibonacci/fibotest"
+	"github.com/programmablemike/fibo/internal/jobs"
+	"github.com/programmablemike/fibo/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+// openAPIDocument is the subset of the OpenAPI document checked by the tests
+type openAPIDocument struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]openAPISchema `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPISchema struct {
+	Ref        string                     `json:"$ref"`
+	AllOf      []openAPISchema            `json:"allOf"`
+	Required   []string                   `json:"required"`
+	Properties map[string]json.RawMessage `json:"properties"`
+}
+
+// properties returns the names of the properties of a schema, including the ones from allOf
+func (doc openAPIDocument) properties(s openAPISchema) []string {
+	var names []string
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	for _, part := range s.AllOf {
+		if part.Ref != "" {
+			part = doc.Components.Schemas[strings.TrimPrefix(part.Ref, "#/components/schemas/")]
+		}
+		names = append(names, doc.properties(part)...)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// schemaTypes maps the response schemas to the types that are encoded for them
+var schemaTypes = map[string]interface{}{
+	"StatusResponse":    GenericResponse{},
+	"ErrorResponse":     GenericResponse{},
+	"CalculateResponse": CalculateResponse{},
+	"SequenceResponse":  SequenceResponse{},
+	"BatchRequest":      BatchRequest{},
+	"Range":             Range{},
+	"BatchResponse":     BatchResponse{},
+	"BatchValue":        BatchValue{},
+	"Batch":             Batch{},
+	"CountResponse":     CountResponse{},
+	"ImportResponse":    ImportResponse{},
+	"VerifyResponse":    VerifyResponse{},
+	"WarmResponse":      WarmResponse{},
+	"Job":               jobs.Job{},
+	"JobResponse":       JobResponse{},
+	"Value":             Value{},
+	"Sequence":          Sequence{},
+	"Count":             Count{},
+	"Import":            Import{},
+	"Verification":      Verification{},
+	"WarmUp":            WarmUp{},
+	"JobStatus":         JobStatus{},
+	"CacheEntry":        CacheEntry{},
+	"CacheEntries":      CacheEntries{},
+	"CacheStats":        CacheStats{},
+	"Problem":           Problem{},
+	"Health":            Health{},
+	"HealthCheck":       HealthCheck{},
+	"Event":             Event{},
+	"Term":              Term{},
+	"JobProgress":       JobProgress{},
+}
+
+func loadOpenAPI(t *testing.T) openAPIDocument {
+	var doc openAPIDocument
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatalf("openapi.json is invalid: %s", err)
+	}
+	return doc
+}
+
+func newTestRouter() *mux.Router {
+	gen := fibonacci.NewGenerator(fibotest.NewMemoryCache())
+	limiter, err := ratelimit.FromConfig()
+	if err != nil {
+		panic(err)
+	}
+	return NewRouter(gen, jobs.NewManager(gen, jobs.NewMemoryStore(), 1), nil, limiter, nil)
+}
+
+func TestOpenAPIPathsMatchRoutes(t *testing.T) {
+	doc := loadOpenAPI(t)
+	var documented []string
+	for path, item := range doc.Paths {
+		for method := range item {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+
+	var routes []string
+	err := newTestRouter().Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if route.GetHandler() == nil {
+			return nil // Subrouter prefixes
+		}
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{"GET"} // The root handler accepts every method
+		}
+		for _, method := range methods {
+			routes = append(routes, method+" "+path)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	sort.Strings(documented)
+	sort.Strings(routes)
+	assert.Equal(t, routes, documented)
+}
+
+func TestOpenAPISchemasMatchTypes(t *testing.T) {
+	doc := loadOpenAPI(t)
+	for name, v := range schemaTypes {
+		schema, ok := doc.Components.Schemas[name]
+		if !assert.True(t, ok, "schema %s is missing", name) {
+			continue
+		}
+		fields, required := jsonFields(reflect.TypeOf(v))
+		assert.Equal(t, fields, doc.properties(schema), "properties of %s", name)
+		if name != "Job" && name != "JobStatus" { // Requests only require the kind
+			sort.Strings(schema.Required)
+			assert.Equal(t, required, schema.Required, "required properties of %s", name)
+		}
+	}
+}
+
+func TestOpenAPIReferencesResolve(t *testing.T) {
+	var spec interface{}
+	assert.NoError(t, json.Unmarshal(openAPISpec, &spec))
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["$ref"].(string); ok {
+				var target interface{} = spec
+				for _, key := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+					m, _ := target.(map[string]interface{})
+					target = m[key]
+				}
+				assert.NotNil(t, target, "unresolved reference %s", ref)
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(spec)
+}
+
+func TestServeOpenAPI(t *testing.T) {
+	r := newTestRouter()
+	for path, contentType := range map[string]string{"/openapi.json": "application/json", "/docs": "text/html"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), contentType))
+		assert.NotEmpty(t, w.Body.Bytes())
+	}
+}
+
+// jsonFields returns the sorted JSON names of the fields of t and the ones without omitempty
+func jsonFields(t reflect.Type) (fields []string, required []string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options := tag, ""
+		if i := strings.IndexByte(tag, ','); i >= 0 {
+			name, options = tag[:i], tag[i+1:]
+		}
+		if f.Anonymous && name == "" {
+			embedded, embeddedRequired := jsonFields(f.Type)
+			fields = append(fields, embedded...)
+			required = append(required, embeddedRequired...)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, name)
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	sort.Strings(fields)
+	sort.Strings(required)
+	return fields, required
+}
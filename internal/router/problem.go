@@ -0,0 +1,100 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/programmablemike/fibo/internal/codec"
+	"github.com/programmablemike/fibo/internal/logging"
+)
+
+// ErrorCode identifies why a request failed. Codes are stable, clients should branch on them
+// instead of the message.
+type ErrorCode string
+
+const (
+	CodeInvalidRequest     ErrorCode = "invalid_request"
+	CodeInvalidOrdinal     ErrorCode = "invalid_ordinal"
+	CodeOrdinalTooLarge    ErrorCode = "ordinal_too_large"
+	CodeNotFound           ErrorCode = "not_found"
+	CodeConflict           ErrorCode = "conflict"
+	CodeNotImplemented     ErrorCode = "not_implemented"
+	CodeBackendUnavailable ErrorCode = "backend_unavailable"
+	CodeTimeout            ErrorCode = "timeout"
+	CodeRateLimited        ErrorCode = "rate_limited"
+	CodeInternal           ErrorCode = "internal"
+	CodeUnauthorized       ErrorCode = "unauthorized"
+	CodeForbidden          ErrorCode = "forbidden"
+	CodeSequenceTooLong    ErrorCode = "sequence_too_long"
+)
+
+// problemTitles are the short summaries of the error codes, they don't change between occurrences
+var problemTitles = map[ErrorCode]string{
+	CodeInvalidRequest:     "Invalid request",
+	CodeInvalidOrdinal:     "Invalid ordinal",
+	CodeOrdinalTooLarge:    "Ordinal too large",
+	CodeNotFound:           "Not found",
+	CodeConflict:           "Conflict",
+	CodeNotImplemented:     "Not implemented",
+	CodeBackendUnavailable: "Backend unavailable",
+	CodeTimeout:            "Timeout",
+	CodeRateLimited:        "Rate limited",
+	CodeInternal:           "Internal error",
+	CodeUnauthorized:       "Unauthorized",
+	CodeForbidden:          "Forbidden",
+	CodeSequenceTooLong:    "Sequence too long",
+}
+
+// problemContentType is the media type of problems encoded as JSON (RFC 7807)
+const problemContentType = "application/problem+json"
+
+// Problem describes why a /v1 request failed as RFC 7807 problem details
+type Problem struct {
+	Type   string    `json:"type"`
+	Title  string    `json:"title"`
+	Status int       `json:"status"`
+	Detail string    `json:"detail,omitempty"`
+	Code   ErrorCode `json:"code"`
+}
+
+// NewProblem describes an error, the type is a URN derived from the code
+func NewProblem(status int, code ErrorCode, detail string) Problem {
+	return Problem{
+		Type:   "urn:fibo:error:" + string(code),
+		Title:  problemTitles[code],
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// computeError maps the error of a computation to a status and a code
+// Computations only fail when their context ends or the cache backend fails.
+// The generator and the cache backends wrap the errors of the context.
+func computeError(err error) (int, ErrorCode) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, CodeTimeout
+	case errors.Is(err, context.Canceled):
+		// The client went away, nobody reads the response
+		return http.StatusServiceUnavailable, CodeTimeout
+	}
+	return http.StatusServiceUnavailable, CodeBackendUnavailable
+}
+
+// writeProblem responds with a Problem
+// JSON problems use the problem+json media type, CBOR and MessagePack keep their own.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code ErrorCode, detail string) {
+	c := codec.Negotiate(r.Header.Get("Accept"))
+	contentType := c.ContentType()
+	if c == codec.JSON {
+		contentType = problemContentType
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(status)
+	if err := c.Encode(w, NewProblem(status, code, detail)); err != nil {
+		logging.FromContext(r.Context()).Errorf("Failed to encode the response: %s", err)
+	}
+}
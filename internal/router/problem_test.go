@@ -0,0 +1,85 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProblemContentType(t *testing.T) {
+	w := httptest.NewRecorder()
+	newTestRouter().ServeHTTP(w, httptest.NewRequest("GET", "/v1/fibonacci/x", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+}
+
+func TestProblemCodes(t *testing.T) {
+	viper.Set("max_ordinal", 1000)
+	defer viper.Set("max_ordinal", 0)
+	r := newTestRouter()
+
+	tests := []struct {
+		path   string
+		status int
+		code   ErrorCode
+	}{
+		{"/v1/fibonacci/x", http.StatusBadRequest, CodeInvalidOrdinal},
+		{"/v1/fibonacci/18446744073709551616", http.StatusBadRequest, CodeOrdinalTooLarge},
+		{"/v1/fibonacci/1001", http.StatusBadRequest, CodeOrdinalTooLarge},
+		{"/v1/fibonacci/10?format=nope", http.StatusBadRequest, CodeInvalidRequest},
+		{"/v1/sequence/0/1001", http.StatusBadRequest, CodeOrdinalTooLarge},
+		{"/v1/sequence/10/5", http.StatusBadRequest, CodeInvalidOrdinal},
+		{"/v1/jobs/unknown", http.StatusNotFound, CodeNotFound},
+		{"/v1/jobs/unknown/result", http.StatusNotFound, CodeNotFound},
+	}
+	for _, test := range tests {
+		status, body := serve(t, r, "GET", test.path)
+		assert.Equal(t, test.status, status, test.path)
+		assert.Equal(t, string(test.code), body["code"], test.path)
+		assert.Equal(t, "urn:fibo:error:"+string(test.code), body["type"], test.path)
+		assert.Equal(t, float64(test.status), body["status"], test.path)
+	}
+}
+
+func TestSequenceTooLong(t *testing.T) {
+	status, body := serve(t, newTestRouter(), "GET", "/v1/sequence/0/10000")
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, string(CodeSequenceTooLong), body["code"])
+	status, body = serve(t, newTestRouter(), "GET", "/fibo/sequence/0/10000")
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, string(CodeSequenceTooLong), body["code"])
+}
+
+func TestComputeError(t *testing.T) {
+	// The generator and the backends wrap the errors of the context
+	status, code := computeError(fmt.Errorf("failed to read F(10): %w", context.DeadlineExceeded))
+	assert.Equal(t, http.StatusGatewayTimeout, status)
+	assert.Equal(t, CodeTimeout, code)
+	_, code = computeError(fmt.Errorf("query cancelled: %w", context.Canceled))
+	assert.Equal(t, CodeTimeout, code)
+	status, code = computeError(fmt.Errorf("connection refused"))
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, CodeBackendUnavailable, code)
+}
+
+func TestProblemTimeout(t *testing.T) {
+	viper.Set("request_timeout", time.Nanosecond)
+	defer viper.Set("request_timeout", 0)
+	r := newTestRouter()
+
+	status, body := serve(t, r, "GET", "/v1/fibonacci/100000")
+	assert.Equal(t, http.StatusGatewayTimeout, status)
+	assert.Equal(t, string(CodeTimeout), body["code"])
+
+	// The legacy routes report the code next to the message
+	status, body = serve(t, r, "GET", "/fibo/calculate/100000")
+	assert.Equal(t, http.StatusGatewayTimeout, status)
+	assert.Equal(t, StatusError, body["status"])
+	assert.Equal(t, string(CodeTimeout), body["code"])
+}
@@ -0,0 +1,155 @@
+package router
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/programmablemike/fibo/internal/auth"
+	"github.com/programmablemike/fibo/internal/jobs"
+	"github.com/programmablemike/fibo/internal/ratelimit"
+	"github.com/programmablemike/fibo/internal/route"
+)
+
+// rateLimiter charges the requests of the HTTP API to a ratelimit.Limiter
+type rateLimiter struct {
+	limiter *ratelimit.Limiter
+	streams streamLimits
+}
+
+// Middleware rejects the requests of clients that ran out of tokens with 429 Too Many Requests
+// The cost of the ordinals in the path, or in the from and to query parameters, is taken up front.
+func (l *rateLimiter) Middleware(next http.Handler) http.Handler {
+	if !l.limiter.Enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError := writeGenericError
+		if strings.HasPrefix(r.URL.Path, "/v1/") {
+			writeError = writeProblem
+		}
+		if !l.allow(w, r, 1+l.limiter.Tokens(l.requestDigits(r)), writeError) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowBatch takes the cost of the values of a batch, the request itself was paid by Middleware
+func (l *rateLimiter) allowBatch(w http.ResponseWriter, r *http.Request, ordinals []uint64, writeError errorWriter) bool {
+	if !l.limiter.Enabled() {
+		return true
+	}
+	digits := 0.0
+	for _, ord := range ordinals {
+		digits += ratelimit.EstimateDigits(ord)
+	}
+	return l.allow(w, r, l.limiter.Tokens(digits), writeError)
+}
+
+// allowJob takes the cost of the values of a submitted job, the request itself was paid by Middleware
+func (l *rateLimiter) allowJob(w http.ResponseWriter, r *http.Request, job *jobs.Job, writeError errorWriter) bool {
+	if !l.limiter.Enabled() {
+		return true
+	}
+	return l.allow(w, r, l.limiter.Tokens(jobDigits(job)), writeError)
+}
+
+// allow takes cost tokens from the bucket of the client for the route, or responds with an error
+// saying when to retry
+func (l *rateLimiter) allow(w http.ResponseWriter, r *http.Request, cost float64, writeError errorWriter) bool {
+	wait, ok := l.limiter.Take(clientKey(r), route.Template(r), cost)
+	if ok {
+		return true
+	}
+	retry := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+	writeError(w, r, http.StatusTooManyRequests, CodeRateLimited, fmt.Sprintf("too many requests, retry in %d seconds", retry))
+	return false
+}
+
+// requestDigits estimates the digits of the values a request asks for
+// Streams are charged for the values up to their effective end, other ranges are served up to
+// maxSequenceLength values. Counting is charged for the digits of the number, the values it
+// computes are about as long. Unparseable ordinals cost nothing, the handler rejects them.
+func (l *rateLimiter) requestDigits(r *http.Request) float64 {
+	if route.Template(r) == streamRoute {
+		from, to, err := l.streams.streamRange(r)
+		if err != nil {
+			return 0
+		}
+		return ratelimit.RangeDigits(from, to)
+	}
+	if number, ok := mux.Vars(r)["number"]; ok {
+		return float64(len(number))
+	}
+	from, to, ok := requestRange(r)
+	if !ok {
+		return 0
+	}
+	if to-from >= maxSequenceLength {
+		to = from + maxSequenceLength - 1
+	}
+	return ratelimit.RangeDigits(from, to)
+}
+
+// jobDigits estimates the digits of the values a job computes
+// Warm-ups compute every step-th value up to their last ordinal.
+func jobDigits(job *jobs.Job) float64 {
+	switch job.Kind {
+	case jobs.KindCalculate:
+		return ratelimit.EstimateDigits(job.Ordinal)
+	case jobs.KindCount:
+		return float64(len(job.Number))
+	case jobs.KindSequence:
+		if job.To < job.From {
+			return 0
+		}
+		return ratelimit.RangeDigits(job.From, job.To)
+	case jobs.KindWarm:
+		step := job.Step
+		if step == 0 {
+			step = 1
+		}
+		return ratelimit.RangeDigits(0, job.To) / float64(step)
+	}
+	return 0
+}
+
+// requestRange returns the ordinal, or the from and to ordinals, of the path or the query of a request
+func requestRange(r *http.Request) (uint64, uint64, bool) {
+	vars := mux.Vars(r)
+	get := func(name string) (uint64, bool) {
+		s, ok := vars[name]
+		if !ok {
+			s = r.URL.Query().Get(name)
+		}
+		v, err := strconv.ParseUint(s, 10, 64)
+		return v, err == nil
+	}
+	if ord, ok := get("ordinal"); ok {
+		return ord, ord, true
+	}
+	from, okFrom := get("from")
+	to, okTo := get("to")
+	if !okFrom || !okTo || to < from {
+		return 0, 0, false
+	}
+	return from, to, true
+}
+
+// clientKey identifies the client of a request by its API key, or by its IP address
+func clientKey(r *http.Request) string {
+	if p := auth.FromContext(r.Context()); p != nil && !p.Anonymous() {
+		return "key:" + p.Name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,128 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/programmablemike/fibo/internal/jobs"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestDigits(t *testing.T) {
+	r := newTestRouter()
+	l := &rateLimiter{streams: streamLimitsFromConfig()}
+	var digits float64
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			digits = l.requestDigits(r)
+		})
+	})
+	tests := map[string]float64{
+		"/v1/fibonacci/50000000":                10449382,
+		"/v1/sequence/1000/1002":                627,
+		"/v1/events/sequence?from=1000&to=1002": 627,
+		"/v1/sequence/0/1000000":                10448337,
+		// Unbounded streams are charged up to their default end
+		"/v1/events/sequence?from=1000":  12538213,
+		"/v1/count/12345678901234567890": 20,
+		"/fibo/count/100":                3,
+		"/v1/fibonacci/x":                0,
+		"/v1/jobs/abc":                   0,
+	}
+	for path, want := range tests {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		assert.InDelta(t, want, digits, 1, path)
+	}
+}
+
+// setRateLimits configures the rate limits of the routers created until the returned function is called
+func setRateLimits(rate float64, burst float64, routes []string) func() {
+	viper.Set("rate_limit", rate)
+	viper.Set("rate_limit_burst", burst)
+	viper.Set("rate_limit_digits_per_token", 100)
+	viper.Set("rate_limit_routes", routes)
+	return func() {
+		viper.Set("rate_limit", 0)
+		viper.Set("rate_limit_burst", 0)
+		viper.Set("rate_limit_digits_per_token", 0)
+		viper.Set("rate_limit_routes", nil)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	defer setRateLimits(1, 3, []string{"/healthz=0"})()
+	r := newTestRouter()
+
+	// F(10,000) costs 1 token plus 20.9 for its 2,090 digits, which leaves the bucket 18.9 tokens in debt
+	status, _ := serve(t, r, "GET", "/v1/fibonacci/10000")
+	assert.Equal(t, http.StatusOK, status)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/v1/fibonacci/0", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "20", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), string(CodeRateLimited))
+
+	// The legacy routes share the bucket and report the error in their envelope
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/fibo/calculate/10", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), StatusError)
+
+	// Routes with a 0 rate aren't limited and other clients have their own bucket
+	status, _ = serve(t, r, "GET", "/healthz")
+	assert.Equal(t, http.StatusOK, status)
+	req := httptest.NewRequest("GET", "/v1/fibonacci/10", nil)
+	req.RemoteAddr = "192.0.2.2:1234"
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestRateLimitBatch(t *testing.T) {
+	defer setRateLimits(1, 10, nil)()
+	r := newTestRouter()
+
+	// The batch costs 1 token for the request and 20.9 for the digits of its values
+	req := httptest.NewRequest("POST", "/v1/fibonacci", strings.NewReader(`{"ordinals": [5000, 5001]}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	status, body := serve(t, r, "GET", "/v1/fibonacci/1")
+	assert.Equal(t, http.StatusTooManyRequests, status)
+	assert.Equal(t, string(CodeRateLimited), body["code"])
+}
+
+func TestRateLimitJob(t *testing.T) {
+	defer setRateLimits(1, 10, nil)()
+	r := newTestRouter()
+
+	// The job costs 1 token for the request and 20.9 for the digits of its values
+	req := httptest.NewRequest("POST", "/v1/jobs", strings.NewReader(`{"kind": "sequence", "from": 5000, "to": 5001}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	status, body := serve(t, r, "GET", "/v1/fibonacci/1")
+	assert.Equal(t, http.StatusTooManyRequests, status)
+	assert.Equal(t, string(CodeRateLimited), body["code"])
+}
+
+func TestJobDigits(t *testing.T) {
+	tests := []struct {
+		job  jobs.Job
+		want float64
+	}{
+		{jobs.Job{Kind: jobs.KindCalculate, Ordinal: 50000000}, 10449382},
+		{jobs.Job{Kind: jobs.KindCount, Number: "12345678901234567890"}, 20},
+		{jobs.Job{Kind: jobs.KindSequence, From: 1000, To: 1002}, 627},
+		{jobs.Job{Kind: jobs.KindSequence, From: 1002, To: 1000}, 0},
+		{jobs.Job{Kind: jobs.KindWarm, To: 1000, Step: 10}, 10459},
+	}
+	for _, test := range tests {
+		assert.InDelta(t, test.want, jobDigits(&test.job), 1, "%+v", test.job)
+	}
+}
@@ -2,14 +2,23 @@
 package router
 
 import (
-	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/programmablemike/fibo/internal/auth"
+	"github.com/programmablemike/fibo/internal/cache"
+	"github.com/programmablemike/fibo/internal/codec"
 	"github.com/programmablemike/fibo/internal/fibonacci"
-	log "github.com/sirupsen/logrus"
+	"github.com/programmablemike/fibo/internal/jobs"
+	"github.com/programmablemike/fibo/internal/logging"
+	"github.com/programmablemike/fibo/internal/metrics"
+	"github.com/programmablemike/fibo/internal/ratelimit"
+	"github.com/programmablemike/fibo/internal/tracing"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/viper"
 )
 
@@ -17,6 +26,79 @@ type GenericResponse struct {
 	Status  string `json:"status"`
 	Message string `json:"message"`
 	Value   string `json:"value"`
+	// Number replaces Value with a native bignum in the CBOR and MessagePack encodings
+	Number *fibonacci.Number `json:"-" codec:"value"`
+	// Code identifies the error when Status is StatusError
+	Code ErrorCode `json:"code,omitempty"`
+}
+
+// CalculateResponse holds the value of a single ordinal
+type CalculateResponse struct {
+	GenericResponse
+	Ordinal uint64 `json:"ordinal"`
+	Format  string `json:"format"`
+}
+
+// CountResponse holds the number of ordinals whose values are in the range [0, number]
+type CountResponse struct {
+	GenericResponse
+	Count uint64 `json:"count"`
+}
+
+// ImportResponse reports how many cache entries were imported
+type ImportResponse struct {
+	GenericResponse
+	Imported int `json:"imported"`
+}
+
+// SequenceResponse holds the values of a range of ordinals
+type SequenceResponse struct {
+	GenericResponse
+	From   uint64   `json:"from"`
+	To     uint64   `json:"to"`
+	Values []string `json:"values"`
+	// Numbers replaces Values with native bignums in the CBOR and MessagePack encodings
+	Numbers []*fibonacci.Number `json:"-" codec:"values"`
+}
+
+// BatchRequest lists the ordinals and ranges of a batch calculation
+type BatchRequest struct {
+	Ordinals []uint64 `json:"ordinals,omitempty"`
+	Ranges   []Range  `json:"ranges,omitempty"`
+}
+
+// Range is an inclusive range of ordinals
+type Range struct {
+	From uint64 `json:"from"`
+	To   uint64 `json:"to"`
+}
+
+// BatchResponse holds the values of a batch calculation in ascending ordinal order
+type BatchResponse struct {
+	GenericResponse
+	Format string       `json:"format"`
+	Values []BatchValue `json:"values"`
+}
+
+// BatchValue is a single value of a BatchResponse
+type BatchValue struct {
+	Ordinal uint64 `json:"ordinal"`
+	Value   string `json:"value"`
+	// Number replaces Value with a native bignum in the CBOR and MessagePack encodings
+	Number *fibonacci.Number `json:"-" codec:"value"`
+}
+
+// VerifyResponse reports the outcome of a cache verification pass
+type VerifyResponse struct {
+	GenericResponse
+	Verification
+}
+
+// JobResponse reports the state of an asynchronous job
+type JobResponse struct {
+	GenericResponse
+	Job       *jobs.Job `json:"job"`
+	ResultURL string    `json:"result_url,omitempty"`
 }
 
 const (
@@ -24,93 +106,438 @@ const (
 	StatusError string = "ERROR"
 )
 
-func NewRouter(gen *fibonacci.Generator) *mux.Router {
+// maxJobRequestSize limits the size of a submitted job
+const maxJobRequestSize = 1 << 20
+
+// maxBatchRequestSize limits the size of a batch calculation request
+const maxBatchRequestSize = 1 << 20
+
+// maxSequenceLength is the largest range served synchronously, longer ranges should use a sequence job
+// It also limits the number of values in a batch.
+const maxSequenceLength = 10000
+
+// NewRouter creates the router of the HTTP API
+// A nil authenticator lets every request through and a nil limiter doesn't limit the rate of
+// requests. Cache warm-ups run in the background of the tracker.
+func NewRouter(gen *fibonacci.Generator, jobManager *jobs.Manager, authenticator *auth.Authenticator, limiter *ratelimit.Limiter, tracker *Tracker) *mux.Router {
 	r := mux.NewRouter()
+	lim := limitsFromConfig()
+	lim.access = authorizer{auth: authenticator, streams: lim.streams}
+	lim.rate = &rateLimiter{limiter: limiter, streams: lim.streams}
+	r.Use(tracing.Middleware, logging.Middleware, metrics.Middleware, lim.access.Middleware, lim.rate.Middleware)
 
 	// Root handler
 	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
 		res := GenericResponse{
 			Status:  StatusOK,
 			Message: "OK",
 		}
-		json.NewEncoder(w).Encode(res)
+		writeResponse(w, r, http.StatusOK, res)
 	})
 
+	registerHealth(r, gen)
+
+	r.Handle("/metrics", promhttp.Handler()).Methods("GET")
+
+	r.HandleFunc("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPISpec)
+	}).Methods("GET")
+
+	r.HandleFunc("/docs", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(docsPage)
+	}).Methods("GET")
+
 	// Ordinal handler
 	r.HandleFunc("/fibo/calculate/{ordinal}", func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 
-		log.Infof("Calculating Fibonacci number for ordinal=%s...", vars["ordinal"])
-		ord, err := strconv.ParseUint(vars["ordinal"], 10, 64)
+		ord, err := lim.parseOrdinal(vars["ordinal"])
 		if err != nil {
-			res := GenericResponse{
-				Status:  StatusError,
-				Message: "failed to parse ordinal value",
-			}
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(res)
+			writeGenericError(w, r, http.StatusBadRequest, errorCode(err), err.Error())
 			return
 		}
-		value := gen.Compute(ord)
-		res := GenericResponse{
-			Status:  StatusOK,
-			Message: "",
-			Value:   value.String(),
+		logging.FromContext(r.Context()).WithField("ordinal", ord).Debug("Calculating Fibonacci number")
+		format, err := fibonacci.ParseNumberFormat(r.URL.Query().Get("format"))
+		if err != nil {
+			writeGenericError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+		ctx, cancel := lim.context(r)
+		defer cancel()
+		r = r.WithContext(ctx)
+		if wantsStream(r) {
+			streamValue(w, r, gen, ord, format, writeGenericError)
+			return
+		}
+		value, err := gen.ComputeContext(r.Context(), ord, nil)
+		if err != nil {
+			status, code := computeError(err)
+			writeGenericError(w, r, status, code, err.Error())
+			return
 		}
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(res)
+		res := CalculateResponse{
+			GenericResponse: GenericResponse{
+				Status:  StatusOK,
+				Message: "",
+			},
+			Ordinal: ord,
+			Format:  format.String(),
+		}
+		if nativeNumbers(r, format) {
+			res.Number = value
+		} else {
+			res.Value = format.Text(value)
+		}
+		writeResponse(w, r, http.StatusOK, res)
 	}).Methods("GET")
 
+	r.HandleFunc("/fibo/calculate", func(w http.ResponseWriter, r *http.Request) {
+		format, err := fibonacci.ParseNumberFormat(r.URL.Query().Get("format"))
+		if err != nil {
+			writeGenericError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+		ordinals, err := decodeBatch(r, lim)
+		if err != nil {
+			writeGenericError(w, r, http.StatusBadRequest, errorCode(err), err.Error())
+			return
+		}
+		if !lim.access.allowBatch(w, r, ordinals, writeGenericError) || !lim.rate.allowBatch(w, r, ordinals, writeGenericError) {
+			return
+		}
+
+		logging.FromContext(r.Context()).WithField("ordinals", len(ordinals)).Debug("Calculating a batch of Fibonacci numbers")
+		ctx, cancel := lim.context(r)
+		defer cancel()
+		native := nativeNumbers(r, format)
+		res := BatchResponse{
+			GenericResponse: GenericResponse{
+				Status: StatusOK,
+			},
+			Format: format.String(),
+		}
+		err = gen.Batch(ctx, ordinals, func(ordinal uint64, value *fibonacci.Number, cached bool) error {
+			v := BatchValue{Ordinal: ordinal}
+			if native {
+				v.Number = value
+			} else {
+				v.Value = format.Text(value)
+			}
+			res.Values = append(res.Values, v)
+			return nil
+		})
+		if err != nil {
+			status, code := computeError(err)
+			writeGenericError(w, r, status, code, err.Error())
+			return
+		}
+		res.Value = strconv.Itoa(len(res.Values))
+		writeResponse(w, r, http.StatusOK, res)
+	}).Methods("POST")
+
 	r.HandleFunc("/fibo/cache", func(w http.ResponseWriter, r *http.Request) {
-		log.Info("Clearing the memoizer cache...")
+		logging.FromContext(r.Context()).Info("Clearing the memoizer cache...")
 
 		err := gen.ClearCache()
 		if err != nil {
-			res := GenericResponse{
-				Status:  StatusError,
-				Message: fmt.Sprintf("%e", err),
-			}
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(res)
+			writeGenericError(w, r, http.StatusServiceUnavailable, CodeBackendUnavailable, err.Error())
 			return
 		}
 		res := GenericResponse{
 			Status:  StatusOK,
 			Message: "Cache cleared",
 		}
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(res)
+		writeResponse(w, r, http.StatusOK, res)
 	}).Methods("DELETE")
 
-	// Step counter
-	r.HandleFunc("/fibo/count/{number}", func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
+	r.HandleFunc("/fibo/cache/export", exportCache(gen, writeGenericError)).Methods("GET")
 
-		log.Infof("Counting ordinals between 0 and %s...", vars["number"])
-		number, ok := fibonacci.NewNumberFromDecimalString(vars["number"])
-		if !ok {
-			res := GenericResponse{
-				Status:  StatusError,
-				Message: "failed to parse Fibonacci number value",
-			}
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(res)
-			return
+	r.HandleFunc("/fibo/cache/import", importCache(gen, writeGenericError, func(w http.ResponseWriter, r *http.Request, count int) {
+		res := ImportResponse{
+			GenericResponse: GenericResponse{
+				Status:  StatusOK,
+				Message: "Cache imported",
+				Value:   strconv.Itoa(count),
+			},
+			Imported: count,
 		}
-		value := gen.FindOrdinalsInRange(fibonacci.NewNumber(0), number)
-		res := GenericResponse{
-			Status:  StatusOK,
-			Message: "",
-			Value:   fibonacci.Uint64ToString(value),
+		writeResponse(w, r, http.StatusOK, res)
+	})).Methods("POST")
+
+	r.HandleFunc("/fibo/cache/verify", verifyCache(gen, writeGenericError, func(w http.ResponseWriter, r *http.Request, result *cache.VerifyResult) {
+		res := VerifyResponse{
+			GenericResponse: GenericResponse{
+				Status:  StatusOK,
+				Message: fmt.Sprintf("Found %d bad entries", len(result.Bad)),
+				Value:   strconv.Itoa(len(result.Bad)),
+			},
+			Verification: newVerification(result),
+		}
+		writeResponse(w, r, http.StatusOK, res)
+	})).Methods("POST")
+
+	warm := newWarmer(gen, tracker)
+	r.HandleFunc("/fibo/cache/warm", startWarmUp(warm, writeGenericError, func(w http.ResponseWriter, r *http.Request, state WarmUp) {
+		res := WarmResponse{WarmUp: state}
+		res.Status = StatusOK
+		res.Message = "Cache warm-up started"
+		writeResponse(w, r, http.StatusAccepted, res)
+	})).Methods("POST")
+
+	r.HandleFunc("/fibo/cache/warm", func(w http.ResponseWriter, r *http.Request) {
+		res := WarmResponse{WarmUp: warm.progress()}
+		res.Status = StatusOK
+		if res.Error != "" {
+			res.Status = StatusError
+			res.Message = res.Error
+			res.Code = CodeInternal
 		}
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(res)
+		writeResponse(w, r, http.StatusOK, res)
 	}).Methods("GET")
 
+	r.HandleFunc("/fibo/jobs", submitJob(jobManager, lim, writeGenericError, func(w http.ResponseWriter, r *http.Request, job *jobs.Job) {
+		res := newJobResponse(job)
+		res.Message = "Job submitted"
+		w.Header().Set("Location", jobURL(job))
+		writeResponse(w, r, http.StatusAccepted, res)
+	})).Methods("POST")
+
+	r.HandleFunc("/fibo/jobs/{id}", getJob(jobManager, writeGenericError, func(w http.ResponseWriter, r *http.Request, job *jobs.Job) {
+		writeResponse(w, r, http.StatusOK, newJobResponse(job))
+	})).Methods("GET")
+
+	r.HandleFunc("/fibo/jobs/{id}/result", getJobResult(jobManager, writeGenericError)).Methods("GET")
+
+	r.HandleFunc("/fibo/jobs/{id}", cancelJob(jobManager, writeGenericError, func(w http.ResponseWriter, r *http.Request, job *jobs.Job) {
+		res := newJobResponse(job)
+		res.Message = "Job cancelled"
+		writeResponse(w, r, http.StatusOK, res)
+	})).Methods("DELETE")
+
+	r.HandleFunc("/fibo/sequence/{from}/{to}", calculateSequence(gen, lim, writeGenericError, func(w http.ResponseWriter, r *http.Request, from uint64, to uint64, format fibonacci.NumberFormat, values []*fibonacci.Number) {
+		res := SequenceResponse{
+			GenericResponse: GenericResponse{
+				Status: StatusOK,
+				Value:  strconv.FormatUint(to-from+1, 10),
+			},
+			From: from,
+			To:   to,
+		}
+		if nativeNumbers(r, format) {
+			res.Numbers = values
+		} else {
+			for _, value := range values {
+				res.Values = append(res.Values, format.Text(value))
+			}
+		}
+		writeResponse(w, r, http.StatusOK, res)
+	})).Methods("GET")
+
+	// Step counter
+	r.HandleFunc("/fibo/count/{number}", countOrdinals(gen, lim, writeGenericError, func(w http.ResponseWriter, r *http.Request, number *fibonacci.Number, count uint64) {
+		res := CountResponse{
+			GenericResponse: GenericResponse{
+				Status:  StatusOK,
+				Message: "",
+				Value:   fibonacci.Uint64ToString(count),
+			},
+			Count: count,
+		}
+		writeResponse(w, r, http.StatusOK, res)
+	})).Methods("GET")
+
+	registerV1(r.PathPrefix("/v1").Subrouter(), gen, jobManager, warm, lim)
+
 	return r
 }
 
+// nativeNumbers returns true if values should be sent as bignums instead of formatted strings
+func nativeNumbers(r *http.Request, format fibonacci.NumberFormat) bool {
+	return format.Kind == fibonacci.FormatDecimal && codec.Negotiate(r.Header.Get("Accept")).Bignums()
+}
+
+// wantsStream returns true if the client asked for the value as a plain text stream
+func wantsStream(r *http.Request) bool {
+	if stream, err := strconv.ParseBool(r.URL.Query().Get("stream")); err == nil {
+		return stream
+	}
+	return strings.HasPrefix(r.Header.Get("Accept"), "text/plain")
+}
+
+// errorWriter responds with an error in the style of an API version
+type errorWriter func(w http.ResponseWriter, r *http.Request, status int, code ErrorCode, message string)
+
+// writeGenericError responds with an error for the legacy /fibo routes
+func writeGenericError(w http.ResponseWriter, r *http.Request, status int, code ErrorCode, message string) {
+	res := GenericResponse{
+		Status:  StatusError,
+		Message: message,
+		Code:    code,
+	}
+	writeResponse(w, r, status, res)
+}
+
+// streamValue writes F(ordinal) in the format followed by a newline as plain text, or the raw bytes
+//
+// No Content-Length is set so the response uses chunked transfer encoding. Cached decimal values
+// are copied straight from backends that can stream them, through the cache bound to the request,
+// everything else is computed with fast doubling. Both stop when the client goes away and the
+// digits are written in chunks.
+func streamValue(w http.ResponseWriter, r *http.Request, gen *fibonacci.Generator, ordinal uint64, format fibonacci.NumberFormat, writeError errorWriter) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if format.Binary() {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	if s, ok := gen.CacheContext(r.Context()).(cache.Streamer); ok && format.Kind == fibonacci.FormatDecimal {
+		n, err := s.StreamValue(ordinal, w)
+		switch {
+		case err == nil:
+			io.WriteString(w, "\n")
+			return
+		case n > 0:
+			// The status line has already been sent so the only way to signal
+			// the failure is to abort the response mid-stream
+			logging.FromContext(r.Context()).Errorf("Failed to stream the value for ordinal=%s: %s", fibonacci.Uint64ToString(ordinal), err)
+			panic(http.ErrAbortHandler)
+		case err != cache.ErrNotFound:
+			logging.FromContext(r.Context()).Warnf("Failed to stream the cached value for ordinal=%s: %s", fibonacci.Uint64ToString(ordinal), err)
+		}
+	}
+	value, err := gen.ComputeContext(r.Context(), ordinal, nil)
+	if err != nil {
+		status, code := computeError(err)
+		writeError(w, r, status, code, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, err := format.WriteTo(w, value); err != nil {
+		return // The client went away
+	}
+	if !format.Binary() {
+		io.WriteString(w, "\n")
+	}
+}
+
+// streamSequence writes F(from) to F(to) as plain text, one value per line
+// The bytes format is base64 encoded because raw values can't be delimited.
+func streamSequence(w http.ResponseWriter, r *http.Request, gen *fibonacci.Generator, from uint64, to uint64, format fibonacci.NumberFormat) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	err := gen.Sequence(r.Context(), from, to, func(ordinal uint64, value *fibonacci.Number) error {
+		_, err := io.WriteString(w, format.Text(value)+"\n")
+		return err
+	})
+	if err != nil {
+		logging.FromContext(r.Context()).Errorf("Failed to stream the sequence: %s", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// parseVerifyQuery parses the verification method and action, both are optional
+func parseVerifyQuery(r *http.Request) (cache.VerifyMethod, cache.VerifyAction, error) {
+	query := r.URL.Query()
+	method, err := cache.ParseVerifyMethod(valueOrDefault(query.Get("method"), string(cache.VerifyBoth)))
+	if err != nil {
+		return "", "", err
+	}
+	action, err := cache.ParseVerifyAction(valueOrDefault(query.Get("action"), string(cache.ActionReport)))
+	if err != nil {
+		return "", "", err
+	}
+	return method, action, nil
+}
+
+// parseWarmQuery parses the last ordinal and the optional step of a warm-up
+func parseWarmQuery(r *http.Request) (uint64, uint64, error) {
+	query := r.URL.Query()
+	to, err := strconv.ParseUint(query.Get("to"), 10, 64)
+	if err != nil {
+		return 0, 0, &requestError{code: CodeInvalidOrdinal, message: "failed to parse the to ordinal value"}
+	}
+	step, err := strconv.ParseUint(valueOrDefault(query.Get("step"), "1"), 10, 64)
+	if err != nil || step == 0 {
+		return 0, 0, fmt.Errorf("failed to parse the step value")
+	}
+	return to, step, nil
+}
+
+// decodeJob decodes a submitted job with the codec for the Content-Type of the request
+func decodeJob(r *http.Request) (*jobs.Job, error) {
+	job := new(jobs.Job)
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxJobRequestSize))
+	if err == nil {
+		err = codec.Unmarshal(codec.ForContentType(r.Header.Get("Content-Type")), body, job)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse job: %s", err)
+	}
+	return job, nil
+}
+
+// decodeBatch decodes a batch calculation with the codec for the Content-Type of the request and
+// returns its ordinals
+func decodeBatch(r *http.Request, lim limits) ([]uint64, error) {
+	req := new(BatchRequest)
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBatchRequestSize))
+	if err == nil {
+		err = codec.Unmarshal(codec.ForContentType(r.Header.Get("Content-Type")), body, req)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse batch: %s", err)
+	}
+	return lim.batchOrdinals(req)
+}
+
+// jobURL is the status URL of a job
+func jobURL(job *jobs.Job) string {
+	return "/fibo/jobs/" + job.ID
+}
+
+// newJobResponse describes a job, the result URL is only set once there's a result to fetch
+func newJobResponse(job *jobs.Job) JobResponse {
+	res := JobResponse{
+		GenericResponse: GenericResponse{
+			Status:  StatusOK,
+			Message: string(job.State),
+		},
+		Job: job,
+	}
+	if job.State == jobs.StateSucceeded {
+		res.ResultURL = jobURL(job) + "/result"
+	}
+	if job.State == jobs.StateFailed {
+		res.Status = StatusError
+		res.Message = job.Error
+	}
+	return res
+}
+
+// writeResponse encodes res as JSON, CBOR or MessagePack depending on the Accept header
+func writeResponse(w http.ResponseWriter, r *http.Request, status int, res interface{}) {
+	c := codec.Negotiate(r.Header.Get("Accept"))
+	w.Header().Set("Content-Type", c.ContentType())
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(status)
+	if err := c.Encode(w, res); err != nil {
+		logging.FromContext(r.Context()).Errorf("Failed to encode the response: %s", err)
+	}
+}
+
+// formatOrDefault returns the export format requested in the query string
+func formatOrDefault(r *http.Request) string {
+	return valueOrDefault(r.URL.Query().Get("format"), string(cache.FormatJSONL))
+}
+
+// valueOrDefault returns v unless it's empty
+func valueOrDefault(v string, def string) string {
+	if v != "" {
+		return v
+	}
+	return def
+}
+
 // createDsnFromConfig converts the options in the CLI flags/environment/.fiborc into a Postgres
 // connection string
 func createDsnFromConfig() string {
@@ -0,0 +1,153 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/programmablemike/fibo/internal/cache"
+	"github.com/programmablemike/fibo/internal/fibonacci"
+	"github.com/programmablemike/fibo/internal/fibonacci/fibotest"
+	"github.com/programmablemike/fibo/internal/jobs"
+	"github.com/stretchr/testify/assert"
+)
+
+// StreamingMemoryCache is a MemoryCache that streams its values like the Postgres backend
+type StreamingMemoryCache struct {
+	*fibotest.MemoryCache
+	streamed int
+}
+
+func (c *StreamingMemoryCache) StreamValue(ordinal uint64, w io.Writer) (int64, error) {
+	value, err := c.Read(ordinal)
+	if err != nil {
+		return 0, cache.ErrNotFound
+	}
+	c.streamed++
+	n, err := io.WriteString(w, value.String())
+	return int64(n), err
+}
+
+// BoundStreamingMemoryCache is a StreamingMemoryCache that only streams once bound to a request
+type BoundStreamingMemoryCache struct {
+	*StreamingMemoryCache
+	ctx context.Context
+}
+
+func (c *BoundStreamingMemoryCache) WithContext(ctx context.Context) fibonacci.Memoizer {
+	return &BoundStreamingMemoryCache{StreamingMemoryCache: c.StreamingMemoryCache, ctx: ctx}
+}
+
+func (c *BoundStreamingMemoryCache) StreamValue(ordinal uint64, w io.Writer) (int64, error) {
+	if c.ctx == nil {
+		return 0, errors.New("the cache is not bound to a request")
+	}
+	return c.StreamingMemoryCache.StreamValue(ordinal, w)
+}
+
+func TestStreamValueBoundToRequest(t *testing.T) {
+	c := &StreamingMemoryCache{MemoryCache: fibotest.NewMemoryCache()}
+	assert.NoError(t, c.Write(100, fibonacci.FastDoubling(100)))
+	gen := fibonacci.NewGenerator(&BoundStreamingMemoryCache{StreamingMemoryCache: c})
+	r := NewRouter(gen, jobs.NewManager(gen, jobs.NewMemoryStore(), 1), nil, nil, nil)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/v1/fibonacci/100?stream=1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, fibonacci.FastDoubling(100).String()+"\n", w.Body.String())
+	assert.Equal(t, 1, c.streamed)
+}
+
+func TestStreamValue(t *testing.T) {
+	// F(100,000) has 20,899 digits, which are written in several chunks
+	f100000 := fibonacci.FastDoubling(100000)
+	tests := []struct {
+		Name        string
+		Query       string
+		Accept      string
+		Cached      bool
+		Streamed    bool // Copied from the cache
+		ContentType string
+		Expected    string
+	}{
+		{Name: "uncached", Query: "?stream=1", ContentType: "text/plain; charset=utf-8", Expected: f100000.String() + "\n"},
+		{Name: "uncached text/plain", Accept: "text/plain", ContentType: "text/plain; charset=utf-8", Expected: f100000.String() + "\n"},
+		{Name: "cached", Query: "?stream=1", Cached: true, Streamed: true, ContentType: "text/plain; charset=utf-8", Expected: f100000.String() + "\n"},
+		{Name: "cached text/plain", Accept: "text/plain", Cached: true, Streamed: true, ContentType: "text/plain; charset=utf-8", Expected: f100000.String() + "\n"},
+		{Name: "cached hex", Query: "?stream=1&format=hex", Cached: true, ContentType: "text/plain; charset=utf-8", Expected: f100000.Text(16) + "\n"},
+		{Name: "uncached grouped", Query: "?stream=1&format=grouped", ContentType: "text/plain; charset=utf-8", Expected: fibonacci.NumberFormat{Kind: fibonacci.FormatGrouped, Separator: ","}.Text(f100000) + "\n"},
+		{Name: "cached bytes", Query: "?stream=1&format=bytes", Cached: true, ContentType: "application/octet-stream", Expected: string(f100000.Bytes())},
+	}
+	for _, path := range []string{"/fibo/calculate/100000", "/v1/fibonacci/100000"} {
+		for _, streaming := range []bool{false, true} {
+			for _, test := range tests {
+				name := path + " " + test.Name
+				c := &StreamingMemoryCache{MemoryCache: fibotest.NewMemoryCache()}
+				var memo fibonacci.Memoizer = c.MemoryCache
+				if streaming {
+					memo, name = c, name+" from a streaming cache"
+				}
+				if test.Cached {
+					c.Write(100000, f100000)
+				}
+				gen := fibonacci.NewGenerator(memo)
+				r := NewRouter(gen, jobs.NewManager(gen, jobs.NewMemoryStore(), 1), nil, nil, nil)
+
+				req := httptest.NewRequest("GET", path+test.Query, nil)
+				if test.Accept != "" {
+					req.Header.Set("Accept", test.Accept)
+				}
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				assert.Equal(t, http.StatusOK, w.Code, name)
+				assert.Equal(t, test.ContentType, w.Header().Get("Content-Type"), name)
+				assert.Empty(t, w.Header().Get("Content-Length"), name)
+				assert.True(t, test.Expected == w.Body.String(), name)
+				assert.Equal(t, streaming && test.Streamed, c.streamed > 0, name)
+			}
+		}
+	}
+}
+
+func TestFormats(t *testing.T) {
+	r := newTestRouter()
+	tests := []struct {
+		Format   string
+		Spec     string   // The format echoed in the response
+		Value    string   // F(100)
+		Sequence []string // F(10) to F(12)
+	}{
+		{Format: "hex", Spec: "hex", Value: "1333db76a7c594bfc3", Sequence: []string{"37", "59", "90"}},
+		{Format: "bytes", Spec: "bytes", Value: "EzPbdqfFlL/D", Sequence: []string{"Nw==", "WQ==", "kA=="}},
+		{Format: "sci", Spec: "sci:6", Value: "3.54225e+20", Sequence: []string{"5.50000e+01", "8.90000e+01", "1.44000e+02"}},
+		{Format: "sci:2", Spec: "sci:2", Value: "3.5e+20", Sequence: []string{"5.5e+01", "8.9e+01", "1.4e+02"}},
+		{Format: "grouped", Spec: "grouped:,", Value: "354,224,848,179,261,915,075", Sequence: []string{"55", "89", "144"}},
+		{Format: "grouped:_", Spec: "grouped:_", Value: "354_224_848_179_261_915_075", Sequence: []string{"55", "89", "144"}},
+	}
+	for _, test := range tests {
+		status, body := serve(t, r, "GET", "/fibo/calculate/100?format="+test.Format)
+		assert.Equal(t, http.StatusOK, status, test.Format)
+		assert.Equal(t, test.Value, body["value"], test.Format)
+		assert.Equal(t, test.Spec, body["format"], test.Format)
+
+		status, body = serve(t, r, "GET", "/v1/fibonacci/100?format="+test.Format)
+		assert.Equal(t, http.StatusOK, status, test.Format)
+		assert.Equal(t, test.Value, body["value"], test.Format)
+		assert.Equal(t, test.Spec, body["format"], test.Format)
+
+		status, body = serve(t, r, "GET", "/fibo/sequence/10/12?format="+test.Format)
+		assert.Equal(t, http.StatusOK, status, test.Format)
+		assert.Equal(t, []interface{}{test.Sequence[0], test.Sequence[1], test.Sequence[2]}, body["values"], test.Format)
+	}
+
+	for _, path := range []string{"/fibo/calculate/100", "/v1/fibonacci/100", "/fibo/sequence/10/12"} {
+		status, body := serve(t, r, "GET", path+"?format=octal")
+		assert.Equal(t, http.StatusBadRequest, status, path)
+		assert.Equal(t, string(CodeInvalidRequest), body["code"], path)
+		assert.Contains(t, fmt.Sprint(body), `invalid format "octal"`, path)
+	}
+}
@@ -0,0 +1,78 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// Tracker keeps track of the requests being served, including the live streams of hijacked
+// connections, and of the background work they start, like cache warm-ups, so that the server can
+// wait for them on shutdown and cancel them once its grace period is over
+// A nil Tracker runs the background work on context.Background and doesn't wait for anything.
+type Tracker struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewTracker() *Tracker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Tracker{ctx: ctx, cancel: cancel}
+}
+
+// Context returns the context of the requests and of the background work, it's cancelled by Shutdown
+// It's meant as the base context of the http.Server.
+func (t *Tracker) Context() context.Context {
+	if t == nil {
+		return context.Background()
+	}
+	return t.ctx
+}
+
+// Middleware counts the requests until their handler returns
+func (t *Tracker) Middleware(next http.Handler) http.Handler {
+	if t == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.wg.Add(1)
+		defer t.wg.Done()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Go runs f in the background with the context of the tracker
+func (t *Tracker) Go(f func(ctx context.Context)) {
+	if t == nil {
+		go f(context.Background())
+		return
+	}
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		f(t.ctx)
+	}()
+}
+
+// Shutdown waits for the requests and the background work to return
+// When ctx ends first they're cancelled, and Shutdown returns ctx.Err() once they've returned.
+func (t *Tracker) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.cancel()
+		return nil
+	case <-ctx.Done():
+	}
+	t.cancel()
+	<-done
+	return ctx.Err()
+}
@@ -0,0 +1,412 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/programmablemike/fibo/internal/cache"
+	"github.com/programmablemike/fibo/internal/fibonacci"
+	"github.com/programmablemike/fibo/internal/jobs"
+	"github.com/programmablemike/fibo/internal/logging"
+)
+
+// The /v1 routes return typed payloads instead of the GenericResponse envelope of the /fibo routes.
+// Success is signalled by the status code and failures carry a Problem.
+
+// Value is F(ordinal)
+type Value struct {
+	Ordinal uint64 `json:"ordinal"`
+	// Value is a JSON number in the decimal format when every JSON parser can read it exactly,
+	// otherwise it's a string
+	Value interface{} `json:"value"`
+	// Number replaces Value with a native bignum in the CBOR and MessagePack encodings
+	Number *fibonacci.Number `json:"-" codec:"value"`
+	Format string            `json:"format"`
+	Digits int               `json:"digits"` // Number of decimal digits
+	Cached bool              `json:"cached"` // Whether the value was read from the cache
+}
+
+// Batch holds the values of a batch calculation in ascending ordinal order
+type Batch struct {
+	Values []Value `json:"values"`
+}
+
+// Sequence holds the values of a range of ordinals
+type Sequence struct {
+	From   uint64        `json:"from"`
+	To     uint64        `json:"to"`
+	Format string        `json:"format"`
+	Values []interface{} `json:"values"`
+	// Numbers replaces Values with native bignums in the CBOR and MessagePack encodings
+	Numbers []*fibonacci.Number `json:"-" codec:"values"`
+}
+
+// Count holds the number of ordinals whose values are in the range [0, number]
+type Count struct {
+	Number interface{} `json:"number"`
+	Count  uint64      `json:"count"`
+}
+
+// Import reports how many cache entries were imported
+type Import struct {
+	Imported int `json:"imported"`
+}
+
+// Verification is the outcome of a cache verification pass
+type Verification struct {
+	Checked  uint64   `json:"checked"`
+	Bad      []uint64 `json:"bad"`
+	Repaired int      `json:"repaired"`
+	Evicted  int      `json:"evicted"`
+}
+
+// CacheEntry describes how a value is stored in the cache
+type CacheEntry struct {
+	Ordinal   uint64     `json:"ordinal"`
+	Size      int64      `json:"size"`                 // Bytes taken by the stored value
+	CreatedAt *time.Time `json:"created_at,omitempty"` // Only set by backends that record timestamps
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	// Value and Format are only set when a single entry is requested
+	Value  interface{}       `json:"value,omitempty"`
+	Number *fibonacci.Number `json:"-" codec:"value"`
+	Format string            `json:"format,omitempty"`
+}
+
+// CacheEntries is a page of cache entries in ascending ordinal order
+type CacheEntries struct {
+	Entries []CacheEntry `json:"entries"`
+	// Next is the from ordinal of the next page, it's unset after the last page
+	Next *uint64 `json:"next,omitempty"`
+}
+
+// CacheStats summarizes the contents of the cache
+type CacheStats struct {
+	Entries    uint64  `json:"entries"`
+	Tombstoned uint64  `json:"tombstoned"`
+	Bytes      int64   `json:"bytes"`
+	MaxOrdinal *uint64 `json:"max_ordinal,omitempty"` // Unset when the cache is empty
+}
+
+// JobStatus is a job and the URL of its result once it has succeeded
+type JobStatus struct {
+	jobs.Job
+	ResultURL string `json:"result_url,omitempty"`
+}
+
+// defaultCachePageSize and maxCachePageSize bound the entries listed per request
+const (
+	defaultCachePageSize = 100
+	maxCachePageSize     = 1000
+)
+
+// maxSafeInteger is the largest integer that JSON parsers using doubles read exactly
+var maxSafeInteger = fibonacci.NewNumber(1<<53 - 1)
+
+func registerV1(r *mux.Router, gen *fibonacci.Generator, jobManager *jobs.Manager, warm *warmer, lim limits) {
+	r.HandleFunc("/fibonacci/{ordinal}", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		ord, err := lim.parseOrdinal(vars["ordinal"])
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, errorCode(err), err.Error())
+			return
+		}
+		format, err := fibonacci.ParseNumberFormat(r.URL.Query().Get("format"))
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+
+		logging.FromContext(r.Context()).WithField("ordinal", ord).Debug("Calculating Fibonacci number")
+		ctx, cancel := lim.context(r)
+		defer cancel()
+		r = r.WithContext(ctx)
+		if wantsStream(r) {
+			streamValue(w, r, gen, ord, format, writeProblem)
+			return
+		}
+		value, cached, err := gen.Lookup(r.Context(), ord)
+		if err != nil {
+			status, code := computeError(err)
+			writeProblem(w, r, status, code, err.Error())
+			return
+		}
+		writeResponse(w, r, http.StatusOK, newValue(r, ord, value, format, cached))
+	}).Methods("GET")
+
+	r.HandleFunc("/fibonacci", func(w http.ResponseWriter, r *http.Request) {
+		format, err := fibonacci.ParseNumberFormat(r.URL.Query().Get("format"))
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+		ordinals, err := decodeBatch(r, lim)
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, errorCode(err), err.Error())
+			return
+		}
+		if !lim.access.allowBatch(w, r, ordinals, writeProblem) || !lim.rate.allowBatch(w, r, ordinals, writeProblem) {
+			return
+		}
+
+		logging.FromContext(r.Context()).WithField("ordinals", len(ordinals)).Debug("Calculating a batch of Fibonacci numbers")
+		ctx, cancel := lim.context(r)
+		defer cancel()
+		res := Batch{Values: []Value{}}
+		err = gen.Batch(ctx, ordinals, func(ordinal uint64, value *fibonacci.Number, cached bool) error {
+			res.Values = append(res.Values, newValue(r, ordinal, value, format, cached))
+			return nil
+		})
+		if err != nil {
+			status, code := computeError(err)
+			writeProblem(w, r, status, code, err.Error())
+			return
+		}
+		writeResponse(w, r, http.StatusOK, res)
+	}).Methods("POST")
+
+	r.HandleFunc("/sequence/{from}/{to}", calculateSequence(gen, lim, writeProblem, func(w http.ResponseWriter, r *http.Request, from uint64, to uint64, format fibonacci.NumberFormat, values []*fibonacci.Number) {
+		res := Sequence{
+			From:   from,
+			To:     to,
+			Format: format.String(),
+		}
+		if nativeNumbers(r, format) {
+			res.Numbers = values
+		} else {
+			for _, value := range values {
+				res.Values = append(res.Values, formatValue(value, format))
+			}
+		}
+		writeResponse(w, r, http.StatusOK, res)
+	})).Methods("GET")
+
+	r.HandleFunc("/count/{number}", countOrdinals(gen, lim, writeProblem, func(w http.ResponseWriter, r *http.Request, number *fibonacci.Number, count uint64) {
+		res := Count{
+			Number: formatValue(number, fibonacci.DecimalFormat),
+			Count:  count,
+		}
+		writeResponse(w, r, http.StatusOK, res)
+	})).Methods("GET")
+
+	r.HandleFunc("/cache", func(w http.ResponseWriter, r *http.Request) {
+		logging.FromContext(r.Context()).Info("Clearing the memoizer cache...")
+		if err := gen.ClearCache(); err != nil {
+			writeProblem(w, r, http.StatusServiceUnavailable, CodeBackendUnavailable, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods("DELETE")
+
+	r.HandleFunc("/cache/export", exportCache(gen, writeProblem)).Methods("GET")
+
+	r.HandleFunc("/cache/import", importCache(gen, writeProblem, func(w http.ResponseWriter, r *http.Request, count int) {
+		writeResponse(w, r, http.StatusOK, Import{Imported: count})
+	})).Methods("POST")
+
+	r.HandleFunc("/cache/verify", verifyCache(gen, writeProblem, func(w http.ResponseWriter, r *http.Request, result *cache.VerifyResult) {
+		writeResponse(w, r, http.StatusOK, newVerification(result))
+	})).Methods("POST")
+
+	r.HandleFunc("/cache/warm", startWarmUp(warm, writeProblem, func(w http.ResponseWriter, r *http.Request, state WarmUp) {
+		writeResponse(w, r, http.StatusAccepted, state)
+	})).Methods("POST")
+
+	r.HandleFunc("/cache/warm", func(w http.ResponseWriter, r *http.Request) {
+		writeResponse(w, r, http.StatusOK, warm.progress())
+	}).Methods("GET")
+
+	r.HandleFunc("/cache/entries", func(w http.ResponseWriter, r *http.Request) {
+		inspector, ok := gen.Cache().(cache.Inspector)
+		if !ok {
+			writeProblem(w, r, http.StatusNotImplemented, CodeNotImplemented, "the cache backend does not support listing entries")
+			return
+		}
+		from, limit, err := parseEntriesQuery(r)
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, errorCode(err), err.Error())
+			return
+		}
+		// One extra entry tells whether there's another page
+		entries, err := inspector.List(from, limit+1)
+		if err != nil {
+			writeProblem(w, r, http.StatusServiceUnavailable, CodeBackendUnavailable, err.Error())
+			return
+		}
+		res := CacheEntries{Entries: make([]CacheEntry, 0, len(entries))}
+		if len(entries) > limit {
+			res.Next = &entries[limit].Ordinal
+			entries = entries[:limit]
+		}
+		for _, entry := range entries {
+			res.Entries = append(res.Entries, newCacheEntry(&entry))
+		}
+		writeResponse(w, r, http.StatusOK, res)
+	}).Methods("GET")
+
+	r.HandleFunc("/cache/entries/{ordinal}", func(w http.ResponseWriter, r *http.Request) {
+		inspector, ok := gen.Cache().(cache.Inspector)
+		if !ok {
+			writeProblem(w, r, http.StatusNotImplemented, CodeNotImplemented, "the cache backend does not support listing entries")
+			return
+		}
+		ordinal, err := strconv.ParseUint(mux.Vars(r)["ordinal"], 10, 64)
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, CodeInvalidOrdinal, "failed to parse ordinal value")
+			return
+		}
+		format, err := fibonacci.ParseNumberFormat(r.URL.Query().Get("format"))
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+		info, err := inspector.Info(ordinal)
+		var value *fibonacci.Number
+		if err == nil {
+			value, err = gen.Cache().Read(ordinal)
+		}
+		if err == cache.ErrNotFound {
+			writeProblem(w, r, http.StatusNotFound, CodeNotFound, fmt.Sprintf("ordinal %d is not cached", ordinal))
+			return
+		}
+		if err != nil {
+			writeProblem(w, r, http.StatusServiceUnavailable, CodeBackendUnavailable, err.Error())
+			return
+		}
+		res := newCacheEntry(info)
+		res.Format = format.String()
+		if nativeNumbers(r, format) {
+			res.Number = value
+		} else {
+			res.Value = formatValue(value, format)
+		}
+		writeResponse(w, r, http.StatusOK, res)
+	}).Methods("GET")
+
+	r.HandleFunc("/cache/stats", func(w http.ResponseWriter, r *http.Request) {
+		inspector, ok := gen.Cache().(cache.Inspector)
+		if !ok {
+			writeProblem(w, r, http.StatusNotImplemented, CodeNotImplemented, "the cache backend does not support statistics")
+			return
+		}
+		stats, err := inspector.Stats()
+		if err != nil {
+			writeProblem(w, r, http.StatusServiceUnavailable, CodeBackendUnavailable, err.Error())
+			return
+		}
+		res := CacheStats{
+			Entries:    stats.Entries,
+			Tombstoned: stats.Tombstoned,
+			Bytes:      stats.Bytes,
+		}
+		if stats.Entries > 0 {
+			res.MaxOrdinal = &stats.MaxOrdinal
+		}
+		writeResponse(w, r, http.StatusOK, res)
+	}).Methods("GET")
+
+	r.HandleFunc("/jobs", submitJob(jobManager, lim, writeProblem, func(w http.ResponseWriter, r *http.Request, job *jobs.Job) {
+		w.Header().Set("Location", v1JobURL(job))
+		writeResponse(w, r, http.StatusAccepted, newJobStatus(job))
+	})).Methods("POST")
+
+	r.HandleFunc("/jobs/{id}", getJob(jobManager, writeProblem, func(w http.ResponseWriter, r *http.Request, job *jobs.Job) {
+		writeResponse(w, r, http.StatusOK, newJobStatus(job))
+	})).Methods("GET")
+
+	r.HandleFunc("/jobs/{id}/result", getJobResult(jobManager, writeProblem)).Methods("GET")
+
+	r.HandleFunc("/jobs/{id}", cancelJob(jobManager, writeProblem, func(w http.ResponseWriter, r *http.Request, job *jobs.Job) {
+		writeResponse(w, r, http.StatusOK, newJobStatus(job))
+	})).Methods("DELETE")
+
+	registerEvents(r, gen, jobManager, lim)
+}
+
+// newValue describes F(ordinal), the value is a native bignum when the encoding supports it
+func newValue(r *http.Request, ordinal uint64, value *fibonacci.Number, format fibonacci.NumberFormat, cached bool) Value {
+	res := Value{
+		Ordinal: ordinal,
+		Format:  format.String(),
+		Digits:  fibonacci.DecimalDigits(value),
+		Cached:  cached,
+	}
+	if nativeNumbers(r, format) {
+		res.Number = value
+	} else {
+		res.Value = formatValue(value, format)
+	}
+	return res
+}
+
+// formatValue renders a value for a JSON payload, decimal values are numbers when they're small enough
+func formatValue(v *fibonacci.Number, format fibonacci.NumberFormat) interface{} {
+	if format.Kind == fibonacci.FormatDecimal && v.CmpAbs(maxSafeInteger) <= 0 {
+		return json.Number(v.String())
+	}
+	return format.Text(v)
+}
+
+// newVerification converts the result of cache.Verify
+func newVerification(result *cache.VerifyResult) Verification {
+	return Verification{
+		Checked:  result.Checked,
+		Bad:      result.Bad,
+		Repaired: result.Repaired,
+		Evicted:  result.Evicted,
+	}
+}
+
+// newCacheEntry converts a cache.EntryInfo, timestamps are left out when the backend doesn't record them
+func newCacheEntry(info *cache.EntryInfo) CacheEntry {
+	res := CacheEntry{
+		Ordinal: info.Ordinal,
+		Size:    info.Size,
+	}
+	if !info.CreatedAt.IsZero() {
+		createdAt := info.CreatedAt.UTC()
+		res.CreatedAt = &createdAt
+	}
+	if !info.UpdatedAt.IsZero() {
+		updatedAt := info.UpdatedAt.UTC()
+		res.UpdatedAt = &updatedAt
+	}
+	return res
+}
+
+// parseEntriesQuery parses the first ordinal and the size of a page of cache entries, both are optional
+func parseEntriesQuery(r *http.Request) (uint64, int, error) {
+	query := r.URL.Query()
+	from := uint64(0)
+	if v := query.Get("from"); v != "" {
+		var err error
+		if from, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return 0, 0, &requestError{code: CodeInvalidOrdinal, message: "failed to parse the from ordinal"}
+		}
+	}
+	limit := defaultCachePageSize
+	if v := query.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxCachePageSize {
+			return 0, 0, fmt.Errorf("the limit must be between 1 and %d", maxCachePageSize)
+		}
+	}
+	return from, limit, nil
+}
+
+// v1JobURL is the status URL of a job in the /v1 namespace
+func v1JobURL(job *jobs.Job) string {
+	return "/v1/jobs/" + job.ID
+}
+
+// newJobStatus describes a job, the result URL is only set once there's a result to fetch
+func newJobStatus(job *jobs.Job) JobStatus {
+	res := JobStatus{Job: *job}
+	if job.State == jobs.StateSucceeded {
+		res.ResultURL = v1JobURL(job) + "/result"
+	}
+	return res
+}
@@ -0,0 +1,115 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/programmablemike/fibo/internal/codec"
+	"github.com/stretchr/testify/assert"
+)
+
+// serve sends a request to the router and decodes the JSON response into a generic map
+func serve(t *testing.T, h http.Handler, method string, path string) (int, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	var body map[string]interface{}
+	if w.Body.Len() > 0 {
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body), w.Body.String())
+	}
+	return w.Code, body
+}
+
+func TestV1Calculate(t *testing.T) {
+	r := newTestRouter()
+
+	status, body := serve(t, r, "GET", "/v1/fibonacci/10")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, float64(55), body["value"]) // A JSON number
+	assert.Equal(t, float64(2), body["digits"])
+	assert.Equal(t, "decimal", body["format"])
+	assert.Equal(t, false, body["cached"])
+
+	status, body = serve(t, r, "GET", "/v1/fibonacci/10")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, true, body["cached"])
+
+	// F(78) is the largest value below 2^53
+	_, body = serve(t, r, "GET", "/v1/fibonacci/78")
+	assert.Equal(t, float64(8944394323791464), body["value"])
+	_, body = serve(t, r, "GET", "/v1/fibonacci/100")
+	assert.Equal(t, "354224848179261915075", body["value"])
+	assert.Equal(t, float64(21), body["digits"])
+	_, body = serve(t, r, "GET", "/v1/fibonacci/10?format=hex")
+	assert.Equal(t, "37", body["value"])
+
+	status, body = serve(t, r, "GET", "/v1/fibonacci/x")
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, map[string]interface{}{
+		"type":   "urn:fibo:error:invalid_ordinal",
+		"title":  "Invalid ordinal",
+		"status": float64(http.StatusBadRequest),
+		"detail": "failed to parse ordinal value",
+		"code":   "invalid_ordinal",
+	}, body)
+}
+
+func TestV1CalculateNativeNumbers(t *testing.T) {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/v1/fibonacci/100", nil)
+	req.Header.Set("Accept", codec.CBOR.ContentType())
+	newTestRouter().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var v Value
+	assert.NoError(t, codec.Unmarshal(codec.CBOR, w.Body.Bytes(), &v))
+	assert.Equal(t, "354224848179261915075", fmt.Sprint(v.Value))
+	assert.Equal(t, 21, v.Digits)
+}
+
+func TestV1Sequence(t *testing.T) {
+	r := newTestRouter()
+	status, body := serve(t, r, "GET", "/v1/sequence/77/80")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []interface{}{
+		float64(5527939700884757),
+		float64(8944394323791464),
+		"14472334024676221",
+		"23416728348467685",
+	}, body["values"])
+
+	status, _ = serve(t, r, "GET", "/v1/sequence/10/5")
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestV1Count(t *testing.T) {
+	r := newTestRouter()
+	status, body := serve(t, r, "GET", "/v1/count/100")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, float64(100), body["number"])
+	assert.Equal(t, float64(12), body["count"])
+
+	status, body = serve(t, r, "GET", "/v1/count/-1")
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "invalid_request", body["code"])
+}
+
+func TestV1ClearCache(t *testing.T) {
+	r := newTestRouter()
+	serve(t, r, "GET", "/v1/fibonacci/10")
+	status, body := serve(t, r, "DELETE", "/v1/cache")
+	assert.Equal(t, http.StatusNoContent, status)
+	assert.Nil(t, body)
+	_, body = serve(t, r, "GET", "/v1/fibonacci/10")
+	assert.Equal(t, false, body["cached"])
+}
+
+func TestV1Jobs(t *testing.T) {
+	r := newTestRouter()
+	status, body := serve(t, r, "GET", "/v1/jobs/unknown")
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, "not_found", body["code"])
+	assert.Equal(t, "job not found", body["detail"])
+}
@@ -0,0 +1,85 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/programmablemike/fibo/internal/fibonacci"
+	log "github.com/sirupsen/logrus"
+)
+
+// WarmResponse reports the progress of a cache warm-up
+type WarmResponse struct {
+	GenericResponse
+	WarmUp
+}
+
+// WarmUp is the progress of a cache warm-up
+type WarmUp struct {
+	Running  bool       `json:"running"`
+	To       uint64     `json:"to"`
+	Step     uint64     `json:"step"`
+	Done     uint64     `json:"done"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// warmer runs a single cache warm-up at a time in the background and tracks its progress
+type warmer struct {
+	mu      sync.Mutex
+	gen     *fibonacci.Generator
+	tracker *Tracker // Cancels the warm-up on shutdown
+	state   WarmUp
+}
+
+func newWarmer(gen *fibonacci.Generator, tracker *Tracker) *warmer {
+	return &warmer{gen: gen, tracker: tracker}
+}
+
+// start begins warming the cache unless a warm-up is already running
+func (wm *warmer) start(to uint64, step uint64) (WarmUp, error) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	if wm.state.Running {
+		return wm.state, fmt.Errorf("a cache warm-up is already running")
+	}
+	now := time.Now()
+	wm.state = WarmUp{
+		Running: true,
+		To:      to,
+		Step:    step,
+		Started: &now,
+	}
+
+	wm.tracker.Go(func(ctx context.Context) {
+		log.Infof("Warming the memoizer cache up to ordinal=%s (step=%s)...", fibonacci.Uint64ToString(to), fibonacci.Uint64ToString(step))
+		err := wm.gen.Warm(ctx, to, step, func(done uint64) {
+			wm.mu.Lock()
+			wm.state.Done = done
+			wm.mu.Unlock()
+		})
+
+		wm.mu.Lock()
+		defer wm.mu.Unlock()
+		finished := time.Now()
+		wm.state.Running = false
+		wm.state.Finished = &finished
+		if err != nil {
+			log.Errorf("Failed to warm the cache: %s", err)
+			wm.state.Error = err.Error()
+			return
+		}
+		log.Infof("Warmed the memoizer cache up to ordinal=%s in %s.", fibonacci.Uint64ToString(to), finished.Sub(now))
+	})
+	return wm.state, nil
+}
+
+// progress returns a snapshot of the current (or last) warm-up
+func (wm *warmer) progress() WarmUp {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	return wm.state
+}
@@ -0,0 +1,102 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/programmablemike/fibo/internal/auth"
+	"github.com/programmablemike/fibo/internal/rpc/fibopb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// methodScopes are the scopes of the methods that need more than auth.ScopeRead
+var methodScopes = map[string]auth.Scope{
+	"/fibo.v1.Fibo/ClearCache": auth.ScopeAdmin,
+}
+
+// authOptions returns the interceptors that check the credentials of the calls
+// Like the HTTP API, calls send an "authorization: Bearer TOKEN" or an "x-api-key" metadata entry.
+func authOptions(a *auth.Authenticator) []grpc.ServerOption {
+	if !a.Enabled() {
+		return nil
+	}
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			p, err := authorize(ctx, a, info.FullMethod, req)
+			if err != nil {
+				return nil, err
+			}
+			return handler(auth.NewContext(ctx, p), req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			return handler(srv, &authorizedStream{ServerStream: ss, auth: a, method: info.FullMethod})
+		}),
+	}
+}
+
+// authorize authenticates a call and checks the scope of its method and request
+func authorize(ctx context.Context, a *auth.Authenticator, method string, req interface{}) (*auth.Principal, error) {
+	p, err := a.Authenticate(callToken(ctx))
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	scope, ok := methodScopes[method]
+	if !ok {
+		scope = auth.ScopeRead
+		switch req := req.(type) {
+		case *fibopb.CalculateRequest:
+			scope = a.OrdinalScope(req.Ordinal)
+		case *fibopb.SequenceRequest:
+			scope = a.OrdinalScope(req.To)
+		}
+	}
+	if p.Allows(scope) {
+		return p, nil
+	}
+	if p.Anonymous() {
+		return nil, status.Errorf(codes.Unauthenticated, "an API key with the %s scope is required", scope)
+	}
+	return nil, status.Error(codes.PermissionDenied, fmt.Sprintf("the API key %s lacks the %s scope", p.Name, scope))
+}
+
+// callToken returns the token of the metadata of a call
+func callToken(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	return auth.Token(first("authorization"), first("x-api-key"))
+}
+
+// authorizedStream checks the request of a server streaming call once it has been received
+type authorizedStream struct {
+	grpc.ServerStream
+	auth   *auth.Authenticator
+	method string
+	ctx    context.Context
+}
+
+func (s *authorizedStream) Context() context.Context {
+	if s.ctx != nil {
+		return s.ctx
+	}
+	return s.ServerStream.Context()
+}
+
+func (s *authorizedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	p, err := authorize(s.ServerStream.Context(), s.auth, s.method, m)
+	if err != nil {
+		return err
+	}
+	s.ctx = auth.NewContext(s.ServerStream.Context(), p)
+	return nil
+}
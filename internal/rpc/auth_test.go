@@ -0,0 +1,53 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/programmablemike/fibo/internal/auth"
+	"github.com/programmablemike/fibo/internal/fibonacci/fibotest"
+	"github.com/programmablemike/fibo/internal/rpc/fibopb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuth(t *testing.T) {
+	a, err := auth.New(auth.Config{
+		Keys: []auth.Key{
+			{Name: "ci", Hash: auth.HashToken("reader"), Scopes: []auth.Scope{auth.ScopeRead}},
+			{Name: "ops", Hash: auth.HashToken("admin"), Scopes: []auth.Scope{auth.ScopeAdmin}},
+		},
+		LargeOrdinal: 1000,
+	})
+	assert.NoError(t, err)
+	client := newAuthTestClient(t, fibotest.NewMemoryCache(), a, nil)
+	withToken := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	}
+
+	_, err = client.Calculate(context.Background(), &fibopb.CalculateRequest{Ordinal: 10})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.Calculate(withToken("wrong"), &fibopb.CalculateRequest{Ordinal: 10})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.Calculate(withToken("reader"), &fibopb.CalculateRequest{Ordinal: 10})
+	assert.NoError(t, err)
+	_, err = client.Calculate(withToken("reader"), &fibopb.CalculateRequest{Ordinal: 1001})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.ClearCache(withToken("reader"), &fibopb.ClearCacheRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.ClearCache(metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "admin"), &fibopb.ClearCacheRequest{})
+	assert.NoError(t, err)
+
+	// Streams are checked once the request is received
+	stream, err := client.Sequence(withToken("reader"), &fibopb.SequenceRequest{From: 999, To: 1001})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	stream, err = client.Sequence(withToken("admin"), &fibopb.SequenceRequest{From: 999, To: 1001})
+	assert.NoError(t, err)
+	v, err := stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(999), v.GetOrdinal())
+}
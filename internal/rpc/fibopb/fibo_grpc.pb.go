@@ -0,0 +1,245 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package fibopb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// FiboClient is the client API for Fibo service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type FiboClient interface {
+	// Calculate returns the Fibonacci value for an ordinal
+	Calculate(ctx context.Context, in *CalculateRequest, opts ...grpc.CallOption) (*Value, error)
+	// Sequence streams the Fibonacci values for every ordinal in a range, one message per value
+	Sequence(ctx context.Context, in *SequenceRequest, opts ...grpc.CallOption) (Fibo_SequenceClient, error)
+	// Count returns the number of ordinals whose values are in the range [0, number]
+	Count(ctx context.Context, in *CountRequest, opts ...grpc.CallOption) (*CountResponse, error)
+	// ClearCache wipes the memoizer cache
+	ClearCache(ctx context.Context, in *ClearCacheRequest, opts ...grpc.CallOption) (*ClearCacheResponse, error)
+}
+
+type fiboClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFiboClient(cc grpc.ClientConnInterface) FiboClient {
+	return &fiboClient{cc}
+}
+
+func (c *fiboClient) Calculate(ctx context.Context, in *CalculateRequest, opts ...grpc.CallOption) (*Value, error) {
+	out := new(Value)
+	err := c.cc.Invoke(ctx, "/fibo.v1.Fibo/Calculate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fiboClient) Sequence(ctx context.Context, in *SequenceRequest, opts ...grpc.CallOption) (Fibo_SequenceClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Fibo_ServiceDesc.Streams[0], "/fibo.v1.Fibo/Sequence", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fiboSequenceClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Fibo_SequenceClient interface {
+	Recv() (*Value, error)
+	grpc.ClientStream
+}
+
+type fiboSequenceClient struct {
+	grpc.ClientStream
+}
+
+func (x *fiboSequenceClient) Recv() (*Value, error) {
+	m := new(Value)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fiboClient) Count(ctx context.Context, in *CountRequest, opts ...grpc.CallOption) (*CountResponse, error) {
+	out := new(CountResponse)
+	err := c.cc.Invoke(ctx, "/fibo.v1.Fibo/Count", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fiboClient) ClearCache(ctx context.Context, in *ClearCacheRequest, opts ...grpc.CallOption) (*ClearCacheResponse, error) {
+	out := new(ClearCacheResponse)
+	err := c.cc.Invoke(ctx, "/fibo.v1.Fibo/ClearCache", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// FiboServer is the server API for Fibo service.
+// All implementations must embed UnimplementedFiboServer
+// for forward compatibility
+type FiboServer interface {
+	// Calculate returns the Fibonacci value for an ordinal
+	Calculate(context.Context, *CalculateRequest) (*Value, error)
+	// Sequence streams the Fibonacci values for every ordinal in a range, one message per value
+	Sequence(*SequenceRequest, Fibo_SequenceServer) error
+	// Count returns the number of ordinals whose values are in the range [0, number]
+	Count(context.Context, *CountRequest) (*CountResponse, error)
+	// ClearCache wipes the memoizer cache
+	ClearCache(context.Context, *ClearCacheRequest) (*ClearCacheResponse, error)
+	mustEmbedUnimplementedFiboServer()
+}
+
+// UnimplementedFiboServer must be embedded to have forward compatible implementations.
+type UnimplementedFiboServer struct {
+}
+
+func (UnimplementedFiboServer) Calculate(context.Context, *CalculateRequest) (*Value, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Calculate not implemented")
+}
+func (UnimplementedFiboServer) Sequence(*SequenceRequest, Fibo_SequenceServer) error {
+	return status.Errorf(codes.Unimplemented, "method Sequence not implemented")
+}
+func (UnimplementedFiboServer) Count(context.Context, *CountRequest) (*CountResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Count not implemented")
+}
+func (UnimplementedFiboServer) ClearCache(context.Context, *ClearCacheRequest) (*ClearCacheResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ClearCache not implemented")
+}
+func (UnimplementedFiboServer) mustEmbedUnimplementedFiboServer() {}
+
+// UnsafeFiboServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FiboServer will
+// result in compilation errors.
+type UnsafeFiboServer interface {
+	mustEmbedUnimplementedFiboServer()
+}
+
+func RegisterFiboServer(s grpc.ServiceRegistrar, srv FiboServer) {
+	s.RegisterService(&Fibo_ServiceDesc, srv)
+}
+
+func _Fibo_Calculate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CalculateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FiboServer).Calculate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fibo.v1.Fibo/Calculate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FiboServer).Calculate(ctx, req.(*CalculateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Fibo_Sequence_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SequenceRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FiboServer).Sequence(m, &fiboSequenceServer{stream})
+}
+
+type Fibo_SequenceServer interface {
+	Send(*Value) error
+	grpc.ServerStream
+}
+
+type fiboSequenceServer struct {
+	grpc.ServerStream
+}
+
+func (x *fiboSequenceServer) Send(m *Value) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Fibo_Count_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CountRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FiboServer).Count(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fibo.v1.Fibo/Count",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FiboServer).Count(ctx, req.(*CountRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Fibo_ClearCache_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClearCacheRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FiboServer).ClearCache(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fibo.v1.Fibo/ClearCache",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FiboServer).ClearCache(ctx, req.(*ClearCacheRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Fibo_ServiceDesc is the grpc.ServiceDesc for Fibo service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Fibo_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "fibo.v1.Fibo",
+	HandlerType: (*FiboServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Calculate",
+			Handler:    _Fibo_Calculate_Handler,
+		},
+		{
+			MethodName: "Count",
+			Handler:    _Fibo_Count_Handler,
+		},
+		{
+			MethodName: "ClearCache",
+			Handler:    _Fibo_ClearCache_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Sequence",
+			Handler:       _Fibo_Sequence_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "fibopb/fibo.proto",
+}
@@ -0,0 +1,13 @@
+package fibopb
+
+import "math/big"
+
+// NewNumber converts a non-negative big.Int into its protobuf representation
+func NewNumber(v *big.Int) *Number {
+	return &Number{Magnitude: v.Bytes()}
+}
+
+// BigInt converts the number back into a big.Int, a nil number is zero
+func (x *Number) BigInt() *big.Int {
+	return new(big.Int).SetBytes(x.GetMagnitude())
+}
@@ -0,0 +1,90 @@
+package rpc
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/programmablemike/fibo/internal/logging"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// requestIDKey is the metadata key of the request IDs, like the HTTP header
+var requestIDKey = strings.ToLower(logging.RequestIDHeader)
+
+// loggingOptions returns the interceptors that assign every call a request ID and write the access log
+// Like the HTTP API, the ID is taken from the x-request-id metadata of the caller when it's valid
+// and is echoed in the response header. The handlers get a logger with the request ID from
+// logging.FromContext.
+func loggingOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			id := callRequestID(ctx)
+			grpc.SetHeader(ctx, metadata.Pairs(requestIDKey, id))
+			ctx, logger := callLogger(ctx, id)
+			start := time.Now()
+			resp, err := handler(ctx, req)
+			logCall(logger, info.FullMethod, start, err)
+			return resp, err
+		}),
+		grpc.ChainStreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			id := callRequestID(ss.Context())
+			ss.SetHeader(metadata.Pairs(requestIDKey, id))
+			ctx, logger := callLogger(ss.Context(), id)
+			start := time.Now()
+			err := handler(srv, &loggedStream{ServerStream: ss, ctx: ctx})
+			logCall(logger, info.FullMethod, start, err)
+			return err
+		}),
+	}
+}
+
+// callRequestID returns the request ID sent by the caller when it's valid, or a new one
+func callRequestID(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(requestIDKey); len(v) > 0 {
+		return logging.RequestID(v[0])
+	}
+	return logging.RequestID("")
+}
+
+// callLogger returns a copy of ctx carrying a logger with the request ID of the call
+func callLogger(ctx context.Context, id string) (context.Context, *log.Entry) {
+	fields := log.Fields{"request_id": id}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		fields["trace_id"] = sc.TraceID().String()
+	}
+	logger := logging.FromContext(ctx).WithFields(fields)
+	return logging.NewContext(ctx, logger), logger
+}
+
+// logCall writes the access log entry of a call, server errors are logged as errors
+func logCall(logger *log.Entry, method string, start time.Time, err error) {
+	code := status.Code(err)
+	entry := logger.WithFields(log.Fields{
+		"method":      method,
+		"code":        code.String(),
+		"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+	})
+	switch code {
+	case codes.Unknown, codes.Internal, codes.DataLoss, codes.Unimplemented:
+		entry.Error("Call failed")
+	default:
+		entry.Info("Call handled")
+	}
+}
+
+// loggedStream carries the logger of a server streaming call in its context
+type loggedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *loggedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,67 @@
+package rpc
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/programmablemike/fibo/internal/fibonacci"
+	"github.com/programmablemike/fibo/internal/fibonacci/fibotest"
+	"github.com/programmablemike/fibo/internal/rpc/fibopb"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestLogging(t *testing.T) {
+	hook := test.NewGlobal()
+	level := log.GetLevel()
+	log.SetLevel(log.DebugLevel)
+	t.Cleanup(func() {
+		log.SetLevel(level)
+		hook.Reset()
+	})
+	client := newTestClient(t, fibotest.NewMemoryCache())
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "abc-1")
+	var header metadata.MD
+	_, err := client.Count(ctx, &fibopb.CountRequest{Number: fibopb.NewNumber(fibonacci.NewNumber(10))}, grpc.Header(&header))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"abc-1"}, header.Get("x-request-id"))
+	// The generator logs with the logger of the call too
+	entries := hook.AllEntries()
+	if assert.Len(t, entries, 3) {
+		assert.Equal(t, log.Fields{"request_id": "abc-1", "bits": 4}, entries[0].Data)
+		assert.Equal(t, "abc-1", entries[1].Data["request_id"])
+		assert.Equal(t, "Call handled", entries[2].Message)
+		assert.Equal(t, "abc-1", entries[2].Data["request_id"])
+		assert.Equal(t, "/fibo.v1.Fibo/Count", entries[2].Data["method"])
+		assert.Equal(t, "OK", entries[2].Data["code"])
+	}
+
+	// Invalid IDs are replaced
+	hook.Reset()
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "not valid")
+	stream, err := client.Sequence(ctx, &fibopb.SequenceRequest{From: 1, To: 3})
+	assert.NoError(t, err)
+	for {
+		if _, err := stream.Recv(); err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
+		}
+	}
+	header, err = stream.Header()
+	assert.NoError(t, err)
+	id := header.Get("x-request-id")
+	if assert.Len(t, id, 1) {
+		assert.Len(t, id[0], 32)
+		entries = hook.AllEntries()
+		if assert.Len(t, entries, 2) {
+			assert.Equal(t, log.Fields{"request_id": id[0], "from": uint64(1), "to": uint64(3)}, entries[0].Data)
+			assert.Equal(t, id[0], entries[1].Data["request_id"])
+			assert.Equal(t, "/fibo.v1.Fibo/Sequence", entries[1].Data["method"])
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+
+	"github.com/programmablemike/fibo/internal/auth"
+	"github.com/programmablemike/fibo/internal/ratelimit"
+	"github.com/programmablemike/fibo/internal/rpc/fibopb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// rateLimitOptions returns the interceptors that charge the calls to the limiter of the HTTP API
+// Calls cost the same tokens as the HTTP requests asking for the same values and take them from the
+// same buckets, the methods can have their own limit under their full name.
+func rateLimitOptions(l *ratelimit.Limiter) []grpc.ServerOption {
+	if !l.Enabled() {
+		return nil
+	}
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := take(ctx, l, info.FullMethod, req); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			return handler(srv, &limitedStream{ServerStream: ss, limiter: l, method: info.FullMethod})
+		}),
+	}
+}
+
+// take charges a call for the values of its request, or returns a ResourceExhausted error with a
+// retry-after trailer
+func take(ctx context.Context, l *ratelimit.Limiter, method string, req interface{}) error {
+	digits := 0.0
+	switch req := req.(type) {
+	case *fibopb.CalculateRequest:
+		digits = ratelimit.EstimateDigits(req.Ordinal)
+	case *fibopb.SequenceRequest:
+		// Streams aren't capped so they're charged for the whole range
+		digits = ratelimit.RangeDigits(req.From, req.To)
+	case *fibopb.CountRequest:
+		// Like the HTTP route, counting is charged for the digits of the number
+		digits = float64(len(req.GetNumber().GetMagnitude())) * math.Log10(256)
+	}
+	wait, ok := l.Take(callClient(ctx), method, 1+l.Tokens(digits))
+	if ok {
+		return nil
+	}
+	retry := int(math.Ceil(wait.Seconds()))
+	grpc.SetTrailer(ctx, metadata.Pairs("retry-after", strconv.Itoa(retry)))
+	return status.Error(codes.ResourceExhausted, fmt.Sprintf("too many requests, retry in %d seconds", retry))
+}
+
+// callClient identifies the client of a call like the HTTP API, by its API key or its IP address
+func callClient(ctx context.Context) string {
+	if p := auth.FromContext(ctx); p != nil && !p.Anonymous() {
+		return "key:" + p.Name
+	}
+	pr, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(pr.Addr.String())
+	if err != nil {
+		return pr.Addr.String()
+	}
+	return host
+}
+
+// limitedStream charges a server streaming call once its request has been received
+type limitedStream struct {
+	grpc.ServerStream
+	limiter *ratelimit.Limiter
+	method  string
+}
+
+func (s *limitedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return take(s.Context(), s.limiter, s.method, m)
+}
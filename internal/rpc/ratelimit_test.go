@@ -0,0 +1,43 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/programmablemike/fibo/internal/fibonacci/fibotest"
+	"github.com/programmablemike/fibo/internal/ratelimit"
+	"github.com/programmablemike/fibo/internal/rpc/fibopb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestRateLimit(t *testing.T) {
+	l := ratelimit.New(ratelimit.RateLimit{Rate: 1, Burst: 3}, map[string]ratelimit.RateLimit{
+		"/fibo.v1.Fibo/Sequence": {Rate: 1, Burst: 10},
+	}, 100)
+	client := newAuthTestClient(t, fibotest.NewMemoryCache(), nil, l)
+	ctx := context.Background()
+
+	// F(10,000) costs 1 token plus 20.9 for its 2,090 digits, which leaves the bucket 18.9 tokens in debt
+	_, err := client.Calculate(ctx, &fibopb.CalculateRequest{Ordinal: 10000})
+	assert.NoError(t, err)
+	var trailer metadata.MD
+	_, err = client.Calculate(ctx, &fibopb.CalculateRequest{Ordinal: 0}, grpc.Trailer(&trailer))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, []string{"20"}, trailer.Get("retry-after"))
+
+	// Streams are charged for their whole range once the request is received
+	stream, err := client.Sequence(ctx, &fibopb.SequenceRequest{From: 0, To: 1000})
+	assert.NoError(t, err)
+	v, err := stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), v.GetOrdinal())
+	stream, err = client.Sequence(ctx, &fibopb.SequenceRequest{From: 0, To: 1})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, []string{"1038"}, stream.Trailer().Get("retry-after"))
+}
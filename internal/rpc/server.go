@@ -0,0 +1,110 @@
+// The gRPC service for the fibo server
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative fibopb/fibo.proto
+
+import (
+	"context"
+
+	"github.com/programmablemike/fibo/internal/auth"
+	"github.com/programmablemike/fibo/internal/fibonacci"
+	"github.com/programmablemike/fibo/internal/logging"
+	"github.com/programmablemike/fibo/internal/ratelimit"
+	"github.com/programmablemike/fibo/internal/rpc/fibopb"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// NewServer creates a gRPC server with the Fibo service and server reflection registered
+// A nil authenticator lets every call through and a nil limiter doesn't limit the rate of calls.
+func NewServer(gen *fibonacci.Generator, authenticator *auth.Authenticator, limiter *ratelimit.Limiter, opts ...grpc.ServerOption) *grpc.Server {
+	interceptors := append(loggingOptions(), authOptions(authenticator)...)
+	interceptors = append(interceptors, rateLimitOptions(limiter)...)
+	s := grpc.NewServer(append(interceptors, opts...)...)
+	fibopb.RegisterFiboServer(s, &service{gen: gen})
+	reflection.Register(s)
+	return s
+}
+
+// service implements the same operations as the HTTP router
+type service struct {
+	fibopb.UnimplementedFiboServer
+	gen *fibonacci.Generator
+}
+
+func (s *service) Calculate(ctx context.Context, req *fibopb.CalculateRequest) (*fibopb.Value, error) {
+	format, err := fibonacci.ParseNumberFormat(req.Format)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	logging.FromContext(ctx).WithField("ordinal", req.Ordinal).Debug("Calculating Fibonacci number")
+	value, err := s.gen.ComputeContext(ctx, req.Ordinal, nil)
+	if err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+	return newValue(req.Ordinal, value, req.Format, format), nil
+}
+
+// Sequence sends the values as they're generated so ranges aren't limited like the HTTP endpoint
+func (s *service) Sequence(req *fibopb.SequenceRequest, stream fibopb.Fibo_SequenceServer) error {
+	if req.To < req.From {
+		return status.Error(codes.InvalidArgument, "the to ordinal is smaller than the from ordinal")
+	}
+	format, err := fibonacci.ParseNumberFormat(req.Format)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	logging.FromContext(stream.Context()).WithFields(log.Fields{"from": req.From, "to": req.To}).Debug("Calculating Fibonacci sequence")
+	var sendErr error
+	err = s.gen.Sequence(stream.Context(), req.From, req.To, func(ordinal uint64, value *fibonacci.Number) error {
+		sendErr = stream.Send(newValue(ordinal, value, req.Format, format))
+		return sendErr
+	})
+	switch {
+	case err == nil:
+		return nil
+	case err == sendErr:
+		return err // Already a status error
+	default:
+		return status.FromContextError(err).Err()
+	}
+}
+
+func (s *service) Count(ctx context.Context, req *fibopb.CountRequest) (*fibopb.CountResponse, error) {
+	if req.Number == nil {
+		return nil, status.Error(codes.InvalidArgument, "number is required")
+	}
+	number := req.Number.BigInt()
+
+	logging.FromContext(ctx).WithField("bits", number.BitLen()).Debug("Counting ordinals")
+	count, err := s.gen.CountOrdinalsContext(ctx, fibonacci.NewNumber(0), number, nil)
+	if err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+	return &fibopb.CountResponse{Count: count}, nil
+}
+
+func (s *service) ClearCache(ctx context.Context, req *fibopb.ClearCacheRequest) (*fibopb.ClearCacheResponse, error) {
+	logging.FromContext(ctx).Info("Clearing the memoizer cache...")
+	if err := s.gen.ClearCache(); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &fibopb.ClearCacheResponse{}, nil
+}
+
+// newValue converts a value for the wire, the text is only filled in when a format was requested
+func newValue(ordinal uint64, value *fibonacci.Number, spec string, format fibonacci.NumberFormat) *fibopb.Value {
+	v := &fibopb.Value{
+		Ordinal: ordinal,
+		Number:  fibopb.NewNumber(value),
+	}
+	if spec != "" {
+		v.Text = format.Text(value)
+	}
+	return v
+}
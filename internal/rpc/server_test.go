@@ -0,0 +1,121 @@
+package rpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/programmablemike/fibo/internal/auth"
+	"github.com/programmablemike/fibo/internal/fibonacci"
+	"github.com/programmablemike/fibo/internal/fibonacci/fibotest"
+	"github.com/programmablemike/fibo/internal/ratelimit"
+	"github.com/programmablemike/fibo/internal/rpc/fibopb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestClient serves the service over an in-memory listener
+func newTestClient(t *testing.T, c fibonacci.Memoizer) fibopb.FiboClient {
+	return newAuthTestClient(t, c, nil, nil)
+}
+
+// newAuthTestClient serves the service over an in-memory listener with the authenticator and limiter
+func newAuthTestClient(t *testing.T, c fibonacci.Memoizer, a *auth.Authenticator, l *ratelimit.Limiter) fibopb.FiboClient {
+	lis := bufconn.Listen(1 << 20)
+	s := NewServer(fibonacci.NewGenerator(c), a, l)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return fibopb.NewFiboClient(conn)
+}
+
+func TestCalculate(t *testing.T) {
+	client := newTestClient(t, fibotest.NewMemoryCache())
+	ctx := context.Background()
+
+	v, err := client.Calculate(ctx, &fibopb.CalculateRequest{Ordinal: 100})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(100), v.Ordinal)
+	assert.Equal(t, "354224848179261915075", v.Number.BigInt().String())
+	assert.Empty(t, v.Text)
+
+	v, err = client.Calculate(ctx, &fibopb.CalculateRequest{Ordinal: 100, Format: "hex"})
+	assert.NoError(t, err)
+	assert.Equal(t, "1333db76a7c594bfc3", v.Text)
+
+	v, err = client.Calculate(ctx, &fibopb.CalculateRequest{Ordinal: 0})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), v.Number.BigInt().Int64())
+
+	_, err = client.Calculate(ctx, &fibopb.CalculateRequest{Ordinal: 1, Format: "nope"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestSequence(t *testing.T) {
+	client := newTestClient(t, fibotest.NewMemoryCache())
+	ctx := context.Background()
+
+	stream, err := client.Sequence(ctx, &fibopb.SequenceRequest{From: 5, To: 10})
+	assert.NoError(t, err)
+	var ordinals []uint64
+	var values []int64
+	for {
+		v, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err) {
+			return
+		}
+		ordinals = append(ordinals, v.Ordinal)
+		values = append(values, v.Number.BigInt().Int64())
+	}
+	assert.Equal(t, []uint64{5, 6, 7, 8, 9, 10}, ordinals)
+	assert.Equal(t, []int64{5, 8, 13, 21, 34, 55}, values)
+
+	stream, err = client.Sequence(ctx, &fibopb.SequenceRequest{From: 10, To: 5})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestCount(t *testing.T) {
+	client := newTestClient(t, fibotest.NewMemoryCache())
+	ctx := context.Background()
+
+	// 0, 1, 1, 2, 3, 5 and 8
+	res, err := client.Count(ctx, &fibopb.CountRequest{Number: fibopb.NewNumber(fibonacci.NewNumber(10))})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(7), res.Count)
+
+	_, err = client.Count(ctx, &fibopb.CountRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestClearCache(t *testing.T) {
+	c := fibotest.NewMemoryCache()
+	client := newTestClient(t, c)
+	ctx := context.Background()
+
+	_, err := client.Calculate(ctx, &fibopb.CalculateRequest{Ordinal: 20})
+	assert.NoError(t, err)
+	_, err = c.Read(20)
+	assert.NoError(t, err)
+
+	_, err = client.ClearCache(ctx, &fibopb.ClearCacheRequest{})
+	assert.NoError(t, err)
+	_, err = c.Read(20)
+	assert.Error(t, err)
+}
@@ -0,0 +1,187 @@
+// Builds the TLS configurations of the servers and of the CLI, server certificates are reloaded
+// when their files change
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ServerOptions are the files of the server certificate and of the CAs of the client certificates
+type ServerOptions struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile turns on mutual TLS, clients need a certificate signed by one of its CAs
+	ClientCAFile string
+}
+
+// Enabled returns true if a certificate was configured
+func (o ServerOptions) Enabled() bool {
+	return o.CertFile != "" || o.KeyFile != ""
+}
+
+// Reloader serves the latest certificate and client CAs read from the files of the options
+type Reloader struct {
+	opts ServerOptions
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time // Modification times of the files when they were last read
+}
+
+// NewReloader reads the files of the options
+func NewReloader(opts ServerOptions) (*Reloader, error) {
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, errors.New("both a TLS certificate and a key are needed")
+	}
+	r := &Reloader{opts: opts}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// files lists the files of the options
+func (r *Reloader) files() []string {
+	files := []string{r.opts.CertFile, r.opts.KeyFile}
+	if r.opts.ClientCAFile != "" {
+		files = append(files, r.opts.ClientCAFile)
+	}
+	return files
+}
+
+// Reload reads the files again, the previous certificate is kept when they're invalid
+func (r *Reloader) Reload() error {
+	modTimes := map[string]time.Time{}
+	for _, name := range r.files() {
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		modTimes[name] = info.ModTime()
+	}
+	cert, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load the TLS certificate: %w", err)
+	}
+	var clientCAs *x509.CertPool
+	if r.opts.ClientCAFile != "" {
+		if clientCAs, err = readCertPool(r.opts.ClientCAFile); err != nil {
+			return err
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert, r.clientCAs, r.modTimes = &cert, clientCAs, modTimes
+	return nil
+}
+
+// changed returns true if a file was modified since it was read
+func (r *Reloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, name := range r.files() {
+		info, err := os.Stat(name)
+		if err != nil || !info.ModTime().Equal(r.modTimes[name]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch reloads the files every interval when they changed, until ctx is done
+// Certificate managers replace the files one after the other so a failed reload is retried.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				log.Errorf("Failed to reload the TLS certificate, keeping the previous one: %s", err)
+				continue
+			}
+			log.Info("Reloaded the TLS certificate.")
+		}
+	}
+}
+
+// Config returns a server configuration that uses the latest certificate and client CAs
+func (r *Reloader) Config() *tls.Config {
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	base.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return r.cert, nil
+	}
+	if r.opts.ClientCAFile != "" {
+		base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := base.Clone()
+			cfg.GetConfigForClient = nil
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			cfg.ClientCAs = r.clientCAs
+			return cfg, nil
+		}
+	}
+	return base
+}
+
+// ClientOptions are the files of the CAs of the server certificate and of the client certificate
+type ClientOptions struct {
+	// CAFile replaces the system CAs
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+// NewClientConfig reads the files of the options into a client configuration
+func NewClientConfig(opts ClientOptions) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.CAFile != "" {
+		pool, err := readCertPool(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// readCertPool reads the PEM encoded certificates of a file
+func readCertPool(name string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificates in %s", name)
+	}
+	return pool, nil
+}
@@ -0,0 +1,171 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testCert is a certificate and its key, signed by parent or self-signed when parent is nil
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCert(t *testing.T, name string, parent *testCert, isCA bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	must(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	must(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	must(t, err)
+	cert, err := x509.ParseCertificate(der)
+	must(t, err)
+	return &testCert{cert: cert, key: key}
+}
+
+// write writes the PEM certificate and key into dir, returning their paths
+func (c *testCert) write(t *testing.T, dir string, name string) (string, string) {
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	must(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600))
+	der, err := x509.MarshalECPrivateKey(c.key)
+	must(t, err)
+	must(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600))
+	return certFile, keyFile
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serve starts an HTTPS server with the configuration of the reloader and returns its URL
+// httptest.Server isn't used as it adds its own certificate to the configuration.
+func serve(t *testing.T, r *Reloader) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	must(t, err)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})}
+	go srv.Serve(tls.NewListener(l, r.Config()))
+	t.Cleanup(func() { srv.Close() })
+	return "https://" + l.Addr().String()
+}
+
+// peerSerial returns the serial number of the certificate served at url
+func peerSerial(t *testing.T, client *http.Client, url string) *big.Int {
+	res, err := client.Get(url)
+	must(t, err)
+	defer res.Body.Close()
+	return res.TLS.PeerCertificates[0].SerialNumber
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "fibo CA", nil, true)
+	caFile, _ := ca.write(t, dir, "ca")
+	first := newTestCert(t, "localhost", ca, false)
+	certFile, keyFile := first.write(t, dir, "server")
+
+	r, err := NewReloader(ServerOptions{CertFile: certFile, KeyFile: keyFile})
+	must(t, err)
+	url := serve(t, r)
+	cfg, err := NewClientConfig(ClientOptions{CAFile: caFile})
+	must(t, err)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg, DisableKeepAlives: true}}
+	assert.Equal(t, first.cert.SerialNumber, peerSerial(t, client, url))
+
+	// An invalid key keeps the previous certificate
+	must(t, os.WriteFile(keyFile, []byte("garbage"), 0600))
+	assert.Error(t, r.Reload())
+	assert.Equal(t, first.cert.SerialNumber, peerSerial(t, client, url))
+
+	second := newTestCert(t, "localhost", ca, false)
+	second.write(t, dir, "server")
+	later := time.Now().Add(time.Minute)
+	must(t, os.Chtimes(certFile, later, later))
+	must(t, os.Chtimes(keyFile, later, later))
+	assert.True(t, r.changed())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return !r.changed() }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, second.cert.SerialNumber, peerSerial(t, client, url))
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "fibo CA", nil, true)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := newTestCert(t, "localhost", ca, false).write(t, dir, "server")
+	clientCertFile, clientKeyFile := newTestCert(t, "ci", ca, false).write(t, dir, "client")
+	otherCertFile, otherKeyFile := newTestCert(t, "ci", newTestCert(t, "other CA", nil, true), false).write(t, dir, "other")
+
+	r, err := NewReloader(ServerOptions{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile})
+	must(t, err)
+	url := serve(t, r)
+
+	get := func(opts ClientOptions) error {
+		opts.CAFile = caFile
+		cfg, err := NewClientConfig(opts)
+		must(t, err)
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+		res, err := client.Get(url)
+		if err == nil {
+			res.Body.Close()
+		}
+		return err
+	}
+	assert.Error(t, get(ClientOptions{}))
+	assert.Error(t, get(ClientOptions{CertFile: otherCertFile, KeyFile: otherKeyFile}))
+	assert.NoError(t, get(ClientOptions{CertFile: clientCertFile, KeyFile: clientKeyFile}))
+}
+
+func TestNewClientConfig(t *testing.T) {
+	cfg, err := NewClientConfig(ClientOptions{})
+	assert.NoError(t, err)
+	assert.Nil(t, cfg.RootCAs)
+	assert.Equal(t, uint16(tls.VersionTLS12), cfg.MinVersion)
+
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.pem")
+	must(t, os.WriteFile(empty, nil, 0600))
+	_, err = NewClientConfig(ClientOptions{CAFile: empty})
+	assert.Error(t, err)
+	_, err = NewClientConfig(ClientOptions{CertFile: filepath.Join(dir, "missing.crt")})
+	assert.Error(t, err)
+	_, err = NewReloader(ServerOptions{CertFile: filepath.Join(dir, "missing.crt")})
+	assert.Error(t, err)
+}
@@ -0,0 +1,110 @@
+// Exports OpenTelemetry traces of the server
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/programmablemike/fibo/internal/route"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ServiceName identifies the server in the exported traces
+const ServiceName = "fibo"
+
+// Exporter is where the spans are sent
+type Exporter string
+
+const (
+	// ExporterNone drops the spans, the trace context of callers is still propagated
+	ExporterNone Exporter = "none"
+	// ExporterStdout prints the spans as JSON, for local use
+	ExporterStdout Exporter = "stdout"
+	// ExporterOTLP sends the spans to an OpenTelemetry collector over gRPC
+	ExporterOTLP Exporter = "otlp"
+)
+
+// ParseExporter converts a configuration string into an Exporter
+func ParseExporter(v string) (Exporter, error) {
+	switch e := Exporter(v); e {
+	case ExporterNone, ExporterStdout, ExporterOTLP:
+		return e, nil
+	default:
+		return "", fmt.Errorf("invalid trace exporter %q (expected none, stdout or otlp)", v)
+	}
+}
+
+// Options configures the export of the spans
+type Options struct {
+	Exporter Exporter
+	// Endpoint is the host:port of the OTLP collector, the OTEL_EXPORTER_OTLP_ENDPOINT environment
+	// variable or localhost:4317 when it's empty
+	Endpoint string
+	// Insecure sends the spans to the OTLP collector without TLS
+	Insecure bool
+	// SampleRatio is the fraction of the traces started by the server that are sampled, the
+	// decision of a caller that propagates its trace context is always followed
+	SampleRatio float64
+}
+
+// Setup installs the global tracer provider and the W3C trace context propagator
+// The returned function flushes the spans that haven't been exported yet.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch opts.Exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	case ExporterOTLP:
+		var clientOpts []otlptracegrpc.Option
+		if opts.Endpoint != "" {
+			clientOpts = append(clientOpts, otlptracegrpc.WithEndpoint(opts.Endpoint))
+		}
+		if opts.Insecure {
+			clientOpts = append(clientOpts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err = otlptracegrpc.New(ctx, clientOpts...)
+	default:
+		return nil, fmt.Errorf("invalid trace exporter %q", opts.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the %s trace exporter: %w", opts.Exporter, err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Middleware starts a span for every request to the routes of a mux.Router
+// The span continues the trace of the caller when the request carries a W3C traceparent header,
+// and is named after the route template so that the ordinals in the paths don't name a span each.
+func Middleware(next http.Handler) http.Handler {
+	named := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trace.SpanFromContext(r.Context()).SetAttributes(semconv.HTTPRouteKey.String(route.Template(r)))
+		next.ServeHTTP(w, r)
+	})
+	return otelhttp.NewHandler(named, "", otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		return r.Method + " " + route.Template(r)
+	}))
+}